@@ -14,6 +14,7 @@ import (
 	"fmt"
 	"net/http"
 	"os"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
@@ -57,6 +58,20 @@ type (
 		MergeXrayTraces bool
 		// HTTPClientTimeout specifies a time limit for requests to the API. It defaults to 5s.
 		HTTPClientTimeout time.Duration
+		// MetricsCompression is the compression of the metrics sent to the API: "gzip", "deflate", "zstd" or "none". If
+		// empty, this value is read from the 'DD_METRICS_COMPRESSION' environment variable, or if that is empty defaults
+		// to "deflate". Only series metrics sent to the v2 series endpoint are compressed with zstd, the others use
+		// "deflate" instead. Metrics are sent uncompressed if the API rejects the compression.
+		MetricsCompression string
+		// MetricsTagCardinalityLimit is the number of distinct values a tag key can have for a metric. Tags with more
+		// values are dropped, or rewritten as "key:overflow", and a warning is logged once per metric. If zero, this
+		// value is read from the 'DD_METRICS_TAG_CARDINALITY_LIMIT' environment variable, or if that is empty tag values
+		// aren't limited. A negative value turns the limit off regardless of the environment variable.
+		MetricsTagCardinalityLimit int
+		// MetricsTagCardinalityOverflow is what happens to the tags over the cardinality limit: "drop" or "rewrite". If
+		// empty, this value is read from the 'DD_METRICS_TAG_CARDINALITY_OVERFLOW' environment variable, or if that is
+		// empty defaults to "drop".
+		MetricsTagCardinalityOverflow string
 		// CircuitBreakerInterval is the cyclic period of the closed state
 		// for the CircuitBreaker to clear the internal Counts.
 		// default: 30s
@@ -75,8 +90,32 @@ type (
 		// TraceContextExtractor is the function that extracts a root/parent trace context from the Lambda event body.
 		// See trace.DefaultTraceExtractor for an example.
 		TraceContextExtractor trace.ContextExtractor
+		// PropagationStyleExtract lists the propagation styles ("datadog", "tracecontext", "b3multi", "b3 single header"
+		// or "none") read from incoming events, in order of precedence. If empty, this value is read from the
+		// 'DD_TRACE_PROPAGATION_STYLE_EXTRACT' environment variable, or if that is empty defaults to "datadog,tracecontext".
+		PropagationStyleExtract []string
+		// CaptureLambdaPayload tags the function execution span with the invocation event and the handler response,
+		// as `function.request.*` and `function.response.*` tags. If false, this value is read from the
+		// 'DD_TRACE_CAPTURE_LAMBDA_PAYLOAD' environment variable.
+		CaptureLambdaPayload bool
+		// CaptureLambdaPayloadMaxDepth is the depth past which captured payloads are tagged as JSON strings. If zero,
+		// this value is read from the 'DD_CAPTURE_LAMBDA_PAYLOAD_MAX_DEPTH' environment variable, or if that is empty
+		// defaults to 10.
+		CaptureLambdaPayloadMaxDepth int
+		// CaptureLambdaPayloadRedactedKeys lists the payload fields whose values are replaced by "redacted", in addition
+		// to common secrets such as passwords, tokens and authorization headers.
+		CaptureLambdaPayloadRedactedKeys []string
+		// FlushDeadline is how long before the invocation deadline a handler that is still running is reported as timed
+		// out, and traces and metrics are flushed. If zero, this value is read from the
+		// 'DD_APM_FLUSH_DEADLINE_MILLISECONDS' environment variable, or if that is empty defaults to 100ms. A negative
+		// value disables timeout detection.
+		FlushDeadline time.Duration
 		// TracerOptions are additional options passed to the tracer.
 		TracerOptions []tracer.StartOption
+		// Tags are added to custom and enhanced metrics, and to the tracer's global tags, as "key:value" strings.
+		// They are added to the tags read from the 'DD_TAGS', 'DD_ENV', 'DD_SERVICE' and 'DD_VERSION' environment
+		// variables, and override the ones with the same key.
+		Tags []string
 	}
 )
 
@@ -99,6 +138,35 @@ const (
 	UniversalInstrumentation = "DD_UNIVERSAL_INSTRUMENTATION"
 	// Initialize otel tracer provider if enabled
 	OtelTracerEnabled = "DD_TRACE_OTEL_ENABLED"
+	// PropagationStyleExtractEnvVar is the environment variable that lists the propagation styles read from incoming events.
+	PropagationStyleExtractEnvVar = "DD_TRACE_PROPAGATION_STYLE_EXTRACT"
+	// PropagationStyleEnvVar is the environment variable used for the propagation styles when DD_TRACE_PROPAGATION_STYLE_EXTRACT is not set.
+	PropagationStyleEnvVar = "DD_TRACE_PROPAGATION_STYLE"
+	// ColdStartTracingEnvVar is the environment variable that enables the cold start span. Defaults to true.
+	ColdStartTracingEnvVar = "DD_COLD_START_TRACING"
+	// CaptureLambdaPayloadEnvVar is the environment variable that enables capturing the invocation event and response.
+	CaptureLambdaPayloadEnvVar = "DD_TRACE_CAPTURE_LAMBDA_PAYLOAD"
+	// CaptureLambdaPayloadMaxDepthEnvVar is the environment variable that sets the max depth of captured payloads.
+	CaptureLambdaPayloadMaxDepthEnvVar = "DD_CAPTURE_LAMBDA_PAYLOAD_MAX_DEPTH"
+	// FlushDeadlineEnvVar is the environment variable that sets how long before the invocation deadline, in
+	// milliseconds, timeouts are reported.
+	FlushDeadlineEnvVar = "DD_APM_FLUSH_DEADLINE_MILLISECONDS"
+	// MetricsCompressionEnvVar is the environment variable that sets the compression of the metrics sent to the API.
+	MetricsCompressionEnvVar = "DD_METRICS_COMPRESSION"
+	// MetricsTagCardinalityLimitEnvVar is the environment variable that sets the number of distinct values a tag key
+	// can have for a metric.
+	MetricsTagCardinalityLimitEnvVar = "DD_METRICS_TAG_CARDINALITY_LIMIT"
+	// MetricsTagCardinalityOverflowEnvVar is the environment variable that sets what happens to the tags over the
+	// cardinality limit.
+	MetricsTagCardinalityOverflowEnvVar = "DD_METRICS_TAG_CARDINALITY_OVERFLOW"
+	// EnvironmentEnvVar is the environment variable that sets the env tag of all telemetry.
+	EnvironmentEnvVar = "DD_ENV"
+	// ServiceEnvVar is the environment variable that sets the service tag of all telemetry.
+	ServiceEnvVar = "DD_SERVICE"
+	// VersionEnvVar is the environment variable that sets the version tag of all telemetry.
+	VersionEnvVar = "DD_VERSION"
+	// TagsEnvVar is the environment variable that lists tags added to all telemetry, separated by commas or spaces.
+	TagsEnvVar = "DD_TAGS"
 	// FIPSModeEnvVar is the environment variable that determines whether to enable FIPS mode.
 	// Defaults to true in GovCloud regions and false otherwise.
 	FIPSModeEnvVar = "DD_LAMBDA_FIPS_MODE"
@@ -129,7 +197,7 @@ const (
 func WrapLambdaHandlerInterface(handler lambda.Handler, cfg *Config) lambda.Handler {
 	setupAppSec()
 	listeners := initializeListeners(cfg)
-	return wrapper.WrapHandlerInterfaceWithListeners(handler, listeners...)
+	return wrapper.WrapHandlerInterfaceWithListeners(handler, cfg.toWrapperOptions(), listeners...)
 }
 
 // WrapFunction is used to instrument your lambda functions.
@@ -137,7 +205,16 @@ func WrapLambdaHandlerInterface(handler lambda.Handler, cfg *Config) lambda.Hand
 func WrapFunction(handler interface{}, cfg *Config) interface{} {
 	setupAppSec()
 	listeners := initializeListeners(cfg)
-	return wrapper.WrapHandlerWithListeners(handler, listeners...)
+	return wrapper.WrapHandlerWithListeners(handler, cfg.toWrapperOptions(), listeners...)
+}
+
+// Wrap is used to instrument your lambda functions. Unlike WrapFunction, the handler signature is checked at
+// compile time, and the handler is called without reflection.
+// It returns a modified handler that can be passed directly to the lambda.Start function from aws-lambda-go.
+func Wrap[TIn any, TOut any](handler func(context.Context, TIn) (TOut, error), cfg *Config) func(context.Context, json.RawMessage) (TOut, error) {
+	setupAppSec()
+	listeners := initializeListeners(cfg)
+	return wrapper.WrapTypedHandlerWithListeners(handler, cfg.toWrapperOptions(), listeners...)
 }
 
 // WrapHandler is used to instrument your lambda functions.
@@ -147,6 +224,20 @@ func WrapHandler(handler interface{}, cfg *Config) interface{} {
 	return WrapFunction(handler, cfg)
 }
 
+// TraceInit times a step of the function initialization, such as loading configuration or creating SDK clients,
+// and returns the function to call when the step completes. Steps are reported as children of the
+// `aws.lambda.load` span of the first invocation.
+//
+//	func main() {
+//		done := ddlambda.TraceInit("load-config")
+//		cfg := loadConfig()
+//		done()
+//		lambda.Start(ddlambda.WrapFunction(handler, nil))
+//	}
+func TraceInit(name string) func() {
+	return trace.StartInitStep(name)
+}
+
 // GetTraceHeaders returns a map containing Datadog trace headers that reflect the
 // current X-Ray subsegment.
 // Deprecated: use native Datadog tracing instead.
@@ -165,10 +256,11 @@ func AddTraceHeaders(ctx context.Context, req *http.Request) {
 	}
 }
 
-// GetContext retrieves the last created lambda context.
-// Only use this if you aren't manually passing context through your call hierarchy.
+// GetContext retrieves the context of the most recently started invocation that is still running.
+// Only use this if you aren't manually passing context through your call hierarchy. When invocations run
+// concurrently, it can't tell which one the caller belongs to: pass the handler context to MetricWithContext instead.
 func GetContext() context.Context {
-	return wrapper.CurrentContext
+	return wrapper.CurrentContext()
 }
 
 // Distribution sends a distribution metric to Datadog
@@ -184,20 +276,128 @@ func Metric(metric string, value float64, tags ...string) {
 
 // MetricWithTimestamp sends a distribution metric to DataDog with a custom timestamp
 func MetricWithTimestamp(metric string, value float64, timestamp time.Time, tags ...string) {
-	ctx := GetContext()
+	MetricWithContextAndTimestamp(GetContext(), metric, value, timestamp, tags...)
+}
+
+// MetricWithContext sends a distribution metric to DataDog, as part of the invocation ctx belongs to
+func MetricWithContext(ctx context.Context, metric string, value float64, tags ...string) {
+	MetricWithContextAndTimestamp(ctx, metric, value, time.Now(), tags...)
+}
+
+// MetricWithContextAndTimestamp sends a distribution metric to DataDog with a custom timestamp, as part of the
+// invocation ctx belongs to
+func MetricWithContextAndTimestamp(ctx context.Context, metric string, value float64, timestamp time.Time, tags ...string) {
+	if listener := getMetricsListener(ctx); listener != nil {
+		listener.AddDistributionMetric(metric, value, timestamp, false, tags...)
+	}
+}
+
+// Gauge sends a gauge metric to DataDog, reporting the last value submitted during each interval
+func Gauge(metric string, value float64, tags ...string) {
+	GaugeWithContext(GetContext(), metric, value, tags...)
+}
+
+// GaugeWithContext sends a gauge metric to DataDog, as part of the invocation ctx belongs to
+func GaugeWithContext(ctx context.Context, metric string, value float64, tags ...string) {
+	GaugeWithContextAndUnit(ctx, metric, value, "", tags...)
+}
+
+// GaugeWithUnit sends a gauge metric to DataDog, whose values are in unit, such as "byte" or "second"
+func GaugeWithUnit(metric string, value float64, unit string, tags ...string) {
+	GaugeWithContextAndUnit(GetContext(), metric, value, unit, tags...)
+}
+
+// GaugeWithContextAndUnit sends a gauge metric to DataDog, whose values are in unit, as part of the invocation ctx
+// belongs to
+func GaugeWithContextAndUnit(ctx context.Context, metric string, value float64, unit string, tags ...string) {
+	if listener := getMetricsListener(ctx); listener != nil {
+		listener.AddGaugeMetric(metric, value, unit, time.Now(), tags...)
+	}
+}
+
+// Count sends a count metric to DataDog, reporting the sum of the values submitted during each interval
+func Count(metric string, value int64, tags ...string) {
+	CountWithContext(GetContext(), metric, value, tags...)
+}
+
+// CountWithContext sends a count metric to DataDog, as part of the invocation ctx belongs to
+func CountWithContext(ctx context.Context, metric string, value int64, tags ...string) {
+	CountWithContextAndUnit(ctx, metric, value, "", tags...)
+}
+
+// CountWithUnit sends a count metric to DataDog, whose values are in unit, such as "request" or "byte"
+func CountWithUnit(metric string, value int64, unit string, tags ...string) {
+	CountWithContextAndUnit(GetContext(), metric, value, unit, tags...)
+}
 
+// CountWithContextAndUnit sends a count metric to DataDog, whose values are in unit, as part of the invocation ctx
+// belongs to
+func CountWithContextAndUnit(ctx context.Context, metric string, value int64, unit string, tags ...string) {
+	if listener := getMetricsListener(ctx); listener != nil {
+		listener.AddCountMetric(metric, value, unit, time.Now(), tags...)
+	}
+}
+
+// Increment adds one to a count metric
+func Increment(metric string, tags ...string) {
+	CountWithContext(GetContext(), metric, 1, tags...)
+}
+
+// IncrementWithContext adds one to a count metric, as part of the invocation ctx belongs to
+func IncrementWithContext(ctx context.Context, metric string, tags ...string) {
+	CountWithContext(ctx, metric, 1, tags...)
+}
+
+// Rate sends a rate metric to DataDog, reporting the sum of the values submitted during each interval per second
+func Rate(metric string, value int64, tags ...string) {
+	RateWithContext(GetContext(), metric, value, tags...)
+}
+
+// RateWithContext sends a rate metric to DataDog, as part of the invocation ctx belongs to
+func RateWithContext(ctx context.Context, metric string, value int64, tags ...string) {
+	RateWithContextAndUnit(ctx, metric, value, "", tags...)
+}
+
+// RateWithUnit sends a rate metric to DataDog, whose values are in unit, such as "request" or "byte"
+func RateWithUnit(metric string, value int64, unit string, tags ...string) {
+	RateWithContextAndUnit(GetContext(), metric, value, unit, tags...)
+}
+
+// RateWithContextAndUnit sends a rate metric to DataDog, whose values are in unit, as part of the invocation ctx
+// belongs to
+func RateWithContextAndUnit(ctx context.Context, metric string, value int64, unit string, tags ...string) {
+	if listener := getMetricsListener(ctx); listener != nil {
+		listener.AddRateMetric(metric, value, unit, time.Now(), tags...)
+	}
+}
+
+// Set sends a set metric to DataDog, reporting the number of unique values submitted during each interval
+func Set(metric string, value string, tags ...string) {
+	SetWithContext(GetContext(), metric, value, tags...)
+}
+
+// SetWithContext sends a set metric to DataDog, as part of the invocation ctx belongs to
+func SetWithContext(ctx context.Context, metric string, value string, tags ...string) {
+	if listener := getMetricsListener(ctx); listener != nil {
+		listener.AddSetMetric(metric, value, time.Now(), tags...)
+	}
+}
+
+// getMetricsListener returns the metrics listener of the invocation ctx belongs to, or nil when the handler wasn't
+// wrapped
+func getMetricsListener(ctx context.Context) *metrics.Listener {
 	if ctx == nil {
 		logger.Debug("no context available, did you wrap your handler?")
-		return
+		return nil
 	}
 
 	listener := metrics.GetListener(ctx)
 
 	if listener == nil {
 		logger.Error(fmt.Errorf("couldn't get metrics listener from current context"))
-		return
+		return nil
 	}
-	listener.AddDistributionMetric(metric, value, timestamp, false, tags...)
+	return listener
 }
 
 // InvokeDryRun is a utility to easily run your lambda for testing
@@ -217,6 +417,7 @@ func (cfg *Config) toTraceConfig() trace.Config {
 		MergeXrayTraces:          false,
 		UniversalInstrumentation: true,
 		OtelTracerEnabled:        false,
+		ColdStartTracing:         true,
 	}
 
 	if cfg != nil {
@@ -224,12 +425,28 @@ func (cfg *Config) toTraceConfig() trace.Config {
 		traceConfig.MergeXrayTraces = cfg.MergeXrayTraces
 		traceConfig.TraceContextExtractor = cfg.TraceContextExtractor
 		traceConfig.TracerOptions = cfg.TracerOptions
+		traceConfig.CapturePayload = cfg.CaptureLambdaPayload
+		traceConfig.CapturePayloadMaxDepth = cfg.CaptureLambdaPayloadMaxDepth
+		traceConfig.CapturePayloadRedactedKeys = cfg.CaptureLambdaPayloadRedactedKeys
+		if len(cfg.PropagationStyleExtract) > 0 {
+			traceConfig.PropagationStyleExtract = trace.ParsePropagationStyles(strings.Join(cfg.PropagationStyleExtract, ","))
+		}
 	}
 
 	if traceConfig.TraceContextExtractor == nil {
 		traceConfig.TraceContextExtractor = trace.DefaultTraceExtractor
 	}
 
+	if traceConfig.PropagationStyleExtract == nil {
+		if styles := os.Getenv(PropagationStyleExtractEnvVar); styles != "" {
+			traceConfig.PropagationStyleExtract = trace.ParsePropagationStyles(styles)
+		} else if styles := os.Getenv(PropagationStyleEnvVar); styles != "" {
+			traceConfig.PropagationStyleExtract = trace.ParsePropagationStyles(styles)
+		} else {
+			traceConfig.PropagationStyleExtract = trace.DefaultPropagationStyleExtract
+		}
+	}
+
 	if tracingEnabled, err := strconv.ParseBool(os.Getenv(DatadogTraceEnabledEnvVar)); err == nil {
 		traceConfig.DDTraceEnabled = tracingEnabled
 		// Only read the OTEL env var if DD tracing is enabled
@@ -248,6 +465,24 @@ func (cfg *Config) toTraceConfig() trace.Config {
 		traceConfig.UniversalInstrumentation = universalInstrumentation
 	}
 
+	if coldStartTracing, err := strconv.ParseBool(os.Getenv(ColdStartTracingEnvVar)); err == nil {
+		traceConfig.ColdStartTracing = coldStartTracing
+	}
+
+	if !traceConfig.CapturePayload {
+		traceConfig.CapturePayload, _ = strconv.ParseBool(os.Getenv(CaptureLambdaPayloadEnvVar))
+	}
+
+	if traceConfig.CapturePayloadMaxDepth == 0 {
+		if maxDepth := os.Getenv(CaptureLambdaPayloadMaxDepthEnvVar); maxDepth != "" {
+			if depth, err := strconv.Atoi(maxDepth); err == nil {
+				traceConfig.CapturePayloadMaxDepth = depth
+			} else {
+				logger.Debug(fmt.Sprintf("could not parse %s: %s", CaptureLambdaPayloadMaxDepthEnvVar, err))
+			}
+		}
+	}
+
 	return traceConfig
 }
 
@@ -256,10 +491,13 @@ func initializeListeners(cfg *Config) []wrapper.HandlerListener {
 	if strings.EqualFold(logLevel, "debug") || (cfg != nil && cfg.DebugLogging) {
 		logger.SetLogLevel(logger.LevelDebug)
 	}
+	globalTags := cfg.toGlobalTags()
 	traceConfig := cfg.toTraceConfig()
+	traceConfig.GlobalTags = globalTags
 	extensionManager := extension.BuildExtensionManager(traceConfig.UniversalInstrumentation)
 	isExtensionRunning := extensionManager.IsExtensionRunning()
 	metricsConfig := cfg.toMetricsConfig(isExtensionRunning)
+	metricsConfig.Tags = globalTags
 
 	// Wrap the handler with listeners that add instrumentation for traces and metrics.
 	tl := trace.MakeListener(traceConfig, extensionManager)
@@ -284,6 +522,27 @@ func (cfg *Config) toMetricsConfig(isExtensionRunning bool) metrics.Config {
 		mc.Site = cfg.Site
 		mc.ShouldUseLogForwarder = cfg.ShouldUseLogForwarder
 		mc.HTTPClientTimeout = cfg.HTTPClientTimeout
+		mc.Compression = cfg.MetricsCompression
+		mc.TagCardinalityLimit = cfg.MetricsTagCardinalityLimit
+		mc.TagCardinalityOverflow = cfg.MetricsTagCardinalityOverflow
+	}
+
+	if mc.Compression == "" {
+		mc.Compression = strings.ToLower(os.Getenv(MetricsCompressionEnvVar))
+	}
+
+	if mc.TagCardinalityLimit == 0 {
+		if limit := os.Getenv(MetricsTagCardinalityLimitEnvVar); limit != "" {
+			if parsedLimit, err := strconv.Atoi(limit); err == nil {
+				mc.TagCardinalityLimit = parsedLimit
+			} else {
+				logger.Debug(fmt.Sprintf("could not parse %s: %s", MetricsTagCardinalityLimitEnvVar, err))
+			}
+		}
+	}
+
+	if mc.TagCardinalityOverflow == "" {
+		mc.TagCardinalityOverflow = strings.ToLower(os.Getenv(MetricsTagCardinalityOverflowEnvVar))
 	}
 
 	if mc.Site == "" {
@@ -292,10 +551,8 @@ func (cfg *Config) toMetricsConfig(isExtensionRunning bool) metrics.Config {
 	if mc.Site == "" {
 		mc.Site = DefaultSite
 	}
-	if strings.HasPrefix(mc.Site, "https://") || strings.HasPrefix(mc.Site, "http://") {
-		mc.Site = fmt.Sprintf("%s/api/v1", mc.Site)
-	} else {
-		mc.Site = fmt.Sprintf("https://api.%s/api/v1", mc.Site)
+	if !strings.HasPrefix(mc.Site, "https://") && !strings.HasPrefix(mc.Site, "http://") {
+		mc.Site = fmt.Sprintf("https://api.%s", mc.Site)
 	}
 
 	if !mc.ShouldUseLogForwarder {
@@ -331,6 +588,84 @@ func (cfg *Config) toMetricsConfig(isExtensionRunning bool) metrics.Config {
 	return mc
 }
 
+// toGlobalTags returns the unified service tags, added to all telemetry. The env, service and version tags read from
+// DD_TAGS are overridden by the ones read from DD_ENV, DD_SERVICE and DD_VERSION. Tags with the same key as one of
+// the tags of cfg are overridden by them. Other tags sharing a key are all kept.
+func (cfg *Config) toGlobalTags() []string {
+	tags := parseTags(os.Getenv(TagsEnvVar))
+	for _, unified := range []struct{ key, envVar string }{
+		{"env", EnvironmentEnvVar},
+		{"service", ServiceEnvVar},
+		{"version", VersionEnvVar},
+	} {
+		if value := strings.TrimSpace(os.Getenv(unified.envVar)); value != "" {
+			tags = replaceTags(tags, fmt.Sprintf("%s:%s", unified.key, value))
+		}
+	}
+	if cfg != nil {
+		var cfgTags []string
+		for _, tag := range cfg.Tags {
+			if tag = strings.TrimSpace(tag); tag != "" {
+				cfgTags = append(cfgTags, tag)
+			}
+		}
+		tags = replaceTags(tags, cfgTags...)
+	}
+	return tags
+}
+
+// parseTags splits a list of tags separated by commas or spaces, as in DD_TAGS, dropping exact duplicates
+func parseTags(value string) []string {
+	return addTags(nil, strings.FieldsFunc(value, func(r rune) bool { return r == ',' || r == ' ' })...)
+}
+
+// addTags appends added to tags, except for the exact duplicates of tags already there
+func addTags(tags []string, added ...string) []string {
+	for _, tag := range added {
+		if !slices.Contains(tags, tag) {
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
+
+// replaceTags appends replacements to tags, dropping all the tags that have the same key as one of them
+func replaceTags(tags []string, replacements ...string) []string {
+	keys := map[string]struct{}{}
+	for _, tag := range replacements {
+		key, _, _ := strings.Cut(tag, ":")
+		keys[key] = struct{}{}
+	}
+	var kept []string
+	for _, tag := range tags {
+		key, _, _ := strings.Cut(tag, ":")
+		if _, replaced := keys[key]; !replaced {
+			kept = append(kept, tag)
+		}
+	}
+	return addTags(kept, replacements...)
+}
+
+func (cfg *Config) toWrapperOptions() wrapper.Options {
+	return wrapper.Options{FlushDeadline: cfg.toFlushDeadline()}
+}
+
+func (cfg *Config) toFlushDeadline() time.Duration {
+	if cfg != nil && cfg.FlushDeadline != 0 {
+		return cfg.FlushDeadline
+	}
+
+	if flushDeadline := os.Getenv(FlushDeadlineEnvVar); flushDeadline != "" {
+		ms, err := strconv.Atoi(flushDeadline)
+		if err == nil {
+			return time.Duration(ms) * time.Millisecond
+		}
+		logger.Debug(fmt.Sprintf("could not parse %s: %s", FlushDeadlineEnvVar, err))
+	}
+
+	return wrapper.DefaultFlushDeadline
+}
+
 func (cfg *Config) calculateFipsMode() bool {
 	if cfg != nil && cfg.FIPSMode != nil {
 		return *cfg.FIPSMode
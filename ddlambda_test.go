@@ -9,13 +9,19 @@ package ddlambda
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
+	"io"
 	"net/http"
 	"net/http/httptest"
 	"os"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
+
+	"github.com/DataDog/datadog-lambda-go/internal/trace"
+	"github.com/DataDog/datadog-lambda-go/internal/wrapper"
 )
 
 func TestInvokeDryRun(t *testing.T) {
@@ -32,6 +38,23 @@ func TestInvokeDryRun(t *testing.T) {
 	assert.True(t, called)
 }
 
+func TestWrap(t *testing.T) {
+	t.Setenv(UniversalInstrumentation, "false")
+	t.Setenv(DatadogTraceEnabledEnvVar, "false")
+
+	type event struct {
+		Name string `json:"name"`
+	}
+	handler := Wrap(func(ctx context.Context, ev event) (string, error) {
+		assert.Equal(t, GetContext(), ctx)
+		return "hello " + ev.Name, nil
+	}, nil)
+
+	result, err := handler(context.Background(), json.RawMessage(`{"name":"world"}`))
+	assert.NoError(t, err)
+	assert.Equal(t, "hello world", result)
+}
+
 func TestMetricsSilentFailWithoutWrapper(t *testing.T) {
 	Metric("my-metric", 100, "my:tag")
 }
@@ -54,6 +77,57 @@ func TestMetricsSubmitWithWrapper(t *testing.T) {
 	assert.True(t, called)
 }
 
+func TestMetricWithContextSubmitWithWrapper(t *testing.T) {
+	called := false
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer server.Close()
+
+	_, err := InvokeDryRun(func(ctx context.Context) {
+		MetricWithContext(ctx, "my-metric", 100, "my:tag")
+	}, &Config{
+		APIKey: "abc-123",
+		Site:   server.URL,
+	})
+	assert.NoError(t, err)
+	assert.True(t, called)
+}
+
+func TestMetricWithContextSilentFailWithoutWrapper(t *testing.T) {
+	MetricWithContext(context.Background(), "my-metric", 100, "my:tag")
+}
+
+func TestSeriesMetricsSubmitWithWrapper(t *testing.T) {
+	var body string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/v2/series" {
+			b, _ := io.ReadAll(r.Body)
+			body = string(b)
+		}
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer server.Close()
+
+	_, err := InvokeDryRun(func(ctx context.Context) {
+		Gauge("my-gauge", 100, "my:tag")
+		Count("my-count", 2, "my:tag")
+		IncrementWithContext(ctx, "my-count", "my:tag")
+		Rate("my-rate", 30, "my:tag")
+		Set("my-set", "user-1", "my:tag")
+	}, &Config{
+		APIKey:             "abc-123",
+		Site:               server.URL,
+		MetricsCompression: "none",
+	})
+	assert.NoError(t, err)
+	assert.Contains(t, body, `"metric":"my_gauge"`)
+	assert.Regexp(t, `"metric":"my_count","type":1,"points":\[\{"timestamp":\d+,"value":3\}\]`, body)
+	assert.Regexp(t, `"metric":"my_rate","type":2,"points":\[\{"timestamp":\d+,"value":2\}\]`, body)
+	assert.Contains(t, body, `"metric":"my_set"`)
+}
+
 func TestToMetricConfigLocalTest(t *testing.T) {
 	testcases := []struct {
 		envs map[string]string
@@ -105,6 +179,93 @@ func TestToMetricConfigLocalTest(t *testing.T) {
 	}
 }
 
+func TestToMetricsConfigCompression(t *testing.T) {
+	assert.Equal(t, "", (&Config{}).toMetricsConfig(true).Compression)
+
+	t.Setenv(MetricsCompressionEnvVar, "ZSTD")
+	assert.Equal(t, "zstd", (&Config{}).toMetricsConfig(true).Compression)
+
+	cfg := Config{MetricsCompression: "gzip"}
+	assert.Equal(t, "gzip", cfg.toMetricsConfig(true).Compression)
+}
+
+func TestToMetricsConfigTagCardinality(t *testing.T) {
+	mc := (&Config{}).toMetricsConfig(true)
+	assert.Equal(t, 0, mc.TagCardinalityLimit)
+	assert.Equal(t, "", mc.TagCardinalityOverflow)
+
+	t.Setenv(MetricsTagCardinalityLimitEnvVar, "20")
+	t.Setenv(MetricsTagCardinalityOverflowEnvVar, "Rewrite")
+	mc = (&Config{}).toMetricsConfig(true)
+	assert.Equal(t, 20, mc.TagCardinalityLimit)
+	assert.Equal(t, "rewrite", mc.TagCardinalityOverflow)
+
+	mc = (&Config{MetricsTagCardinalityLimit: -1, MetricsTagCardinalityOverflow: "drop"}).toMetricsConfig(true)
+	assert.Equal(t, -1, mc.TagCardinalityLimit)
+	assert.Equal(t, "drop", mc.TagCardinalityOverflow)
+}
+
+func TestToGlobalTags(t *testing.T) {
+	testcases := []struct {
+		name     string
+		envs     map[string]string
+		tags     []string
+		expected []string
+	}{
+		{
+			name:     "no tags",
+			expected: nil,
+		},
+		{
+			name:     "unified service tags",
+			envs:     map[string]string{EnvironmentEnvVar: "prod", ServiceEnvVar: "my-service", VersionEnvVar: "1.2.3"},
+			expected: []string{"env:prod", "service:my-service", "version:1.2.3"},
+		},
+		{
+			name:     "DD_TAGS separated by commas and spaces",
+			envs:     map[string]string{TagsEnvVar: "team:a,owner:b c:d"},
+			expected: []string{"team:a", "owner:b", "c:d"},
+		},
+		{
+			name:     "DD_ENV overrides DD_TAGS",
+			envs:     map[string]string{TagsEnvVar: "env:staging,team:a", EnvironmentEnvVar: "prod"},
+			expected: []string{"team:a", "env:prod"},
+		},
+		{
+			name:     "DD_TAGS keeps repeated keys and drops exact duplicates",
+			envs:     map[string]string{TagsEnvVar: "team:a,team:b,team:a"},
+			expected: []string{"team:a", "team:b"},
+		},
+		{
+			name:     "DD_ENV overrides every env tag of DD_TAGS",
+			envs:     map[string]string{TagsEnvVar: "env:staging,team:a,env:dev", EnvironmentEnvVar: "prod"},
+			expected: []string{"team:a", "env:prod"},
+		},
+		{
+			name:     "Config.Tags overrides the environment",
+			envs:     map[string]string{TagsEnvVar: "team:a", ServiceEnvVar: "my-service"},
+			tags:     []string{"service:other-service", "feature:b"},
+			expected: []string{"team:a", "service:other-service", "feature:b"},
+		},
+		{
+			name:     "Config.Tags keeps its repeated keys",
+			envs:     map[string]string{TagsEnvVar: "team:a,owner:b"},
+			tags:     []string{"team:c", "team:d"},
+			expected: []string{"owner:b", "team:c", "team:d"},
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			for _, envVar := range []string{EnvironmentEnvVar, ServiceEnvVar, VersionEnvVar, TagsEnvVar} {
+				t.Setenv(envVar, tc.envs[envVar])
+			}
+			cfg := Config{Tags: tc.tags}
+			assert.Equal(t, tc.expected, cfg.toGlobalTags())
+		})
+	}
+}
+
 func TestCalculateFipsMode(t *testing.T) {
 	// Save original environment to restore later
 	originalRegion := os.Getenv("AWS_REGION")
@@ -203,3 +364,95 @@ func TestCalculateFipsMode(t *testing.T) {
 func boolPtr(b bool) *bool {
 	return &b
 }
+
+func TestToTraceConfigPropagationStyleExtract(t *testing.T) {
+	testCases := []struct {
+		name        string
+		configValue []string
+		extractEnv  string
+		styleEnv    string
+		expected    []trace.PropagationStyle
+	}{
+		{
+			name:     "Default",
+			expected: trace.DefaultPropagationStyleExtract,
+		},
+		{
+			name:       "Extract env var",
+			extractEnv: "b3multi,tracecontext",
+			styleEnv:   "datadog",
+			expected:   []trace.PropagationStyle{trace.PropagationStyleB3Multi, trace.PropagationStyleTraceContext},
+		},
+		{
+			name:     "Fallback env var",
+			styleEnv: "b3 single header",
+			expected: []trace.PropagationStyle{trace.PropagationStyleB3Single},
+		},
+		{
+			name:        "Config takes precedence over env",
+			configValue: []string{"tracecontext", "datadog"},
+			extractEnv:  "b3multi",
+			expected:    []trace.PropagationStyle{trace.PropagationStyleTraceContext, trace.PropagationStyleDatadog},
+		},
+		{
+			name:       "None",
+			extractEnv: "none",
+			expected:   []trace.PropagationStyle{},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Setenv(PropagationStyleExtractEnvVar, tc.extractEnv)
+			t.Setenv(PropagationStyleEnvVar, tc.styleEnv)
+			cfg := Config{PropagationStyleExtract: tc.configValue}
+			assert.Equal(t, tc.expected, cfg.toTraceConfig().PropagationStyleExtract)
+		})
+	}
+}
+
+func TestToTraceConfigCaptureLambdaPayload(t *testing.T) {
+	t.Run("Default", func(t *testing.T) {
+		traceConfig := (&Config{}).toTraceConfig()
+		assert.False(t, traceConfig.CapturePayload)
+		assert.Equal(t, 0, traceConfig.CapturePayloadMaxDepth)
+	})
+
+	t.Run("Env vars", func(t *testing.T) {
+		t.Setenv(CaptureLambdaPayloadEnvVar, "true")
+		t.Setenv(CaptureLambdaPayloadMaxDepthEnvVar, "5")
+		traceConfig := (&Config{}).toTraceConfig()
+		assert.True(t, traceConfig.CapturePayload)
+		assert.Equal(t, 5, traceConfig.CapturePayloadMaxDepth)
+	})
+
+	t.Run("Config takes precedence over env", func(t *testing.T) {
+		t.Setenv(CaptureLambdaPayloadMaxDepthEnvVar, "5")
+		cfg := Config{
+			CaptureLambdaPayload:             true,
+			CaptureLambdaPayloadMaxDepth:     3,
+			CaptureLambdaPayloadRedactedKeys: []string{"ssn"},
+		}
+		traceConfig := cfg.toTraceConfig()
+		assert.True(t, traceConfig.CapturePayload)
+		assert.Equal(t, 3, traceConfig.CapturePayloadMaxDepth)
+		assert.Equal(t, []string{"ssn"}, traceConfig.CapturePayloadRedactedKeys)
+	})
+}
+
+func TestToTraceConfigColdStartTracing(t *testing.T) {
+	assert.True(t, (&Config{}).toTraceConfig().ColdStartTracing)
+
+	t.Setenv(ColdStartTracingEnvVar, "false")
+	assert.False(t, (&Config{}).toTraceConfig().ColdStartTracing)
+}
+
+func TestToFlushDeadline(t *testing.T) {
+	assert.Equal(t, wrapper.DefaultFlushDeadline, (&Config{}).toFlushDeadline())
+
+	t.Setenv(FlushDeadlineEnvVar, "250")
+	assert.Equal(t, 250*time.Millisecond, (*Config)(nil).toFlushDeadline())
+
+	cfg := Config{FlushDeadline: -1}
+	assert.Equal(t, time.Duration(-1), cfg.toFlushDeadline())
+}
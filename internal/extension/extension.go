@@ -13,6 +13,7 @@ import (
 	"context"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
@@ -37,6 +38,7 @@ const (
 	DdSpanId               ddTraceContext = "x-datadog-span-id"
 	DdSamplingPriority     ddTraceContext = "x-datadog-sampling-priority"
 	DdOrigin               ddTraceContext = "x-datadog-origin"
+	DdTags                 ddTraceContext = "x-datadog-tags"
 	DdInvocationError      ddTraceContext = "x-datadog-invocation-error"
 	DdInvocationErrorMsg   ddTraceContext = "x-datadog-invocation-error-msg"
 	DdInvocationErrorType  ddTraceContext = "x-datadog-invocation-error-type"
@@ -46,6 +48,9 @@ const (
 	DdLambdaResponse ddTraceContext = "dd-response"
 
 	lambdaRuntimeAwsRequestIdHeader = "lambda-runtime-aws-request-id"
+
+	// traceIDUpperTag is the propagated tag holding the upper 64 bits of 128-bit trace ids
+	traceIDUpperTag = "_dd.p.tid"
 )
 
 const (
@@ -153,6 +158,10 @@ func (em *ExtensionManager) SendStartInvocationRequest(ctx context.Context, even
 		if samplingPriority != "" {
 			ctx = context.WithValue(ctx, DdSamplingPriority, samplingPriority)
 		}
+		// The upper 64 bits of 128-bit trace ids are sent in the _dd.p.tid propagated tag
+		if tags := response.Header.Get(string(DdTags)); tags != "" {
+			ctx = context.WithValue(ctx, DdTags, tags)
+		}
 	}
 	return ctx
 }
@@ -178,7 +187,7 @@ func (em *ExtensionManager) SendEndInvocationRequest(ctx context.Context, functi
 		req.Header.Set(string(DdInvocationError), "true")
 		req.Header.Set(string(DdInvocationErrorMsg), cfg.Error.Error())
 		req.Header.Set(string(DdInvocationErrorType), reflect.TypeOf(cfg.Error).String())
-		req.Header.Set(string(DdInvocationErrorStack), takeStacktrace(cfg))
+		req.Header.Set(string(DdInvocationErrorStack), errorStacktrace(cfg))
 	}
 
 	// Extract the DD trace context and pass them to the extension via request headers
@@ -196,10 +205,16 @@ func (em *ExtensionManager) SendEndInvocationRequest(ctx context.Context, functi
 		} else {
 			logger.Error(fmt.Errorf("could not get sampling priority from DdSamplingPriority"))
 		}
+		if tags, ok := ctx.Value(DdTags).(string); ok {
+			req.Header.Set(string(DdTags), tags)
+		}
 	} else {
 		spanContext := functionExecutionSpan.Context()
 		req.Header.Set(string(DdTraceId), fmt.Sprint(spanContext.TraceID()))
 		req.Header.Set(string(DdSpanId), fmt.Sprint(spanContext.SpanID()))
+		if traceIDUpper := getTraceIDUpper(spanContext); traceIDUpper != "" {
+			req.Header.Set(string(DdTags), traceIDUpperTag+"="+traceIDUpper)
+		}
 
 		// Try to get sampling priority
 		// Check if the context implements SamplingPriority method
@@ -230,9 +245,39 @@ func (em *ExtensionManager) SendEndInvocationRequest(ctx context.Context, functi
 	}
 }
 
+// getTraceIDUpper returns the upper 64 bits of the trace id of a span context as a hex string,
+// or an empty string if the trace id is only 64 bits long.
+func getTraceIDUpper(spanContext ddtrace.SpanContext) string {
+	w3cContext, ok := spanContext.(ddtrace.SpanContextW3C)
+	if !ok {
+		return ""
+	}
+	traceID := w3cContext.TraceID128()
+	if len(traceID) != 32 || traceID[:16] == "0000000000000000" {
+		return ""
+	}
+	return traceID[:16]
+}
+
 // defaultStackLength specifies the default maximum size of a stack trace.
 const defaultStackLength = 32
 
+// StackTracer is implemented by errors carrying the stack where they occurred, such as handler panics
+type StackTracer interface {
+	Stack() []byte
+}
+
+// errorStacktrace returns the stack carried by the error, if any, or else the current stack.
+func errorStacktrace(opts ddtrace.FinishConfig) string {
+	var st StackTracer
+	if errors.As(opts.Error, &st) {
+		return base64.StdEncoding.EncodeToString(st.Stack())
+	}
+	// +1 to exclude errorStacktrace
+	opts.SkipStackFrames++
+	return takeStacktrace(opts)
+}
+
 // takeStacktrace takes a stack trace of maximum n entries, skipping the first skip entries.
 // If n is 0, up to 20 entries are retrieved.
 func takeStacktrace(opts ddtrace.FinishConfig) string {
@@ -213,6 +213,23 @@ func TestExtensionStartInvokeWithTraceContext(t *testing.T) {
 	assert.Equal(t, mockSamplingPriority, samplingPriority)
 }
 
+func TestExtensionStartInvokeWithTraceIDUpper(t *testing.T) {
+	headers := http.Header{}
+	headers.Set(string(DdTraceId), mockTraceId)
+	headers.Set(string(DdParentId), mockParentId)
+	headers.Set(string(DdTags), "_dd.p.tid=64fe8b2a57d3eff7")
+
+	em := &ExtensionManager{
+		startInvocationUrl: startInvocationUrl,
+		httpClient: &ClientSuccessStartInvoke{
+			headers: headers,
+		},
+	}
+	ctx := em.SendStartInvocationRequest(context.TODO(), []byte{})
+
+	assert.Equal(t, "_dd.p.tid=64fe8b2a57d3eff7", ctx.Value(DdTags))
+}
+
 func TestExtensionStartInvokeWithTraceContextNoParentID(t *testing.T) {
 	headers := http.Header{}
 	headers.Set(string(DdTraceId), mockTraceId)
@@ -391,6 +408,29 @@ func TestExtensionEndInvocationErrorHeaders(t *testing.T) {
 	assert.Nil(t, err)
 	assert.Contains(t, string(data), "github.com/DataDog/datadog-lambda-go")
 	assert.Contains(t, string(data), "TestExtensionEndInvocationErrorHeaders")
+	assert.NotContains(t, string(data), "errorStacktrace")
+}
+
+type mockStackError struct{}
+
+func (mockStackError) Error() string { return "panic: oops" }
+
+func (mockStackError) Stack() []byte { return []byte("goroutine 1 [running]:\nmain.handler()") }
+
+func TestExtensionEndInvocationErrorHeadersWithStack(t *testing.T) {
+	hdr := http.Header{}
+	em := &ExtensionManager{httpClient: capturingClient{hdr: hdr}}
+	span := tracer.StartSpan("aws.lambda")
+	cfg := ddtrace.FinishConfig{Error: mockStackError{}}
+
+	em.SendEndInvocationRequest(context.TODO(), span, cfg)
+
+	assert.Equal(t, hdr.Get("X-Datadog-Invocation-Error"), "true")
+	assert.Equal(t, hdr.Get("X-Datadog-Invocation-Error-Msg"), "panic: oops")
+
+	data, err := base64.StdEncoding.DecodeString(hdr.Get("X-Datadog-Invocation-Error-Stack"))
+	assert.Nil(t, err)
+	assert.Equal(t, "goroutine 1 [running]:\nmain.handler()", string(data))
 }
 
 func TestExtensionEndInvocationErrorHeadersNilError(t *testing.T) {
@@ -428,3 +468,41 @@ func TestExtensionEndInvocationV2AdapterSamplingPriority(t *testing.T) {
 	assert.Equal(t, "789", headers.Get("X-Datadog-Trace-Id"))
 	assert.Equal(t, "101112", headers.Get("X-Datadog-Span-Id"))
 }
+
+type mockSpanContextW3C struct{ mockSpanContextV2Adapter }
+
+func (m mockSpanContextW3C) TraceID128() string { return "64fe8b2a57d3eff70000000000000315" }
+
+func (m mockSpanContextW3C) TraceID128Bytes() [16]byte { return [16]byte{} }
+
+type mockSpanWithW3CContext struct{ ddtrace.Span }
+
+func (m mockSpanWithW3CContext) Context() ddtrace.SpanContext {
+	return mockSpanContextW3C{mockSpanContextV2Adapter{Ctx: mockV2SpanContext{priority: 1, ok: true}}}
+}
+
+func TestExtensionEndInvocationTraceIDUpper(t *testing.T) {
+	headers := http.Header{}
+	em := &ExtensionManager{httpClient: capturingClient{hdr: headers}}
+
+	em.SendEndInvocationRequest(context.Background(), &mockSpanWithW3CContext{}, ddtrace.FinishConfig{})
+	assert.Equal(t, "789", headers.Get("X-Datadog-Trace-Id"))
+	assert.Equal(t, "_dd.p.tid=64fe8b2a57d3eff7", headers.Get("X-Datadog-Tags"))
+
+	// The propagated tags received from the extension are sent back
+	headers = http.Header{}
+	em = &ExtensionManager{httpClient: capturingClient{hdr: headers}}
+	ctx := context.WithValue(context.Background(), DdTraceId, "123")
+	ctx = context.WithValue(ctx, DdSamplingPriority, "1")
+	ctx = context.WithValue(ctx, DdTags, "_dd.p.tid=80f198ee56343ba8")
+	em.SendEndInvocationRequest(ctx, &mockSpan{}, ddtrace.FinishConfig{})
+	assert.Equal(t, "_dd.p.tid=80f198ee56343ba8", headers.Get("X-Datadog-Tags"))
+}
+
+func TestExtensionEndInvocation64BitTraceID(t *testing.T) {
+	headers := http.Header{}
+	em := &ExtensionManager{httpClient: capturingClient{hdr: headers}}
+
+	em.SendEndInvocationRequest(context.Background(), &mockSpanWithV2Adapter{}, ddtrace.FinishConfig{})
+	assert.Equal(t, "", headers.Get("X-Datadog-Tags"))
+}
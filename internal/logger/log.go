@@ -34,25 +34,25 @@ func SetOutput(w io.Writer) {
 	output = w
 }
 
-// Error logs a structured error message to stdout
+// Error logs a structured error message to stdout, with secrets masked
 func Error(err error) {
 	finalMessage := logStructure{
 		Status:  "error",
-		Message: fmt.Sprintf("datadog: %s", err.Error()),
+		Message: fmt.Sprintf("datadog: %s", scrub(err.Error())),
 	}
 	result, _ := json.Marshal(finalMessage)
 
 	log.Println(string(result))
 }
 
-// Debug logs a structured log message to stdout
+// Debug logs a structured log message to stdout, with secrets masked
 func Debug(message string) {
 	if logLevel > LevelDebug {
 		return
 	}
 	finalMessage := logStructure{
 		Status:  "debug",
-		Message: fmt.Sprintf("datadog: %s", message),
+		Message: fmt.Sprintf("datadog: %s", scrub(message)),
 	}
 
 	result, _ := json.Marshal(finalMessage)
@@ -60,14 +60,14 @@ func Debug(message string) {
 	log.Println(string(result))
 }
 
-// Warn logs a structured log message to stdout
+// Warn logs a structured log message to stdout, with secrets masked
 func Warn(message string) {
 	if logLevel > LevelWarn {
 		return
 	}
 	finalMessage := logStructure{
 		Status:  "warning",
-		Message: fmt.Sprintf("datadog: %s", message),
+		Message: fmt.Sprintf("datadog: %s", scrub(message)),
 	}
 
 	result, _ := json.Marshal(finalMessage)
@@ -75,7 +75,7 @@ func Warn(message string) {
 	log.Println(string(result))
 }
 
-// Raw prints a raw message to the logs.
+// Raw prints a raw message to the logs. It is used for metrics read by the log forwarder, so it isn't scrubbed.
 func Raw(message string) {
 	fmt.Fprintln(output, message)
 }
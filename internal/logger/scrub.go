@@ -0,0 +1,87 @@
+/*
+ * Unless explicitly stated otherwise all files in this repository are licensed
+ * under the Apache License Version 2.0.
+ *
+ * This product includes software developed at Datadog (https://www.datadoghq.com/).
+ * Copyright 2021 Datadog, Inc.
+ */
+
+package logger
+
+import (
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// secretMask replaces the secrets found in log messages
+const secretMask = "********"
+
+type scrubber struct {
+	pattern     *regexp.Regexp
+	replacement string
+}
+
+var (
+	// scrubbers mask secrets in log messages, whatever the code logging them
+	scrubbers = []scrubber{
+		// Keys passed as query parameters, headers or JSON fields
+		{
+			pattern:     regexp.MustCompile(`(?i)\b(api_?key|app(?:lication)?_?key|dd-api-key|dd-application-key)(["']?\s*[:=]\s*["']?)[^\s&"',}]+`),
+			replacement: "${1}${2}" + secretMask,
+		},
+		// Passwords, secrets and tokens passed as key/value pairs or JSON fields
+		{
+			pattern:     regexp.MustCompile(`(?i)\b(password|passwd|secret|token)(["']?\s*[:=]\s*["']?)[^\s&"',}]+`),
+			replacement: "${1}${2}" + secretMask,
+		},
+		// Authorization headers
+		{
+			pattern:     regexp.MustCompile(`(?i)\b(bearer|basic)\s+[A-Za-z0-9\-._~+/]+=*`),
+			replacement: "${1} " + secretMask,
+		},
+		// Datadog application keys, keeping their last characters to help tell them apart
+		{
+			pattern:     regexp.MustCompile(`\b[a-fA-F0-9]{35}([a-fA-F0-9]{5})\b`),
+			replacement: strings.Repeat("*", 35) + "${1}",
+		},
+		// Datadog API keys, keeping their last characters to help tell them apart
+		{
+			pattern:     regexp.MustCompile(`\b[a-fA-F0-9]{27}([a-fA-F0-9]{5})\b`),
+			replacement: strings.Repeat("*", 27) + "${1}",
+		},
+	}
+
+	secrets      []string
+	secretsMutex sync.RWMutex
+)
+
+// AddSecret registers a value that is masked wherever it appears in log messages, such as an API key whose format
+// the scrubbers wouldn't recognize
+func AddSecret(secret string) {
+	if secret == "" {
+		return
+	}
+	secretsMutex.Lock()
+	defer secretsMutex.Unlock()
+	for _, s := range secrets {
+		if s == secret {
+			return
+		}
+	}
+	secrets = append(secrets, secret)
+}
+
+// scrub masks the secrets in message
+func scrub(message string) string {
+	secretsMutex.RLock()
+	for _, secret := range secrets {
+		message = strings.ReplaceAll(message, secret, secretMask)
+	}
+	secretsMutex.RUnlock()
+
+	for _, s := range scrubbers {
+		message = s.pattern.ReplaceAllString(message, s.replacement)
+	}
+	return message
+}
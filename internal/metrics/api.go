@@ -12,9 +12,11 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"sync"
 	"time"
 
 	"github.com/DataDog/datadog-lambda-go/internal/logger"
@@ -23,16 +25,24 @@ import (
 type (
 	// Client sends metrics to Datadog
 	Client interface {
-		SendMetrics(metrics []APIMetric) error
+		SendMetrics(ctx context.Context, metrics []APIMetric) error
 	}
 
 	// APIClient send metrics to Datadog, via the Datadog API
 	APIClient struct {
 		apiKey            string
 		apiKeyDecryptChan <-chan string
+		apiKeyMutex       sync.Mutex
 		baseAPIURL        string
 		httpClient        *http.Client
-		context           context.Context
+		// compression is the content encoding of payloads. It falls back to CompressionNone if the intake rejects it.
+		compression      string
+		compressionMutex sync.RWMutex
+		// seriesAPIVersion is the version of the series endpoint. It falls back to v1 if the v2 endpoint isn't found.
+		seriesAPIVersion           int
+		seriesAPIVersionMutex      sync.RWMutex
+		maxPayloadSize             int
+		maxUncompressedPayloadSize int
 	}
 
 	// APIClientOptions contains instantiation options from creating an APIClient.
@@ -42,24 +52,63 @@ type (
 		kmsAPIKey         string
 		decrypter         Decrypter
 		httpClientTimeout time.Duration
+		compression       string
+		seriesAPIVersion  int
 	}
 
 	postMetricsModel struct {
 		Series []APIMetric `json:"series"`
 	}
+
+	// payloadChunk is a part of a batch of metrics that fits within the payload size limit
+	payloadChunk struct {
+		url         string
+		contentType string
+		encoding    string
+		metrics     []APIMetric
+		marshal     func([]APIMetric) ([]byte, error)
+		content     []byte
+		err         error
+	}
+
+	// chunkError reports that a chunk of a batch of metrics couldn't be sent, along with the metrics it held
+	chunkError struct {
+		url     string
+		index   int
+		total   int
+		metrics []APIMetric
+		err     error
+	}
 )
 
 // MakeAPIClient creates a new API client with the given api and app keys
-func MakeAPIClient(ctx context.Context, options APIClientOptions) *APIClient {
+func MakeAPIClient(options APIClientOptions) *APIClient {
 	httpClient := &http.Client{
 		Timeout: options.httpClientTimeout,
 	}
+	compression := options.compression
+	if compression == "" {
+		compression = DefaultCompression
+	}
+	if !isSupportedCompression(compression) {
+		logger.Error(fmt.Errorf("unsupported metrics compression %s, using %s", compression, DefaultCompression))
+		compression = DefaultCompression
+	}
+	seriesAPIVersion := options.seriesAPIVersion
+	if seriesAPIVersion != seriesAPIV1 {
+		seriesAPIVersion = seriesAPIV2
+	}
 	client := &APIClient{
-		apiKey:     options.apiKey,
-		baseAPIURL: options.baseAPIURL,
-		httpClient: httpClient,
-		context:    ctx,
+		apiKey:                     options.apiKey,
+		baseAPIURL:                 options.baseAPIURL,
+		httpClient:                 httpClient,
+		compression:                compression,
+		seriesAPIVersion:           seriesAPIVersion,
+		maxPayloadSize:             maxPayloadSize,
+		maxUncompressedPayloadSize: maxUncompressedPayloadSize,
 	}
+	// The key is masked in the logs even when it doesn't look like a Datadog API key
+	logger.AddSecret(options.apiKey)
 	if len(options.apiKey) == 0 && len(options.kmsAPIKey) != 0 {
 		client.apiKeyDecryptChan = client.decryptAPIKey(options.decrypter, options.kmsAPIKey)
 	}
@@ -67,33 +116,148 @@ func MakeAPIClient(ctx context.Context, options APIClientOptions) *APIClient {
 	return client
 }
 
-// SendMetrics posts a batch metrics payload to the Datadog API
-func (cl *APIClient) SendMetrics(metrics []APIMetric) error {
+// SendMetrics posts a batch metrics payload to the Datadog API. The batch is split into chunks that fit within the
+// payload size limit of the intake, which are sent concurrently. The requests are cancelled with ctx, typically the
+// context of the invocation, and the failure of each chunk is reported as a separate chunkError.
+func (cl *APIClient) SendMetrics(ctx context.Context, metrics []APIMetric) error {
+	// Distributions are posted as sketches to the sketches intake, and other metric types to the series endpoint
+	var distributions, series []APIMetric
+	for _, metric := range metrics {
+		if metric.MetricType == DistributionType {
+			distributions = append(distributions, metric)
+		} else {
+			series = append(series, metric)
+		}
+	}
 
-	// If the api key was provided as a kms key, wait for it to finish decrypting
-	if cl.apiKeyDecryptChan != nil {
-		cl.apiKey = <-cl.apiKeyDecryptChan
-		cl.apiKeyDecryptChan = nil
+	var chunks []payloadChunk
+	if len(distributions) > 0 {
+		chunks = append(chunks, cl.splitPayload(cl.makeRoute(sketchesRoute), protobufContentType, distributions, marshalSketchPayload)...)
+	}
+	if len(series) > 0 {
+		chunks = append(chunks, cl.splitSeries(series)...)
+	}
+
+	errs := make([]error, len(chunks))
+	var waitGroup sync.WaitGroup
+	for i, chunk := range chunks {
+		if chunk.err != nil {
+			errs[i] = &chunkError{url: chunk.url, index: i, total: len(chunks), metrics: chunk.metrics, err: chunk.err}
+			continue
+		}
+		waitGroup.Add(1)
+		go func(i int, chunk payloadChunk) {
+			defer waitGroup.Done()
+			if err := cl.sendChunk(ctx, chunk); err != nil {
+				errs[i] = &chunkError{url: chunk.url, index: i, total: len(chunks), metrics: chunk.metrics, err: err}
+			}
+		}(i, chunk)
+	}
+	waitGroup.Wait()
+	return errors.Join(errs...)
+}
+
+// sendChunk posts a chunk of a batch. If the intake rejects the compression of the chunk, compression is turned off
+// and the metrics of the chunk are sent again uncompressed. If the v2 series endpoint isn't found, series metrics are
+// sent again to the v1 endpoint.
+func (cl *APIClient) sendChunk(ctx context.Context, chunk payloadChunk) error {
+	err := cl.post(ctx, chunk.url, chunk.contentType, chunk.encoding, chunk.content)
+
+	var resent []payloadChunk
+	switch {
+	case errors.Is(err, errUnsupportedEncoding):
+		cl.disableCompression(chunk.encoding)
+		resent = cl.splitPayload(chunk.url, chunk.contentType, chunk.metrics, chunk.marshal)
+	case errors.Is(err, errEndpointNotFound) && chunk.url == cl.routeURL(seriesV2Route):
+		cl.disableSeriesV2()
+		resent = cl.splitSeries(chunk.metrics)
+	default:
+		return err
 	}
 
-	content, err := marshalAPIMetricsModel(metrics)
+	var errs []error
+	for _, resentChunk := range resent {
+		if resentChunk.err != nil {
+			errs = append(errs, resentChunk.err)
+			continue
+		}
+		if err := cl.sendChunk(ctx, resentChunk); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// splitSeries splits series metrics into chunks for the current version of the series endpoint
+func (cl *APIClient) splitSeries(metrics []APIMetric) []payloadChunk {
+	if cl.getSeriesAPIVersion() == seriesAPIV1 {
+		return cl.splitPayload(cl.makeRoute(seriesV1Route), jsonContentType, metrics, marshalAPIMetricsModel)
+	}
+	return cl.splitPayload(cl.makeRoute(seriesV2Route), jsonContentType, metrics, marshalSeriesV2Model)
+}
+
+// splitPayload marshals and compresses metrics into chunks that each fit within the payload size limits, halving the
+// batch until they do. A single metric that is too large on its own is returned as a chunk with an error.
+func (cl *APIClient) splitPayload(url string, contentType string, metrics []APIMetric, marshal func([]APIMetric) ([]byte, error)) []payloadChunk {
+	chunk := payloadChunk{url: url, contentType: contentType, metrics: metrics, marshal: marshal}
+	content, err := marshal(metrics)
 	if err != nil {
-		return fmt.Errorf("Couldn't marshal metrics model: %v", err)
+		chunk.err = fmt.Errorf("Couldn't marshal metrics model: %v", err)
+		return []payloadChunk{chunk}
+	}
+
+	maxSize, maxUncompressedSize := cl.payloadSizeLimits(url)
+	size := len(content)
+	if size <= maxUncompressedSize {
+		chunk.encoding = cl.compressionFor(url)
+		chunk.content, err = compress(chunk.encoding, content)
+		if err != nil {
+			logger.Debug(fmt.Sprintf("could not compress payload with %s, sending it uncompressed: %v", chunk.encoding, err))
+			chunk.encoding, chunk.content = CompressionNone, content
+		}
+		size = len(chunk.content)
+		if size <= maxSize {
+			if contentType == jsonContentType {
+				logger.Debug(fmt.Sprintf("Sending payload with body %s", content))
+			} else {
+				logger.Debug(fmt.Sprintf("Sending payload of %d bytes", len(content)))
+			}
+			return []payloadChunk{chunk}
+		}
+	}
+	if len(metrics) == 1 {
+		chunk.content = nil
+		chunk.err = fmt.Errorf("metric %s is %d bytes, over the payload size limit", metrics[0].Name, size)
+		return []payloadChunk{chunk}
 	}
-	body := bytes.NewBuffer(content)
 
-	// For the moment we only support distribution metrics.
-	// Other metric types use the "series" endpoint, which takes an identical payload.
-	req, err := http.NewRequest("POST", cl.makeRoute("distribution_points"), body)
+	half := len(metrics) / 2
+	logger.Debug(fmt.Sprintf("payload of %d bytes is over the size limit, splitting it", size))
+	return append(cl.splitPayload(url, contentType, metrics[:half], marshal), cl.splitPayload(url, contentType, metrics[half:], marshal)...)
+}
+
+// payloadSizeLimits returns the compressed and uncompressed payload size limits of the intake at url. The v2 series
+// intake accepts smaller payloads than the others.
+func (cl *APIClient) payloadSizeLimits(url string) (int, int) {
+	if url == cl.routeURL(seriesV2Route) {
+		return min(cl.maxPayloadSize, maxSeriesV2PayloadSize), min(cl.maxUncompressedPayloadSize, maxSeriesV2UncompressedPayloadSize)
+	}
+	return cl.maxPayloadSize, cl.maxUncompressedPayloadSize
+}
+
+func (cl *APIClient) post(ctx context.Context, url string, contentType string, encoding string, content []byte) error {
+	req, err := http.NewRequest("POST", url, bytes.NewBuffer(content))
 	if err != nil {
 		return fmt.Errorf("Couldn't create send metrics request:%v", err)
 	}
-	req = req.WithContext(cl.context)
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", contentType)
+	if encoding != CompressionNone {
+		req.Header.Set("Content-Encoding", contentEncoding(encoding))
+	}
 
 	defer req.Body.Close()
 
-	logger.Debug(fmt.Sprintf("Sending payload with body %s", content))
-
 	cl.addAPICredentials(req)
 
 	resp, err := cl.httpClient.Do(req)
@@ -105,19 +269,70 @@ func (cl *APIClient) SendMetrics(metrics []APIMetric) error {
 
 	if resp.StatusCode < 200 || resp.StatusCode > 299 {
 		if resp.StatusCode == 403 {
-			logger.Debug(fmt.Sprintf("authorization failed with api key of length %d characters", len(cl.apiKey)))
+			logger.Debug(fmt.Sprintf("authorization failed with api key of length %d characters", len(cl.getAPIKey())))
 		}
 		bodyBytes, err := io.ReadAll(resp.Body)
 		body := ""
 		if err == nil {
 			body = string(bodyBytes)
 		}
+		// Intakes reject an encoding they don't support either as unsupported or as a bad request
+		if (resp.StatusCode == http.StatusUnsupportedMediaType || resp.StatusCode == http.StatusBadRequest) && encoding != CompressionNone {
+			return fmt.Errorf("%w: Status Code %d, Body %s", errUnsupportedEncoding, resp.StatusCode, body)
+		}
+		if resp.StatusCode == http.StatusNotFound {
+			return fmt.Errorf("%w: Status Code %d, Body %s", errEndpointNotFound, resp.StatusCode, body)
+		}
 		return fmt.Errorf("Failed to send metrics to API. Status Code %d, Body %s", resp.StatusCode, body)
 	}
 
 	return err
 }
 
+// getCompression returns the content encoding to compress payloads with
+func (cl *APIClient) getCompression() string {
+	cl.compressionMutex.RLock()
+	defer cl.compressionMutex.RUnlock()
+	return cl.compression
+}
+
+// compressionFor returns the compression of the payloads posted to url. Only the v2 series intake accepts zstd, the
+// other intakes get DefaultCompression instead.
+func (cl *APIClient) compressionFor(url string) string {
+	compression := cl.getCompression()
+	if compression == CompressionZstd && url != cl.routeURL(seriesV2Route) {
+		return DefaultCompression
+	}
+	return compression
+}
+
+// disableCompression stops compressing payloads, after the intake rejected the given encoding
+func (cl *APIClient) disableCompression(encoding string) {
+	cl.compressionMutex.Lock()
+	defer cl.compressionMutex.Unlock()
+	if cl.compression != CompressionNone {
+		logger.Error(fmt.Errorf("the metrics intake rejected %s compression, sending metrics uncompressed", encoding))
+		cl.compression = CompressionNone
+	}
+}
+
+// getSeriesAPIVersion returns the version of the series endpoint to send series metrics to
+func (cl *APIClient) getSeriesAPIVersion() int {
+	cl.seriesAPIVersionMutex.RLock()
+	defer cl.seriesAPIVersionMutex.RUnlock()
+	return cl.seriesAPIVersion
+}
+
+// disableSeriesV2 sends series metrics to the v1 endpoint, after the v2 endpoint wasn't found
+func (cl *APIClient) disableSeriesV2() {
+	cl.seriesAPIVersionMutex.Lock()
+	defer cl.seriesAPIVersionMutex.Unlock()
+	if cl.seriesAPIVersion == seriesAPIV2 {
+		logger.Error(fmt.Errorf("the v2 series endpoint wasn't found, sending series metrics to the v1 endpoint"))
+		cl.seriesAPIVersion = seriesAPIV1
+	}
+}
+
 func (cl *APIClient) decryptAPIKey(decrypter Decrypter, kmsAPIKey string) <-chan string {
 
 	ch := make(chan string)
@@ -127,24 +342,48 @@ func (cl *APIClient) decryptAPIKey(decrypter Decrypter, kmsAPIKey string) <-chan
 		if err != nil {
 			logger.Error(fmt.Errorf("Couldn't decrypt api kms key %s", err))
 		}
+		logger.AddSecret(result)
 		ch <- result
 		close(ch)
 	}()
 	return ch
 }
 
+// getAPIKey returns the API key, waiting for it to finish decrypting if it was provided as a kms key.
+func (cl *APIClient) getAPIKey() string {
+	cl.apiKeyMutex.Lock()
+	defer cl.apiKeyMutex.Unlock()
+	if cl.apiKeyDecryptChan != nil {
+		cl.apiKey = <-cl.apiKeyDecryptChan
+		cl.apiKeyDecryptChan = nil
+	}
+	return cl.apiKey
+}
+
+// addAPICredentials sets the API key header. The key isn't added to the url, which is logged and can show up in errors.
 func (cl *APIClient) addAPICredentials(req *http.Request) {
-	query := req.URL.Query()
-	query.Add(apiKeyParam, cl.apiKey)
-	req.URL.RawQuery = query.Encode()
+	req.Header.Set(apiKeyHeader, cl.getAPIKey())
 }
 
 func (cl *APIClient) makeRoute(route string) string {
-	url := fmt.Sprintf("%s/%s", cl.baseAPIURL, route)
+	url := cl.routeURL(route)
 	logger.Debug(fmt.Sprintf("posting to url %s", url))
 	return url
 }
 
+// routeURL returns the url of route. Routes include the version of the API they belong to.
+func (cl *APIClient) routeURL(route string) string {
+	return fmt.Sprintf("%s/%s", cl.baseAPIURL, route)
+}
+
+func (e *chunkError) Error() string {
+	return fmt.Sprintf("chunk %d of %d (%d metrics to %s): %v", e.index+1, e.total, len(e.metrics), e.url, e.err)
+}
+
+func (e *chunkError) Unwrap() error {
+	return e.err
+}
+
 func marshalAPIMetricsModel(metrics []APIMetric) ([]byte, error) {
 	pm := postMetricsModel{}
 	pm.Series = metrics
@@ -9,12 +9,21 @@
 package metrics
 
 import (
+	"compress/gzip"
+	"compress/zlib"
 	"context"
+	"encoding/json"
+	"fmt"
 	"io"
 	"net/http"
 	"net/http/httptest"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/DataDog/datadog-lambda-go/internal/logger"
+	"github.com/klauspost/compress/zstd"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -36,10 +45,11 @@ func (md *mockDecrypter) Decrypt(cipherText string) (string, error) {
 }
 
 func TestAddAPICredentials(t *testing.T) {
-	cl := MakeAPIClient(context.Background(), APIClientOptions{baseAPIURL: "", apiKey: mockAPIKey})
+	cl := MakeAPIClient(APIClientOptions{baseAPIURL: "", apiKey: mockAPIKey})
 	req, _ := http.NewRequest("GET", "http://some-api.com/endpoint", nil)
 	cl.addAPICredentials(req)
-	assert.Equal(t, "http://some-api.com/endpoint?api_key=12345", req.URL.String())
+	assert.Equal(t, "http://some-api.com/endpoint", req.URL.String())
+	assert.Equal(t, "12345", req.Header.Get("DD-API-KEY"))
 }
 
 func TestSendMetricsSuccess(t *testing.T) {
@@ -48,10 +58,11 @@ func TestSendMetricsSuccess(t *testing.T) {
 		called = true
 		w.WriteHeader(http.StatusCreated)
 		body, _ := io.ReadAll(r.Body)
-		s := string(body)
 
-		assert.Equal(t, "/distribution_points?api_key=12345", r.URL.String())
-		assert.Equal(t, "{\"series\":[{\"metric\":\"metric-1\",\"tags\":[\"a\",\"b\",\"c\"],\"type\":\"distribution\",\"points\":[[1,[2]],[3,[4]],[5,[6]]]}]}", s)
+		assert.Equal(t, "/api/beta/sketches", r.URL.String())
+		assert.Equal(t, "12345", r.Header.Get("DD-API-KEY"))
+		assert.Equal(t, "application/x-protobuf", r.Header.Get("Content-Type"))
+		assert.NotEmpty(t, body)
 
 	}))
 	defer server.Close()
@@ -62,16 +73,14 @@ func TestSendMetricsSuccess(t *testing.T) {
 			Host:       nil,
 			Tags:       []string{"a", "b", "c"},
 			MetricType: DistributionType,
-			Points: []interface{}{
-				[]interface{}{float64(1), []interface{}{float64(2)}},
-				[]interface{}{float64(3), []interface{}{float64(4)}},
-				[]interface{}{float64(5), []interface{}{float64(6)}},
+			Sketches: []SketchPoint{
+				{Timestamp: 1, Count: 1, Min: 2, Max: 2, Sum: 2, Avg: 2, Keys: []int32{1383}, Counts: []uint32{1}},
 			},
 		},
 	}
 
-	cl := MakeAPIClient(context.Background(), APIClientOptions{baseAPIURL: server.URL, apiKey: mockAPIKey})
-	err := cl.SendMetrics(am)
+	cl := MakeAPIClient(APIClientOptions{baseAPIURL: server.URL, apiKey: mockAPIKey})
+	err := cl.SendMetrics(context.Background(), am)
 
 	assert.NoError(t, err)
 	assert.True(t, called)
@@ -83,10 +92,11 @@ func TestSendMetricsBadRequest(t *testing.T) {
 		called = true
 		w.WriteHeader(http.StatusForbidden)
 		body, _ := io.ReadAll(r.Body)
-		s := string(body)
 
-		assert.Equal(t, "/distribution_points?api_key=12345", r.URL.String())
-		assert.Equal(t, "{\"series\":[{\"metric\":\"metric-1\",\"tags\":[\"a\",\"b\",\"c\"],\"type\":\"distribution\",\"points\":[[1,[2]],[3,[4]],[5,[6]]]}]}", s)
+		assert.Equal(t, "/api/beta/sketches", r.URL.String())
+		assert.Equal(t, "12345", r.Header.Get("DD-API-KEY"))
+		assert.Equal(t, "application/x-protobuf", r.Header.Get("Content-Type"))
+		assert.NotEmpty(t, body)
 
 	}))
 	defer server.Close()
@@ -97,16 +107,14 @@ func TestSendMetricsBadRequest(t *testing.T) {
 			Host:       nil,
 			Tags:       []string{"a", "b", "c"},
 			MetricType: DistributionType,
-			Points: []interface{}{
-				[]interface{}{float64(1), []interface{}{float64(2)}},
-				[]interface{}{float64(3), []interface{}{float64(4)}},
-				[]interface{}{float64(5), []interface{}{float64(6)}},
+			Sketches: []SketchPoint{
+				{Timestamp: 1, Count: 1, Min: 2, Max: 2, Sum: 2, Avg: 2, Keys: []int32{1383}, Counts: []uint32{1}},
 			},
 		},
 	}
 
-	cl := MakeAPIClient(context.Background(), APIClientOptions{baseAPIURL: server.URL, apiKey: mockAPIKey})
-	err := cl.SendMetrics(am)
+	cl := MakeAPIClient(APIClientOptions{baseAPIURL: server.URL, apiKey: mockAPIKey})
+	err := cl.SendMetrics(context.Background(), am)
 
 	assert.Error(t, err)
 	assert.True(t, called)
@@ -125,26 +133,343 @@ func TestSendMetricsCantReachServer(t *testing.T) {
 			Host:       nil,
 			Tags:       []string{"a", "b", "c"},
 			MetricType: DistributionType,
-			Points: []interface{}{
-				[]interface{}{float64(1), []interface{}{float64(2)}},
-				[]interface{}{float64(3), []interface{}{float64(4)}},
-				[]interface{}{float64(5), []interface{}{float64(6)}},
+			Sketches: []SketchPoint{
+				{Timestamp: 1, Count: 1, Min: 2, Max: 2, Sum: 2, Avg: 2, Keys: []int32{1383}, Counts: []uint32{1}},
 			},
 		},
 	}
 
-	cl := MakeAPIClient(context.Background(), APIClientOptions{baseAPIURL: "httpa:///badly-formatted-url", apiKey: mockAPIKey})
-	err := cl.SendMetrics(am)
+	cl := MakeAPIClient(APIClientOptions{baseAPIURL: "httpa:///badly-formatted-url", apiKey: mockAPIKey})
+	err := cl.SendMetrics(context.Background(), am)
 
 	assert.Error(t, err)
 	assert.False(t, called)
 }
 
+func TestSendMetricsRoutesSeriesAndDistributions(t *testing.T) {
+	bodies := map[string]string{}
+	var mutex sync.Mutex
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mutex.Lock()
+		bodies[r.URL.Path] = string(body)
+		mutex.Unlock()
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer server.Close()
+
+	am := []APIMetric{
+		{
+			Name:       "metric-1",
+			MetricType: DistributionType,
+			Sketches:   []SketchPoint{{Timestamp: 1, Count: 1, Min: 2, Max: 2, Sum: 2, Avg: 2, Keys: []int32{1383}, Counts: []uint32{1}}},
+		},
+		{
+			Name:       "metric-2",
+			MetricType: GaugeType,
+			Points:     []interface{}{[]interface{}{float64(1), float64(3)}},
+		},
+	}
+
+	cl := MakeAPIClient(APIClientOptions{baseAPIURL: server.URL, apiKey: mockAPIKey, compression: CompressionNone})
+	err := cl.SendMetrics(context.Background(), am)
+
+	assert.NoError(t, err)
+	sketches, _ := marshalSketchPayload(am[:1])
+	assert.Equal(t, map[string]string{
+		"/api/beta/sketches": string(sketches),
+		"/api/v2/series":     "{\"series\":[{\"metric\":\"metric-2\",\"type\":3,\"points\":[{\"timestamp\":1,\"value\":3}],\"metadata\":{\"origin\":{\"product\":1}}}]}",
+	}, bodies)
+}
+
+func TestSendMetricsSplitsLargeBatches(t *testing.T) {
+	var mutex sync.Mutex
+	requests := 0
+	received := map[string]bool{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		assert.LessOrEqual(t, len(body), 200)
+
+		var payload postMetricsModel
+		assert.NoError(t, json.Unmarshal(body, &payload))
+		mutex.Lock()
+		requests++
+		for _, metric := range payload.Series {
+			received[metric.Name] = true
+		}
+		mutex.Unlock()
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer server.Close()
+
+	var am []APIMetric
+	for i := 0; i < 10; i++ {
+		am = append(am, APIMetric{
+			Name:       fmt.Sprintf("metric-%d", i),
+			MetricType: GaugeType,
+			Points:     []interface{}{[]interface{}{float64(1), float64(i)}},
+		})
+	}
+
+	cl := MakeAPIClient(APIClientOptions{baseAPIURL: server.URL, apiKey: mockAPIKey, compression: CompressionNone, seriesAPIVersion: seriesAPIV1})
+	cl.maxPayloadSize = 200
+	err := cl.SendMetrics(context.Background(), am)
+
+	assert.NoError(t, err)
+	assert.Greater(t, requests, 1)
+	assert.Len(t, received, 10)
+}
+
+func TestSendMetricsReportsEachFailedChunk(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if strings.Contains(string(body), "metric-0") || strings.Contains(string(body), "metric-3") {
+			w.WriteHeader(http.StatusRequestEntityTooLarge)
+			return
+		}
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer server.Close()
+
+	var am []APIMetric
+	for i := 0; i < 4; i++ {
+		am = append(am, APIMetric{
+			Name:       fmt.Sprintf("metric-%d", i),
+			MetricType: GaugeType,
+			Points:     []interface{}{[]interface{}{float64(1), float64(i)}},
+		})
+	}
+
+	cl := MakeAPIClient(APIClientOptions{baseAPIURL: server.URL, apiKey: mockAPIKey, compression: CompressionNone, seriesAPIVersion: seriesAPIV1})
+	cl.maxPayloadSize = 100
+	err := cl.SendMetrics(context.Background(), am)
+
+	assert.Error(t, err)
+	failed, ok := failedMetrics(err)
+	assert.True(t, ok)
+	assert.ElementsMatch(t, []APIMetric{am[0], am[3]}, failed)
+	assert.Contains(t, err.Error(), "chunk 1 of 4")
+	assert.Contains(t, err.Error(), "chunk 4 of 4")
+}
+
+func TestSendMetricsMetricOverSizeLimit(t *testing.T) {
+	called := false
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer server.Close()
+
+	am := []APIMetric{
+		{
+			Name:       "metric-1",
+			MetricType: GaugeType,
+			Points:     []interface{}{[]interface{}{float64(1), float64(2)}},
+		},
+	}
+
+	cl := MakeAPIClient(APIClientOptions{baseAPIURL: server.URL, apiKey: mockAPIKey, compression: CompressionNone})
+	cl.maxPayloadSize = 10
+	err := cl.SendMetrics(context.Background(), am)
+
+	assert.ErrorContains(t, err, "over the payload size limit")
+	assert.False(t, called)
+}
+
+func TestSendMetricsCompressed(t *testing.T) {
+	am := []APIMetric{
+		{
+			Name:       "metric-1",
+			MetricType: GaugeType,
+			Points:     []interface{}{[]interface{}{float64(1), float64(2)}},
+		},
+	}
+	expected, _ := marshalSeriesV2Model(am)
+
+	decompressors := map[string]func(io.Reader) (io.Reader, error){
+		CompressionGzip:    func(r io.Reader) (io.Reader, error) { return gzip.NewReader(r) },
+		CompressionDeflate: func(r io.Reader) (io.Reader, error) { return zlib.NewReader(r) },
+		CompressionZstd:    func(r io.Reader) (io.Reader, error) { return zstd.NewReader(r) },
+	}
+	for compression, decompress := range decompressors {
+		t.Run(compression, func(t *testing.T) {
+			var body []byte
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				assert.Equal(t, contentEncoding(compression), r.Header.Get("Content-Encoding"))
+				reader, err := decompress(r.Body)
+				assert.NoError(t, err)
+				body, _ = io.ReadAll(reader)
+				w.WriteHeader(http.StatusAccepted)
+			}))
+			defer server.Close()
+
+			cl := MakeAPIClient(APIClientOptions{baseAPIURL: server.URL, apiKey: mockAPIKey, compression: compression})
+			err := cl.SendMetrics(context.Background(), am)
+
+			assert.NoError(t, err)
+			assert.Equal(t, expected, body)
+		})
+	}
+}
+
+func TestSendMetricsCompressionFallback(t *testing.T) {
+	var encodings []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		encodings = append(encodings, r.Header.Get("Content-Encoding"))
+		if r.Header.Get("Content-Encoding") != "" {
+			w.WriteHeader(http.StatusUnsupportedMediaType)
+			return
+		}
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer server.Close()
+
+	am := []APIMetric{
+		{
+			Name:       "metric-1",
+			MetricType: GaugeType,
+			Points:     []interface{}{[]interface{}{float64(1), float64(2)}},
+		},
+	}
+
+	cl := MakeAPIClient(APIClientOptions{baseAPIURL: server.URL, apiKey: mockAPIKey, compression: CompressionZstd})
+	assert.NoError(t, cl.SendMetrics(context.Background(), am))
+	assert.NoError(t, cl.SendMetrics(context.Background(), am))
+
+	// Once rejected, compression stays off
+	assert.Equal(t, []string{zstdContentEncoding, "", ""}, encodings)
+}
+
+func TestSendMetricsCompressionFallbackOnBadRequest(t *testing.T) {
+	var encodings []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		encodings = append(encodings, r.Header.Get("Content-Encoding"))
+		if r.Header.Get("Content-Encoding") != "" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer server.Close()
+
+	am := []APIMetric{
+		{
+			Name:       "metric-1",
+			MetricType: GaugeType,
+			Points:     []interface{}{[]interface{}{float64(1), float64(2)}},
+		},
+	}
+
+	cl := MakeAPIClient(APIClientOptions{baseAPIURL: server.URL, apiKey: mockAPIKey, compression: CompressionGzip})
+	assert.NoError(t, cl.SendMetrics(context.Background(), am))
+	assert.Equal(t, []string{CompressionGzip, ""}, encodings)
+}
+
+func TestSendMetricsZstdOnlyToSeriesV2(t *testing.T) {
+	encodings := map[string]string{}
+	var mutex sync.Mutex
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		encodings[r.URL.Path] = r.Header.Get("Content-Encoding")
+		if r.URL.Path == "/api/v2/series" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer server.Close()
+
+	am := []APIMetric{
+		{
+			Name:       "metric-1",
+			MetricType: GaugeType,
+			Points:     []interface{}{[]interface{}{float64(1), float64(2)}},
+		},
+	}
+	distribution := Distribution{Name: "metric-2"}
+	distribution.AddPoint(time.Now(), 1)
+	am = append(am, distribution.ToAPIMetric(10)...)
+
+	cl := MakeAPIClient(APIClientOptions{baseAPIURL: server.URL, apiKey: mockAPIKey, compression: CompressionZstd})
+	assert.NoError(t, cl.SendMetrics(context.Background(), am))
+
+	assert.Equal(t, map[string]string{
+		"/api/v2/series":     zstdContentEncoding,
+		"/api/v1/series":     CompressionDeflate,
+		"/api/beta/sketches": CompressionDeflate,
+	}, encodings)
+}
+
+func TestSendMetricsSeriesV1Fallback(t *testing.T) {
+	var paths []string
+	var v1Body string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.URL.Path)
+		if r.URL.Path == "/api/v2/series" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		v1Body = string(body)
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer server.Close()
+
+	am := []APIMetric{
+		{
+			Name:       "metric-1",
+			MetricType: GaugeType,
+			Points:     []interface{}{[]interface{}{float64(1), float64(2)}},
+		},
+	}
+
+	cl := MakeAPIClient(APIClientOptions{baseAPIURL: server.URL, apiKey: mockAPIKey, compression: CompressionNone})
+	assert.NoError(t, cl.SendMetrics(context.Background(), am))
+	assert.NoError(t, cl.SendMetrics(context.Background(), am))
+
+	// Once the v2 endpoint isn't found, series metrics are only sent to the v1 endpoint
+	assert.Equal(t, []string{"/api/v2/series", "/api/v1/series", "/api/v1/series"}, paths)
+	assert.Equal(t, "{\"series\":[{\"metric\":\"metric-1\",\"type\":\"gauge\",\"points\":[[1,2]]}]}", v1Body)
+}
+
+func TestMakeAPIClientUnsupportedCompression(t *testing.T) {
+	cl := MakeAPIClient(APIClientOptions{compression: "brotli"})
+	assert.Equal(t, DefaultCompression, cl.getCompression())
+}
+
+func TestSendMetricsDoesNotLogAPIKey(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+	}))
+	defer server.Close()
+
+	am := []APIMetric{
+		{
+			Name:       "metric-1",
+			MetricType: GaugeType,
+			Points:     []interface{}{[]interface{}{float64(1), float64(2)}},
+		},
+	}
+
+	logger.SetLogLevel(logger.LevelDebug)
+	defer logger.SetLogLevel(logger.LevelWarn)
+	output := captureOutput(func() {
+		cl := MakeAPIClient(APIClientOptions{baseAPIURL: server.URL, apiKey: "my-secret-api-key"})
+		err := cl.SendMetrics(context.Background(), am)
+		logger.Error(err)
+	})
+
+	assert.Contains(t, output, "posting to url")
+	assert.NotContains(t, output, "my-secret-api-key")
+}
+
 func TestDecryptsUsingKMSKey(t *testing.T) {
 	called := false
 	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		called = true
-		assert.Equal(t, "/distribution_points?api_key=mockDecrypted", r.URL.String())
+		assert.Equal(t, "/api/beta/sketches", r.URL.String())
+		assert.Equal(t, "mockDecrypted", r.Header.Get("DD-API-KEY"))
 	}))
 	defer server.Close()
 
@@ -154,18 +479,16 @@ func TestDecryptsUsingKMSKey(t *testing.T) {
 			Host:       nil,
 			Tags:       []string{"a", "b", "c"},
 			MetricType: DistributionType,
-			Points: []interface{}{
-				[]interface{}{float64(1), []interface{}{float64(2)}},
-				[]interface{}{float64(3), []interface{}{float64(4)}},
-				[]interface{}{float64(5), []interface{}{float64(6)}},
+			Sketches: []SketchPoint{
+				{Timestamp: 1, Count: 1, Min: 2, Max: 2, Sum: 2, Avg: 2, Keys: []int32{1383}, Counts: []uint32{1}},
 			},
 		},
 	}
 	md := mockDecrypter{}
 	md.returnValue = mockDecryptedAPIKey
 
-	cl := MakeAPIClient(context.Background(), APIClientOptions{baseAPIURL: server.URL, apiKey: "", kmsAPIKey: mockEncryptedAPIKey, decrypter: &md})
-	err := cl.SendMetrics(am)
+	cl := MakeAPIClient(APIClientOptions{baseAPIURL: server.URL, apiKey: "", kmsAPIKey: mockEncryptedAPIKey, decrypter: &md})
+	err := cl.SendMetrics(context.Background(), am)
 
 	assert.NoError(t, err)
 	assert.True(t, called)
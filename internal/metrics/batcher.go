@@ -61,6 +61,34 @@ func (b *Batcher) ToAPIMetrics() []APIMetric {
 	return ar
 }
 
+// retain removes the metrics of the batch that none of apiMetrics were made from
+func (b *Batcher) retain(apiMetrics []APIMetric) {
+	retained := map[string]bool{}
+	for _, apiMetric := range apiMetrics {
+		retained[b.getAPIMetricKey(apiMetric)] = true
+	}
+
+	interval := b.batchInterval / time.Second
+	for sk, metric := range b.metrics {
+		keep := false
+		for _, apiMetric := range metric.ToAPIMetric(interval) {
+			keep = keep || retained[b.getAPIMetricKey(apiMetric)]
+		}
+		if !keep {
+			delete(b.metrics, sk)
+		}
+	}
+}
+
+func (b *Batcher) getAPIMetricKey(apiMetric APIMetric) string {
+	return b.getStringKey(BatchKey{
+		metricType: apiMetric.MetricType,
+		name:       apiMetric.Name,
+		tags:       apiMetric.Tags,
+		host:       apiMetric.Host,
+	})
+}
+
 func (b *Batcher) getStringKey(bk BatchKey) string {
 	tagKey := getTagKey(bk.tags)
 
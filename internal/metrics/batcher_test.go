@@ -20,20 +20,22 @@ func TestGetMetricDifferentTagOrder(t *testing.T) {
 	tm := time.Now()
 	batcher := MakeBatcher(10)
 	dm1 := Distribution{
-		Name:   "metric-1",
-		Values: []MetricValue{{Timestamp: tm, Value: 1}, {Timestamp: tm, Value: 2}},
-		Tags:   []string{"a", "b", "c"},
+		Name: "metric-1",
+		Tags: []string{"a", "b", "c"},
 	}
+	dm1.AddPoint(tm, 1)
+	dm1.AddPoint(tm, 2)
 	dm2 := Distribution{
-		Name:   "metric-1",
-		Values: []MetricValue{{Timestamp: tm, Value: 3}, {Timestamp: tm, Value: 4}},
-		Tags:   []string{"c", "b", "a"},
+		Name: "metric-1",
+		Tags: []string{"c", "b", "a"},
 	}
+	dm2.AddPoint(tm, 3)
+	dm2.AddPoint(tm, 4)
 
 	batcher.AddMetric(&dm1)
 	batcher.AddMetric(&dm2)
 
-	assert.Equal(t, []MetricValue{{Timestamp: tm, Value: 1}, {Timestamp: tm, Value: 2}, {Timestamp: tm, Value: 3}, {Timestamp: tm, Value: 4}}, dm1.Values)
+	assert.Equal(t, int64(4), dm1.ToAPIMetric(10)[0].Sketches[0].Count)
 }
 
 func TestGetMetricFailDifferentName(t *testing.T) {
@@ -42,20 +44,22 @@ func TestGetMetricFailDifferentName(t *testing.T) {
 	batcher := MakeBatcher(10)
 
 	dm1 := Distribution{
-		Name:   "metric-1",
-		Values: []MetricValue{{Timestamp: tm, Value: 1}, {Timestamp: tm, Value: 2}},
-		Tags:   []string{"a", "b", "c"},
+		Name: "metric-1",
+		Tags: []string{"a", "b", "c"},
 	}
+	dm1.AddPoint(tm, 1)
+	dm1.AddPoint(tm, 2)
 	dm2 := Distribution{
-		Name:   "metric-2",
-		Values: []MetricValue{{Timestamp: tm, Value: 3}, {Timestamp: tm, Value: 4}},
-		Tags:   []string{"c", "b", "a"},
+		Name: "metric-2",
+		Tags: []string{"c", "b", "a"},
 	}
+	dm2.AddPoint(tm, 3)
+	dm2.AddPoint(tm, 4)
 
 	batcher.AddMetric(&dm1)
 	batcher.AddMetric(&dm2)
 
-	assert.Equal(t, []MetricValue{{Timestamp: tm, Value: 1}, {Timestamp: tm, Value: 2}}, dm1.Values)
+	assert.Equal(t, int64(2), dm1.ToAPIMetric(10)[0].Sketches[0].Count)
 
 }
 
@@ -67,22 +71,23 @@ func TestGetMetricFailDifferentHost(t *testing.T) {
 	host2 := "my-host-2"
 
 	dm1 := Distribution{
-		Values: []MetricValue{{Timestamp: tm, Value: 1}, {Timestamp: tm, Value: 2}},
-
 		Tags: []string{"a", "b", "c"},
 		Host: &host1,
 	}
+	dm1.AddPoint(tm, 1)
+	dm1.AddPoint(tm, 2)
 	dm2 := Distribution{
-		Name:   "metric-1",
-		Values: []MetricValue{{Timestamp: tm, Value: 3}, {Timestamp: tm, Value: 4}},
-		Tags:   []string{"a", "b", "c"},
-		Host:   &host2,
+		Name: "metric-1",
+		Tags: []string{"a", "b", "c"},
+		Host: &host2,
 	}
+	dm2.AddPoint(tm, 3)
+	dm2.AddPoint(tm, 4)
 
 	batcher.AddMetric(&dm1)
 	batcher.AddMetric(&dm2)
 
-	assert.Equal(t, []MetricValue{{Timestamp: tm, Value: 1}, {Timestamp: tm, Value: 2}}, dm1.Values)
+	assert.Equal(t, int64(2), dm1.ToAPIMetric(10)[0].Sketches[0].Count)
 }
 
 func TestGetMetricSameHost(t *testing.T) {
@@ -93,22 +98,24 @@ func TestGetMetricSameHost(t *testing.T) {
 	host := "my-host"
 
 	dm1 := Distribution{
-		Name:   "metric-1",
-		Values: []MetricValue{{Timestamp: tm, Value: 1}, {Timestamp: tm, Value: 2}},
-		Tags:   []string{"a", "b", "c"},
-		Host:   &host,
+		Name: "metric-1",
+		Tags: []string{"a", "b", "c"},
+		Host: &host,
 	}
+	dm1.AddPoint(tm, 1)
+	dm1.AddPoint(tm, 2)
 	dm2 := Distribution{
-		Name:   "metric-1",
-		Values: []MetricValue{{Timestamp: tm, Value: 3}, {Timestamp: tm, Value: 4}},
-		Tags:   []string{"a", "b", "c"},
-		Host:   &host,
+		Name: "metric-1",
+		Tags: []string{"a", "b", "c"},
+		Host: &host,
 	}
+	dm2.AddPoint(tm, 3)
+	dm2.AddPoint(tm, 4)
 
 	batcher.AddMetric(&dm1)
 	batcher.AddMetric(&dm2)
 
-	assert.Equal(t, []MetricValue{{Timestamp: tm, Value: 1}, {Timestamp: tm, Value: 2}, {Timestamp: tm, Value: 3}, {Timestamp: tm, Value: 4}}, dm1.Values)
+	assert.Equal(t, int64(4), dm1.ToAPIMetric(10)[0].Sketches[0].Count)
 }
 
 func TestToAPIMetricsSameInterval(t *testing.T) {
@@ -117,10 +124,9 @@ func TestToAPIMetricsSameInterval(t *testing.T) {
 
 	batcher := MakeBatcher(10)
 	dm := Distribution{
-		Name:   "metric-1",
-		Tags:   []string{"a", "b", "c"},
-		Host:   &hostname,
-		Values: []MetricValue{},
+		Name: "metric-1",
+		Tags: []string{"a", "b", "c"},
+		Host: &hostname,
 	}
 
 	dm.AddPoint(tm, 1)
@@ -129,7 +135,6 @@ func TestToAPIMetricsSameInterval(t *testing.T) {
 
 	batcher.AddMetric(&dm)
 
-	floatTime := float64(tm.Unix())
 	result := batcher.ToAPIMetrics()
 	expected := []APIMetric{
 		{
@@ -138,13 +143,116 @@ func TestToAPIMetricsSameInterval(t *testing.T) {
 			Tags:       []string{"a", "b", "c"},
 			MetricType: DistributionType,
 			Interval:   nil,
-			Points: []interface{}{
-				[]interface{}{floatTime, []interface{}{float64(1)}},
-				[]interface{}{floatTime, []interface{}{float64(2)}},
-				[]interface{}{floatTime, []interface{}{float64(3)}},
+			Sketches: []SketchPoint{
+				{
+					Timestamp: tm.Truncate(sketchInterval).Unix(),
+					Count:     3,
+					Min:       1,
+					Max:       3,
+					Sum:       6,
+					Avg:       2,
+					Keys:      []int32{1338, 1383, 1409},
+					Counts:    []uint32{1, 1, 1},
+				},
 			},
 		},
 	}
 
 	assert.Equal(t, expected, result)
 }
+
+func TestGaugeJoinKeepsMostRecentValue(t *testing.T) {
+	tm := time.Now()
+	batcher := MakeBatcher(10)
+
+	g1 := Gauge{Name: "metric-1", Value: MetricValue{Timestamp: tm, Value: 1}}
+	g2 := Gauge{Name: "metric-1", Value: MetricValue{Timestamp: tm.Add(time.Second), Value: 2}}
+	g3 := Gauge{Name: "metric-1", Value: MetricValue{Timestamp: tm.Add(-time.Second), Value: 3}}
+
+	batcher.AddMetric(&g1)
+	batcher.AddMetric(&g2)
+	batcher.AddMetric(&g3)
+
+	assert.Equal(t, MetricValue{Timestamp: tm.Add(time.Second), Value: 2}, g1.Value)
+}
+
+func TestCountJoinAddsValues(t *testing.T) {
+	tm := time.Now()
+	batcher := MakeBatcher(10 * time.Second)
+
+	c1 := Count{Name: "metric-1", Value: MetricValue{Timestamp: tm, Value: 1}}
+	c2 := Count{Name: "metric-1", Value: MetricValue{Timestamp: tm.Add(time.Second), Value: 2}}
+
+	batcher.AddMetric(&c1)
+	batcher.AddMetric(&c2)
+
+	assert.Equal(t, MetricValue{Timestamp: tm.Add(time.Second), Value: 3}, c1.Value)
+
+	interval := float64(10)
+	assert.Equal(t, []APIMetric{
+		{
+			Name:       "metric-1",
+			MetricType: CountType,
+			Points:     []interface{}{[]interface{}{float64(tm.Add(time.Second).Unix()), float64(3)}},
+			Interval:   &interval,
+		},
+	}, batcher.ToAPIMetrics())
+}
+
+func TestRateJoinAddsValuesPerSecond(t *testing.T) {
+	tm := time.Now()
+	batcher := MakeBatcher(10 * time.Second)
+
+	r1 := Rate{Name: "metric-1", Value: MetricValue{Timestamp: tm, Value: 10}}
+	r2 := Rate{Name: "metric-1", Value: MetricValue{Timestamp: tm.Add(time.Second), Value: 20}}
+
+	batcher.AddMetric(&r1)
+	batcher.AddMetric(&r2)
+
+	assert.Equal(t, MetricValue{Timestamp: tm.Add(time.Second), Value: 30}, r1.Value)
+
+	interval := float64(10)
+	assert.Equal(t, []APIMetric{
+		{
+			Name:       "metric-1",
+			MetricType: RateType,
+			Points:     []interface{}{[]interface{}{float64(tm.Add(time.Second).Unix()), float64(3)}},
+			Interval:   &interval,
+		},
+	}, batcher.ToAPIMetrics())
+}
+
+func TestSetJoinCountsUniqueValues(t *testing.T) {
+	tm := time.Now()
+	batcher := MakeBatcher(10)
+
+	s1 := Set{Name: "metric-1"}
+	s1.AddValue(tm, "a")
+	s2 := Set{Name: "metric-1"}
+	s2.AddValue(tm, "a")
+	s2.AddValue(tm, "b")
+
+	batcher.AddMetric(&s1)
+	batcher.AddMetric(&s2)
+
+	assert.Equal(t, []APIMetric{
+		{
+			Name:       "metric-1",
+			MetricType: GaugeType,
+			Points:     []interface{}{[]interface{}{float64(tm.Unix()), float64(2)}},
+		},
+	}, batcher.ToAPIMetrics())
+}
+
+func TestGetMetricFailDifferentType(t *testing.T) {
+	tm := time.Now()
+	batcher := MakeBatcher(10)
+
+	g := Gauge{Name: "metric-1", Value: MetricValue{Timestamp: tm, Value: 1}}
+	c := Count{Name: "metric-1", Value: MetricValue{Timestamp: tm, Value: 2}}
+
+	batcher.AddMetric(&g)
+	batcher.AddMetric(&c)
+
+	assert.Len(t, batcher.ToAPIMetrics(), 2)
+}
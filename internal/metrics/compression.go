@@ -0,0 +1,101 @@
+/*
+ * Unless explicitly stated otherwise all files in this repository are licensed
+ * under the Apache License Version 2.0.
+ *
+ * This product includes software developed at Datadog (https://www.datadoghq.com/).
+ * Copyright 2021 Datadog, Inc.
+ */
+
+package metrics
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+const (
+	// CompressionNone sends payloads uncompressed
+	CompressionNone = "none"
+	// CompressionGzip compresses payloads with gzip
+	CompressionGzip = "gzip"
+	// CompressionDeflate compresses payloads with zlib, as the "deflate" content encoding
+	CompressionDeflate = "deflate"
+	// CompressionZstd compresses payloads with zstd. Only the v2 series intake accepts it, payloads to the other
+	// intakes are compressed with DefaultCompression instead.
+	CompressionZstd = "zstd"
+
+	// zstdContentEncoding is the content encoding of zstd payloads expected by the v2 series intake
+	zstdContentEncoding = "zstd1"
+
+	// DefaultCompression is the compression used when none is configured
+	DefaultCompression = CompressionDeflate
+)
+
+var (
+	// errUnsupportedEncoding is returned when the intake rejects the content encoding of a payload
+	errUnsupportedEncoding = errors.New("content encoding rejected by the intake")
+
+	// zstdEncoder is shared by all payloads, since it is costly to create and safe for concurrent use
+	zstdEncoder     *zstd.Encoder
+	zstdEncoderErr  error
+	zstdEncoderOnce sync.Once
+)
+
+// isSupportedCompression reports whether compression is one of the supported content encodings
+func isSupportedCompression(compression string) bool {
+	switch compression {
+	case CompressionNone, CompressionGzip, CompressionDeflate, CompressionZstd:
+		return true
+	}
+	return false
+}
+
+// contentEncoding returns the Content-Encoding header of payloads compressed with compression
+func contentEncoding(compression string) string {
+	if compression == CompressionZstd {
+		return zstdContentEncoding
+	}
+	return compression
+}
+
+// compress encodes content with the given compression
+func compress(compression string, content []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	switch compression {
+	case CompressionNone:
+		return content, nil
+	case CompressionGzip:
+		writer := gzip.NewWriter(&buf)
+		if _, err := writer.Write(content); err != nil {
+			return nil, err
+		}
+		if err := writer.Close(); err != nil {
+			return nil, err
+		}
+	case CompressionDeflate:
+		writer := zlib.NewWriter(&buf)
+		if _, err := writer.Write(content); err != nil {
+			return nil, err
+		}
+		if err := writer.Close(); err != nil {
+			return nil, err
+		}
+	case CompressionZstd:
+		zstdEncoderOnce.Do(func() {
+			zstdEncoder, zstdEncoderErr = zstd.NewWriter(nil)
+		})
+		if zstdEncoderErr != nil {
+			return nil, zstdEncoderErr
+		}
+		return zstdEncoder.EncodeAll(content, nil), nil
+	default:
+		return nil, fmt.Errorf("unsupported compression %s", compression)
+	}
+	return buf.Bytes(), nil
+}
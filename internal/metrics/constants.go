@@ -11,7 +11,16 @@ package metrics
 import "time"
 
 const (
-	apiKeyParam                        = "api_key"
+	apiKeyHeader                       = "DD-API-KEY"
+	seriesV1Route                      = "api/v1/series"
+	seriesV2Route                      = "api/v2/series"
+	sketchesRoute                      = "api/beta/sketches"
+	maxPayloadSize                     = 3200000
+	maxUncompressedPayloadSize         = 62914560
+	maxSeriesV2PayloadSize             = 512000
+	maxSeriesV2UncompressedPayloadSize = 5242880
+	jsonContentType                    = "application/json"
+	protobufContentType                = "application/x-protobuf"
 	defaultRetryInterval               = time.Millisecond * 250
 	defaultBatchInterval               = time.Second * 15
 	defaultHttpClientTimeout           = time.Second * 5
@@ -27,4 +36,13 @@ const (
 
 	// DistributionType represents a distribution metric
 	DistributionType MetricType = "distribution"
+	// GaugeType represents a gauge metric, reporting the last value in an interval
+	GaugeType MetricType = "gauge"
+	// CountType represents a count metric, reporting the sum of the values in an interval
+	CountType MetricType = "count"
+	// RateType represents a rate metric, reporting the sum of the values in an interval per second
+	RateType MetricType = "rate"
+	// SetType represents a set metric, reporting the number of unique values in an interval. It is sent to the API as
+	// a gauge.
+	SetType MetricType = "set"
 )
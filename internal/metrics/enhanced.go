@@ -0,0 +1,183 @@
+/*
+ * Unless explicitly stated otherwise all files in this repository are licensed
+ * under the Apache License Version 2.0.
+ *
+ * This product includes software developed at Datadog (https://www.datadoghq.com/).
+ * Copyright 2021 Datadog, Inc.
+ */
+
+package metrics
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/DataDog/datadog-lambda-go/internal/logger"
+	"github.com/DataDog/datadog-lambda-go/internal/process"
+	"github.com/aws/aws-lambda-go/lambdacontext"
+)
+
+// When the extension isn't running, the enhanced metrics it would report from the Lambda telemetry are computed in
+// process instead, from the time spent in the handler, the memory high water mark of the process and the Lambda
+// context.
+const (
+	// Lambda prices for each GB-second of billed duration, by architecture, and for each request
+	x86PricePerGBSecond   = 0.0000166667
+	arm64PricePerGBSecond = 0.0000133334
+	pricePerRequest       = 0.0000002
+)
+
+var (
+	// procStatusPath is the status file of the process, which holds its memory high water mark
+	procStatusPath = "/proc/self/status"
+
+	// invocationStartKey holds the invocationStart of the handler in the context of an invocation
+	invocationStartKey = new(contextKeytype)
+
+	// initDurationOnce reports the init duration with the first invocation only
+	initDurationOnce sync.Once
+)
+
+// invocationStart is the state of the process when the handler started
+type invocationStart struct {
+	time time.Time
+	// maxMemoryUsed is the memory high water mark of the process, in MB
+	maxMemoryUsed float64
+}
+
+// invocationMetrics are the enhanced metrics of an invocation
+type invocationMetrics struct {
+	// duration, billedDuration and initDuration are in seconds
+	duration       float64
+	billedDuration float64
+	initDuration   float64
+	// maxMemoryUsed is in MB
+	maxMemoryUsed float64
+	outOfMemory   bool
+	estimatedCost float64
+}
+
+// startInvocation records the time the handler started and the memory high water mark at that time in ctx
+func startInvocation(ctx context.Context) context.Context {
+	return context.WithValue(ctx, invocationStartKey, invocationStart{time: time.Now(), maxMemoryUsed: readMaxMemoryUsedMB()})
+}
+
+// submitInvocationMetrics reports the duration, billed duration, memory, cost and, on cold starts, init duration of the
+// invocation ctx belongs to
+func (l *Listener) submitInvocationMetrics(ctx context.Context, err error) {
+	if !l.config.EnhancedMetrics {
+		return
+	}
+	start, ok := ctx.Value(invocationStartKey).(invocationStart)
+	if !ok {
+		return
+	}
+	var initDuration time.Duration
+	if coldStart, _ := ctx.Value("cold_start").(bool); coldStart {
+		initDurationOnce.Do(func() {
+			initDuration = start.time.Sub(process.StartTime)
+		})
+	}
+	m := computeInvocationMetrics(start, time.Now(), initDuration, lambdacontext.MemoryLimitInMB, err)
+
+	tags := getEnhancedMetricsTags(ctx)
+	timestamp := time.Now()
+	submit := func(metricName string, value float64) {
+		l.AddDistributionMetric(fmt.Sprintf("aws.lambda.enhanced.%s", metricName), value, timestamp, true, tags...)
+	}
+	submit("duration", m.duration)
+	submit("billed_duration", m.billedDuration)
+	submit("max_memory_used", m.maxMemoryUsed)
+	submit("estimated_cost", m.estimatedCost)
+	if m.initDuration > 0 {
+		submit("init_duration", m.initDuration)
+	}
+	if m.outOfMemory {
+		submit("out_of_memory", 1)
+	}
+}
+
+// computeInvocationMetrics computes the enhanced metrics of an invocation that ran from start to end. initDuration is
+// only set on the first invocation.
+func computeInvocationMetrics(start invocationStart, end time.Time, initDuration time.Duration, memoryLimitMB int, err error) invocationMetrics {
+	m := invocationMetrics{
+		duration:     end.Sub(start.time).Seconds(),
+		initDuration: initDuration.Seconds(),
+	}
+
+	// The init phase of OS-only runtimes, which Go functions run on, is billed with the first invocation. Lambda bills
+	// by the millisecond, rounded up.
+	billed := end.Sub(start.time) + initDuration
+	m.billedDuration = math.Ceil(float64(billed)/float64(time.Millisecond)) / 1000
+
+	m.maxMemoryUsed = readMaxMemoryUsedMB()
+	m.outOfMemory = isOutOfMemory(err, start.maxMemoryUsed, m.maxMemoryUsed, memoryLimitMB)
+	m.estimatedCost = estimateCost(m.billedDuration, memoryLimitMB, runtime.GOARCH)
+	return m
+}
+
+// readMaxMemoryUsedMB returns the memory high water mark of the process, in MB. It falls back to the memory obtained
+// from the OS by the Go runtime when /proc can't be read.
+func readMaxMemoryUsedMB() float64 {
+	kb, err := readProcStatusKB(procStatusPath, "VmHWM")
+	if err == nil {
+		return kb / 1024
+	}
+	logger.Debug(fmt.Sprintf("could not read the memory high water mark from %s: %v", procStatusPath, err))
+	var stats runtime.MemStats
+	runtime.ReadMemStats(&stats)
+	return float64(stats.Sys) / (1024 * 1024)
+}
+
+// readProcStatusKB reads a field in kB from a /proc status file
+func readProcStatusKB(path string, field string) (float64, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		name, value, ok := strings.Cut(scanner.Text(), ":")
+		if !ok || name != field {
+			continue
+		}
+		return strconv.ParseFloat(strings.TrimSuffix(strings.TrimSpace(value), " kB"), 64)
+	}
+	if err := scanner.Err(); err != nil {
+		return 0, err
+	}
+	return 0, fmt.Errorf("field %s not found", field)
+}
+
+// isOutOfMemory reports whether the invocation ran out of memory. The Go runtime can't recover from failing to allocate
+// memory, so this is only known when the handler got ENOMEM from the OS, or when the memory high water mark of the
+// process rose to the memory limit of the function during the invocation. The high water mark is kept for the lifetime
+// of the process, so a limit reached by a previous invocation doesn't count.
+func isOutOfMemory(err error, startMaxMemoryUsedMB float64, maxMemoryUsedMB float64, memoryLimitMB int) bool {
+	if errors.Is(err, syscall.ENOMEM) {
+		return true
+	}
+	return memoryLimitMB > 0 && maxMemoryUsedMB > startMaxMemoryUsedMB && maxMemoryUsedMB >= float64(memoryLimitMB)
+}
+
+// estimateCost returns the cost in dollars of an invocation, from its billed duration in seconds and the memory of the
+// function
+func estimateCost(billedDuration float64, memoryLimitMB int, arch string) float64 {
+	pricePerGBSecond := x86PricePerGBSecond
+	if arch == "arm64" {
+		pricePerGBSecond = arm64PricePerGBSecond
+	}
+	return billedDuration*float64(memoryLimitMB)/1024*pricePerGBSecond + pricePerRequest
+}
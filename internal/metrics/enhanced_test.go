@@ -0,0 +1,141 @@
+/*
+ * Unless explicitly stated otherwise all files in this repository are licensed
+ * under the Apache License Version 2.0.
+ *
+ * This product includes software developed at Datadog (https://www.datadoghq.com/).
+ * Copyright 2021 Datadog, Inc.
+ */
+
+package metrics
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/DataDog/datadog-lambda-go/internal/extension"
+	"github.com/aws/aws-lambda-go/lambdacontext"
+	"github.com/stretchr/testify/assert"
+)
+
+func withProcStatus(t *testing.T, content string) {
+	path := filepath.Join(t.TempDir(), "status")
+	assert.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+	previous := procStatusPath
+	procStatusPath = path
+	t.Cleanup(func() { procStatusPath = previous })
+}
+
+func TestComputeInvocationMetrics(t *testing.T) {
+	withProcStatus(t, "Name:\tbootstrap\nVmPeak:\t  102400 kB\nVmHWM:\t   51200 kB\n")
+	start := invocationStart{time: time.Now(), maxMemoryUsed: 40}
+	end := start.time.Add(1500*time.Millisecond + 200*time.Microsecond)
+
+	m := computeInvocationMetrics(start, end, 300*time.Millisecond, 1024, nil)
+
+	assert.InDelta(t, 1.5002, m.duration, 1e-9)
+	assert.InDelta(t, 0.3, m.initDuration, 1e-9)
+	// The init duration is billed, and the total is rounded up to the millisecond
+	assert.InDelta(t, 1.801, m.billedDuration, 1e-9)
+	assert.Equal(t, float64(50), m.maxMemoryUsed)
+	assert.False(t, m.outOfMemory)
+	assert.InDelta(t, estimateCost(1.801, 1024, "amd64"), m.estimatedCost, 1e-15)
+}
+
+func TestReadMaxMemoryUsedWithoutProc(t *testing.T) {
+	previous := procStatusPath
+	procStatusPath = filepath.Join(t.TempDir(), "missing")
+	defer func() { procStatusPath = previous }()
+
+	assert.Greater(t, readMaxMemoryUsedMB(), float64(0))
+}
+
+func TestIsOutOfMemory(t *testing.T) {
+	testcases := []struct {
+		name          string
+		err           error
+		startMaxMemMB float64
+		maxMemMB      float64
+		limitMB       int
+		outOfMemory   bool
+	}{
+		{"under the limit", nil, 50, 100, 128, false},
+		{"handler error", errors.New("boom"), 50, 100, 128, false},
+		{"limit reached during the invocation", nil, 100, 128, 128, true},
+		{"limit reached by a previous invocation", nil, 128, 128, 128, false},
+		{"ENOMEM", fmt.Errorf("mmap: %w", syscall.ENOMEM), 50, 100, 128, true},
+		{"error mentioning memory", errors.New("cache is out of memory"), 50, 100, 128, false},
+		{"no memory limit", nil, 50, 100, 0, false},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.outOfMemory, isOutOfMemory(tc.err, tc.startMaxMemMB, tc.maxMemMB, tc.limitMB))
+		})
+	}
+}
+
+func TestEstimateCost(t *testing.T) {
+	assert.InDelta(t, 2*x86PricePerGBSecond+pricePerRequest, estimateCost(1, 2048, "amd64"), 1e-15)
+	assert.InDelta(t, 0.5*arm64PricePerGBSecond+pricePerRequest, estimateCost(1, 512, "arm64"), 1e-15)
+}
+
+func TestSubmitInvocationMetrics(t *testing.T) {
+	withProcStatus(t, "VmHWM:\t   51200 kB\n")
+	ml := MakeListener(Config{APIKey: "abc-123", EnhancedMetrics: true}, &extension.ExtensionManager{})
+	//nolint
+	ctx := context.WithValue(context.Background(), "cold_start", false)
+
+	output := captureOutput(func() {
+		ctx = ml.HandlerStarted(ctx, json.RawMessage{})
+		ml.HandlerFinished(ctx, fmt.Errorf("mmap: %w", syscall.ENOMEM))
+	})
+
+	values := map[string]float64{}
+	for _, line := range strings.Split(strings.TrimSpace(output), "\n") {
+		var lm logMetric
+		if json.Unmarshal([]byte(line), &lm) == nil {
+			values[lm.MetricName] = lm.Value
+		}
+	}
+	for _, name := range []string{"invocations", "errors", "duration", "billed_duration", "estimated_cost", "out_of_memory"} {
+		assert.Contains(t, values, "aws.lambda.enhanced."+name)
+	}
+	assert.Equal(t, float64(50), values["aws.lambda.enhanced.max_memory_used"])
+	assert.NotContains(t, values, "aws.lambda.enhanced.init_duration")
+}
+
+func TestSubmitInvocationMetricsMemoryLimitReached(t *testing.T) {
+	defer func(limit int) { lambdacontext.MemoryLimitInMB = limit }(lambdacontext.MemoryLimitInMB)
+	lambdacontext.MemoryLimitInMB = 128
+	ml := MakeListener(Config{APIKey: "abc-123", EnhancedMetrics: true}, &extension.ExtensionManager{})
+	//nolint
+	ctx := context.WithValue(context.Background(), "cold_start", false)
+
+	invoke := func(startHWM string, endHWM string) map[string]float64 {
+		withProcStatus(t, startHWM)
+		output := captureOutput(func() {
+			ctx := ml.HandlerStarted(ctx, json.RawMessage{})
+			withProcStatus(t, endHWM)
+			ml.HandlerFinished(ctx, nil)
+		})
+		values := map[string]float64{}
+		for _, line := range strings.Split(strings.TrimSpace(output), "\n") {
+			var lm logMetric
+			if json.Unmarshal([]byte(line), &lm) == nil {
+				values[lm.MetricName] = lm.Value
+			}
+		}
+		return values
+	}
+
+	assert.Contains(t, invoke("VmHWM:\t   51200 kB\n", "VmHWM:\t  131072 kB\n"), "aws.lambda.enhanced.out_of_memory")
+	// The high water mark was already at the limit when the invocation started
+	assert.NotContains(t, invoke("VmHWM:\t  131072 kB\n", "VmHWM:\t  131072 kB\n"), "aws.lambda.enhanced.out_of_memory")
+}
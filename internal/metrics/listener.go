@@ -22,6 +22,7 @@ import (
 	"github.com/DataDog/datadog-go/v5/statsd"
 	"github.com/DataDog/datadog-lambda-go/internal/extension"
 	"github.com/DataDog/datadog-lambda-go/internal/logger"
+	"github.com/DataDog/datadog-lambda-go/internal/trigger"
 	"github.com/DataDog/datadog-lambda-go/internal/version"
 )
 
@@ -34,6 +35,7 @@ type (
 		processor        Processor
 		isAgentRunning   bool
 		extensionManager *extension.ExtensionManager
+		tagGuard         *tagGuard
 	}
 
 	// Config gives options for how the listener should work
@@ -51,6 +53,14 @@ type (
 		CircuitBreakerTotalFailures uint32
 		LocalTest                   bool
 		FIPSMode                    bool
+		Compression                 string
+		// Tags are the unified service tags, added to all metrics
+		Tags []string
+		// TagCardinalityLimit is the number of distinct values a tag key can have for a metric. Zero or a negative
+		// value, the default, turns the limit off.
+		TagCardinalityLimit int
+		// TagCardinalityOverflow is what happens to the tags over the limit: TagOverflowDrop or TagOverflowRewrite
+		TagCardinalityOverflow string
 	}
 
 	logMetric struct {
@@ -72,12 +82,13 @@ func MakeListener(config Config, extensionManager *extension.ExtensionManager) L
 
 	var apiClient *APIClient
 	if !config.FIPSMode {
-		apiClient = MakeAPIClient(context.Background(), APIClientOptions{
+		apiClient = MakeAPIClient(APIClientOptions{
 			baseAPIURL:        config.Site,
 			apiKey:            config.APIKey,
 			decrypter:         MakeKMSDecrypter(config.FIPSMode),
 			kmsAPIKey:         config.KMSAPIKey,
 			httpClientTimeout: config.HTTPClientTimeout,
+			compression:       config.Compression,
 		})
 	}
 
@@ -108,19 +119,28 @@ func MakeListener(config Config, extensionManager *extension.ExtensionManager) L
 		}
 	}
 
+	// The processor lives as long as the execution environment, so that its circuit breaker and the metrics kept for
+	// retry carry over from one invocation to the next. It's only needed when metrics are sent to the API.
+	var processor Processor
+	if statsdClient == nil && !config.ShouldUseLogForwarder && !config.FIPSMode {
+		processor = MakeProcessor(context.Background(), apiClient, MakeTimeService(), config.BatchInterval, config.ShouldRetryOnFailure, config.CircuitBreakerInterval, config.CircuitBreakerTimeout, config.CircuitBreakerTotalFailures)
+		processor.StartProcessing()
+	}
+
 	return Listener{
 		apiClient:        apiClient,
 		config:           &config,
 		isAgentRunning:   statsdClient != nil,
 		statsdClient:     statsdClient,
-		processor:        nil,
+		processor:        processor,
 		extensionManager: extensionManager,
+		tagGuard:         makeTagGuard(config.TagCardinalityLimit, config.TagCardinalityOverflow),
 	}
 }
 
 // canSendMetrics reports whether l can send metrics.
 func (l *Listener) canSendMetrics() bool {
-	return l.isAgentRunning || l.config.ShouldUseLogForwarder || !l.config.FIPSMode || (l.apiClient != nil && (l.apiClient.apiKey != "" || l.config.KMSAPIKey != ""))
+	return l.isAgentRunning || l.config.ShouldUseLogForwarder || !l.config.FIPSMode || (l.apiClient != nil && (l.config.APIKey != "" || l.config.KMSAPIKey != ""))
 }
 
 // HandlerStarted adds metrics service to the context
@@ -130,18 +150,7 @@ func (l *Listener) HandlerStarted(ctx context.Context, msg json.RawMessage) cont
 	}
 
 	ctx = AddListener(ctx, l)
-
-	if !l.config.FIPSMode {
-		ts := MakeTimeService()
-		pr := MakeProcessor(ctx, l.apiClient, ts, l.config.BatchInterval, l.config.ShouldRetryOnFailure, l.config.CircuitBreakerInterval, l.config.CircuitBreakerTimeout, l.config.CircuitBreakerTotalFailures)
-		l.processor = pr
-
-		// Setting the context on the client will mean that future requests will be cancelled correctly
-		// if the lambda times out.
-		l.apiClient.context = ctx
-
-		pr.StartProcessing()
-	}
+	ctx = startInvocation(ctx)
 
 	l.submitEnhancedMetrics("invocations", ctx)
 
@@ -165,21 +174,31 @@ func (l *Listener) HandlerFinished(ctx context.Context, err error) {
 			}
 		}
 	} else {
-		// use the api
+		// use the api. The extension isn't running to report the enhanced metrics it computes from the Lambda
+		// telemetry, so they are computed here.
+		l.submitInvocationMetrics(ctx, err)
+		if err != nil {
+			l.submitEnhancedMetrics("errors", ctx)
+		}
 		if l.processor != nil {
-			if err != nil {
-				l.submitEnhancedMetrics("errors", ctx)
-			}
-			l.processor.FinishProcessing()
+			// Using the context of the invocation means that requests will be cancelled correctly if the lambda times out.
+			l.processor.Flush(ctx)
 		}
 	}
 }
 
+// HandlerTimedOut implemented as part of the wrapper.HandlerTimeoutListener interface
+func (l *Listener) HandlerTimedOut(ctx context.Context, err error) {
+	l.submitEnhancedMetrics("timeouts", ctx)
+	l.HandlerFinished(ctx, err)
+}
+
 // AddDistributionMetric sends a distribution metric
 func (l *Listener) AddDistributionMetric(metric string, value float64, timestamp time.Time, forceLogForwarder bool, tags ...string) {
-
-	// We add our own runtime tag to the metric for version tracking
-	tags = append(tags, runtimeTag)
+	metric, tags, ok := l.prepareMetric(metric, tags)
+	if !ok {
+		return
+	}
 
 	if l.isAgentRunning {
 		err := l.statsdClient.Distribution(metric, value, tags, 1)
@@ -214,15 +233,114 @@ func (l *Listener) AddDistributionMetric(metric string, value float64, timestamp
 	}
 
 	m := Distribution{
-		Name:   metric,
-		Tags:   tags,
-		Values: []MetricValue{},
+		Name: metric,
+		Tags: tags,
 	}
 	m.AddPoint(timestamp, value)
 	logger.Debug(fmt.Sprintf("adding metric \"%s\", with value %f", metric, value))
 	l.processor.AddMetric(&m)
 }
 
+// AddGaugeMetric sends a gauge metric, reporting the last value recorded in an interval. unit is optional.
+func (l *Listener) AddGaugeMetric(metric string, value float64, unit string, timestamp time.Time, tags ...string) {
+	metric, tags, ok := l.prepareMetric(metric, tags)
+	if !ok {
+		return
+	}
+	m := Gauge{Name: metric, Tags: tags, Unit: unit}
+	m.AddPoint(timestamp, value)
+	l.addSeriesMetric(&m, func() error {
+		return l.statsdClient.Gauge(metric, value, tags, 1)
+	})
+}
+
+// AddCountMetric sends a count metric, reporting the sum of the values recorded in an interval. unit is optional.
+func (l *Listener) AddCountMetric(metric string, value int64, unit string, timestamp time.Time, tags ...string) {
+	metric, tags, ok := l.prepareMetric(metric, tags)
+	if !ok {
+		return
+	}
+	m := Count{Name: metric, Tags: tags, Unit: unit}
+	m.AddPoint(timestamp, float64(value))
+	l.addSeriesMetric(&m, func() error {
+		return l.statsdClient.Count(metric, value, tags, 1)
+	})
+}
+
+// AddRateMetric sends a rate metric, reporting the sum of the values recorded in an interval per second. The agent
+// reports the counts it receives as rates. unit is optional.
+func (l *Listener) AddRateMetric(metric string, value int64, unit string, timestamp time.Time, tags ...string) {
+	metric, tags, ok := l.prepareMetric(metric, tags)
+	if !ok {
+		return
+	}
+	m := Rate{Name: metric, Tags: tags, Unit: unit}
+	m.AddPoint(timestamp, float64(value))
+	l.addSeriesMetric(&m, func() error {
+		return l.statsdClient.Count(metric, value, tags, 1)
+	})
+}
+
+// AddSetMetric sends a set metric, reporting the number of unique values recorded in an interval
+func (l *Listener) AddSetMetric(metric string, value string, timestamp time.Time, tags ...string) {
+	metric, tags, ok := l.prepareMetric(metric, tags)
+	if !ok {
+		return
+	}
+	m := Set{Name: metric, Tags: tags}
+	m.AddValue(timestamp, value)
+	l.addSeriesMetric(&m, func() error {
+		return l.statsdClient.Set(metric, value, tags, 1)
+	})
+}
+
+// addSeriesMetric sends a metric other than a distribution to the agent when it's running, or else to the API.
+// The log forwarder only handles distributions.
+func (l *Listener) addSeriesMetric(m Metric, sendToAgent func() error) {
+	key := m.ToBatchKey()
+
+	if l.isAgentRunning {
+		if err := sendToAgent(); err != nil {
+			logger.Error(fmt.Errorf("could not send metric %s: %s", key.name, err.Error()))
+		}
+		return
+	}
+
+	if l.config.ShouldUseLogForwarder {
+		logger.Error(fmt.Errorf("could not send %s metric %s: the log forwarder only supports distribution metrics", key.metricType, key.name))
+		return
+	}
+
+	if l.config.FIPSMode {
+		logger.Debug(fmt.Sprintf("skipping metric %s due to FIPS mode - direct API calls are disabled", key.name))
+		return
+	}
+
+	logger.Debug(fmt.Sprintf("adding %s metric \"%s\"", key.metricType, key.name))
+	l.processor.AddMetric(m)
+}
+
+// prepareMetric normalizes the name and tags of a metric, adds the global tags, and limits the cardinality of the
+// tags. It returns false if the metric must be dropped.
+func (l *Listener) prepareMetric(metric string, tags []string) (string, []string, bool) {
+	name, ok := normalizeMetricName(metric)
+	if !ok {
+		l.tagGuard.dropMetric(metric)
+		return "", nil, false
+	}
+	tags = normalizeTags(l.withGlobalTags(tags))
+	return name, l.tagGuard.apply(name, tags), true
+}
+
+// withGlobalTags adds the unified service tags and our own runtime tag, for version tracking, to the tags of a
+// metric. The tags are copied, so that the slice passed by the caller isn't modified.
+func (l *Listener) withGlobalTags(tags []string) []string {
+	result := make([]string, 0, len(tags)+len(l.config.Tags)+1)
+	result = append(result, tags...)
+	result = append(result, l.config.Tags...)
+	return append(result, runtimeTag)
+}
+
 // getRuntimeTag returns the runtime tag to be used when creating distribution
 // metrics.  It should not be called directly, instead use the global
 // runtimeTag var.
@@ -285,6 +403,14 @@ func getEnhancedMetricsTags(ctx context.Context) []string {
 
 		tags = append(tags, resource)
 
+		if triggerTags, ok := trigger.TagsFromContext(ctx); ok {
+			for _, key := range []string{trigger.EventSourceTag, trigger.EventSourceARNTag} {
+				if value, ok := triggerTags[key]; ok {
+					tags = append(tags, fmt.Sprintf("%s:%s", key, value))
+				}
+			}
+		}
+
 		return tags
 	}
 
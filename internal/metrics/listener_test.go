@@ -14,6 +14,7 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"net"
 	"net/http"
 	"net/http/httptest"
@@ -24,6 +25,7 @@ import (
 
 	"github.com/DataDog/datadog-lambda-go/internal/extension"
 	"github.com/DataDog/datadog-lambda-go/internal/logger"
+	"github.com/DataDog/datadog-lambda-go/internal/trigger"
 	"github.com/DataDog/datadog-lambda-go/internal/version"
 	"github.com/aws/aws-lambda-go/lambdacontext"
 
@@ -45,19 +47,40 @@ func TestHandlerAddsItselfToContext(t *testing.T) {
 	assert.NotNil(t, pr)
 }
 
-func TestHandlerFinishesProcessing(t *testing.T) {
+func TestHandlerFinishedKeepsProcessing(t *testing.T) {
 	listener := MakeListener(Config{}, &extension.ExtensionManager{})
 	ctx := listener.HandlerStarted(context.Background(), json.RawMessage{})
 
 	listener.HandlerFinished(ctx, nil)
-	assert.False(t, listener.processor.IsProcessing())
+	assert.True(t, listener.processor.IsProcessing())
+}
+
+func TestHandlerFinishedFlushesEachInvocation(t *testing.T) {
+	calls := make(chan struct{}, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls <- struct{}{}
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer server.Close()
+
+	listener := MakeListener(Config{APIKey: "12345", Site: server.URL}, &extension.ExtensionManager{})
+	processor := listener.processor
+	for i := 0; i < 2; i++ {
+		ctx := listener.HandlerStarted(context.Background(), json.RawMessage{})
+		GetListener(ctx).AddDistributionMetric("the-metric", 2, time.Now(), false, "tag:a", "tag:b")
+		listener.HandlerFinished(ctx, nil)
+		// The metrics of the invocation are sent by the time it ends
+		assert.Len(t, calls, i+1)
+	}
+	assert.Same(t, processor, listener.processor)
 }
 
 func TestAddDistributionMetricWithAPI(t *testing.T) {
 
 	called := false
 	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		assert.Equal(t, "/distribution_points?api_key=12345", r.URL.String())
+		assert.Equal(t, "/api/beta/sketches", r.URL.String())
+		assert.Equal(t, "12345", r.Header.Get("DD-API-KEY"))
 		called = true
 		w.WriteHeader(http.StatusCreated)
 	}))
@@ -65,11 +88,55 @@ func TestAddDistributionMetricWithAPI(t *testing.T) {
 
 	listener := MakeListener(Config{APIKey: "12345", Site: server.URL}, &extension.ExtensionManager{})
 	ctx := listener.HandlerStarted(context.Background(), json.RawMessage{})
-	listener.AddDistributionMetric("the-metric", 2, time.Now(), false, "tag:a", "tag:b")
+	GetListener(ctx).AddDistributionMetric("the-metric", 2, time.Now(), false, "tag:a", "tag:b")
 	listener.HandlerFinished(ctx, nil)
 	assert.True(t, called)
 }
 
+func TestAddSeriesMetricsWithAPI(t *testing.T) {
+	var body string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/api/v2/series", r.URL.String())
+		assert.Equal(t, "12345", r.Header.Get("DD-API-KEY"))
+		b, _ := io.ReadAll(r.Body)
+		body = string(b)
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer server.Close()
+
+	listener := MakeListener(Config{APIKey: "12345", Site: server.URL, Compression: CompressionNone}, &extension.ExtensionManager{})
+	ctx := listener.HandlerStarted(context.Background(), json.RawMessage{})
+	GetListener(ctx).AddGaugeMetric("the-gauge", 2, "byte", time.Now(), "tag:a")
+	GetListener(ctx).AddCountMetric("the-count", 3, "", time.Now(), "tag:a")
+	GetListener(ctx).AddRateMetric("the-rate", 30, "", time.Now(), "tag:a")
+	GetListener(ctx).AddSetMetric("the-set", "user-1", time.Now(), "tag:a")
+	listener.HandlerFinished(ctx, nil)
+
+	assert.Contains(t, body, `"metric":"the_gauge"`)
+	assert.Contains(t, body, `"unit":"byte"`)
+	assert.Contains(t, body, `"metric":"the_count"`)
+	assert.Contains(t, body, `"metric":"the_rate","type":2`)
+	assert.Contains(t, body, `"metric":"the_set"`)
+}
+
+func TestAddSeriesMetricWithLogForwarder(t *testing.T) {
+	called := false
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer server.Close()
+
+	listener := MakeListener(Config{APIKey: "12345", Site: server.URL, ShouldUseLogForwarder: true}, &extension.ExtensionManager{})
+	ctx := listener.HandlerStarted(context.Background(), json.RawMessage{})
+	output := captureOutput(func() {
+		GetListener(ctx).AddGaugeMetric("the-gauge", 2, "byte", time.Now(), "tag:a")
+	})
+	listener.HandlerFinished(ctx, nil)
+	assert.False(t, called)
+	assert.Contains(t, output, "the log forwarder only supports distribution metrics")
+}
+
 func TestAddDistributionMetricWithLogForwarder(t *testing.T) {
 	called := false
 	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -80,10 +147,60 @@ func TestAddDistributionMetricWithLogForwarder(t *testing.T) {
 
 	listener := MakeListener(Config{APIKey: "12345", Site: server.URL, ShouldUseLogForwarder: true}, &extension.ExtensionManager{})
 	ctx := listener.HandlerStarted(context.Background(), json.RawMessage{})
-	listener.AddDistributionMetric("the-metric", 2, time.Now(), false, "tag:a", "tag:b")
+	GetListener(ctx).AddDistributionMetric("the-metric", 2, time.Now(), false, "tag:a", "tag:b")
 	listener.HandlerFinished(ctx, nil)
 	assert.False(t, called)
 }
+
+func TestLogForwarderWithoutProcessor(t *testing.T) {
+	listener := MakeListener(Config{APIKey: "12345", ShouldUseLogForwarder: true, EnhancedMetrics: true}, &extension.ExtensionManager{})
+	assert.Nil(t, listener.processor)
+
+	ctx := listener.HandlerStarted(context.Background(), json.RawMessage{})
+	output := captureOutput(func() {
+		listener.HandlerFinished(ctx, errors.New("something went wrong"))
+	})
+	assert.Contains(t, output, "aws.lambda.enhanced.errors")
+}
+func TestAddDistributionMetricWithGlobalTags(t *testing.T) {
+	listener := MakeListener(Config{APIKey: "12345", ShouldUseLogForwarder: true, Tags: []string{"env:prod", "service:my-service"}}, &extension.ExtensionManager{})
+	ctx := listener.HandlerStarted(context.Background(), json.RawMessage{})
+	tags := make([]string, 1, 4)
+	tags[0] = "tag:a"
+	output := captureOutput(func() {
+		GetListener(ctx).AddDistributionMetric("the-metric", 2, time.Now(), false, tags...)
+	})
+	listener.HandlerFinished(ctx, nil)
+
+	var lm logMetric
+	assert.NoError(t, json.Unmarshal([]byte(output), &lm))
+	assert.Equal(t, []string{"tag:a", "env:prod", "service:my-service", runtimeTag}, lm.Tags)
+	// The tags passed by the caller are left untouched
+	assert.Equal(t, []string{"tag:a", "", "", ""}, tags[:4])
+}
+
+func TestAddDistributionMetricIsNormalized(t *testing.T) {
+	listener := MakeListener(Config{APIKey: "12345", ShouldUseLogForwarder: true, TagCardinalityLimit: 1}, &extension.ExtensionManager{})
+	ctx := listener.HandlerStarted(context.Background(), json.RawMessage{})
+	output := captureOutput(func() {
+		GetListener(ctx).AddDistributionMetric("my-metric", 2, time.Now(), false, "Request ID:1")
+		GetListener(ctx).AddDistributionMetric("my-metric", 2, time.Now(), false, "Request ID:2")
+		GetListener(ctx).AddDistributionMetric("123", 2, time.Now(), false)
+	})
+	listener.HandlerFinished(ctx, nil)
+
+	lines := strings.Split(strings.TrimSpace(output), "\n")
+	assert.Len(t, lines, 4)
+	var lm logMetric
+	assert.NoError(t, json.Unmarshal([]byte(lines[0]), &lm))
+	assert.Equal(t, "my_metric", lm.MetricName)
+	assert.Equal(t, []string{"request_id:1", runtimeTag}, lm.Tags)
+	assert.Contains(t, lines[1], "dropping tags such as request_id:2")
+	assert.NoError(t, json.Unmarshal([]byte(lines[2]), &lm))
+	assert.Equal(t, []string{runtimeTag}, lm.Tags)
+	assert.Contains(t, lines[3], `dropping metric \"123\"`)
+}
+
 func TestAddDistributionMetricWithForceLogForwarder(t *testing.T) {
 	called := false
 	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -94,7 +211,7 @@ func TestAddDistributionMetricWithForceLogForwarder(t *testing.T) {
 
 	listener := MakeListener(Config{APIKey: "12345", Site: server.URL, ShouldUseLogForwarder: false}, &extension.ExtensionManager{})
 	ctx := listener.HandlerStarted(context.Background(), json.RawMessage{})
-	listener.AddDistributionMetric("the-metric", 2, time.Now(), true, "tag:a", "tag:b")
+	GetListener(ctx).AddDistributionMetric("the-metric", 2, time.Now(), true, "tag:a", "tag:b")
 	listener.HandlerFinished(ctx, nil)
 	assert.False(t, called)
 }
@@ -132,7 +249,7 @@ func TestAddDistributionMetricWithFIPSMode(t *testing.T) {
 	})
 
 	// Check that we logged the skipping message
-	assert.Contains(t, logOutput, "skipping metric fips-test-metric due to FIPS mode", "Expected log about skipping metric")
+	assert.Contains(t, logOutput, "skipping metric fips_test_metric due to FIPS mode", "Expected log about skipping metric")
 	assert.Contains(t, logOutput, "direct API calls are disabled", "Expected log about disabled API calls")
 
 	// Finish the handler
@@ -171,6 +288,25 @@ func TestGetEnhancedMetricsTagsWithAlias(t *testing.T) {
 	assert.ElementsMatch(t, tags, []string{"functionname:go-lambda-test", "region:us-east-1", "memorysize:256", "cold_start:false", "account_id:123497558138", "resource:go-lambda-test:my-alias", "executedversion:1", "datadog_lambda:v" + version.DDLambdaVersion})
 }
 
+func TestGetEnhancedMetricsTagsWithTrigger(t *testing.T) {
+	//nolint
+	ctx := context.WithValue(context.Background(), "cold_start", false)
+	ctx = trigger.ContextWithEvent(ctx, trigger.Event{Source: trigger.SQS, Tags: map[string]string{
+		trigger.EventSourceTag:    "sqs",
+		trigger.EventSourceARNTag: "arn:aws:sqs:us-east-1:123456789012:my-queue",
+	}})
+
+	lambdacontext.MemoryLimitInMB = 256
+	lambdacontext.FunctionName = "go-lambda-test"
+	lc := &lambdacontext.LambdaContext{
+		InvokedFunctionArn: "arn:aws:lambda:us-east-1:123497558138:function:go-lambda-test:$Latest",
+	}
+	tags := getEnhancedMetricsTags(lambdacontext.NewContext(ctx, lc))
+
+	assert.Contains(t, tags, "function_trigger.event_source:sqs")
+	assert.Contains(t, tags, "function_trigger.event_source_arn:arn:aws:sqs:us-east-1:123456789012:my-queue")
+}
+
 func TestGetEnhancedMetricsTagsNoLambdaContext(t *testing.T) {
 	//nolint
 	ctx := context.WithValue(context.Background(), "cold_start", true)
@@ -268,6 +404,32 @@ func TestSubmitEnhancedMetricsOnlyErrors(t *testing.T) {
 	assert.True(t, strings.Contains(output, expected))
 }
 
+func TestSubmitEnhancedMetricsTimeouts(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer server.Close()
+
+	ml := MakeListener(
+		Config{
+			APIKey:          "abc-123",
+			Site:            server.URL,
+			EnhancedMetrics: true,
+		},
+		&extension.ExtensionManager{},
+	)
+	//nolint
+	ctx := context.WithValue(context.Background(), "cold_start", false)
+
+	output := captureOutput(func() {
+		ctx = ml.HandlerStarted(ctx, json.RawMessage{})
+		ml.HandlerTimedOut(ctx, errors.New("timed out"))
+	})
+
+	assert.True(t, strings.Contains(output, "{\"m\":\"aws.lambda.enhanced.timeouts\",\"v\":1,"))
+	assert.True(t, strings.Contains(output, "{\"m\":\"aws.lambda.enhanced.errors\",\"v\":1,"))
+}
+
 func TestListenerHandlerFinishedFlushes(t *testing.T) {
 	var called bool
 
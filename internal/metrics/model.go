@@ -9,7 +9,13 @@
 package metrics
 
 import (
+	"fmt"
+	"sort"
+	"strconv"
 	"time"
+
+	"github.com/DataDog/datadog-lambda-go/internal/logger"
+	"github.com/DataDog/sketches-go/ddsketch"
 )
 
 type (
@@ -29,6 +35,10 @@ type (
 		MetricType MetricType    `json:"type"`
 		Interval   *float64      `json:"interval,omitempty"`
 		Points     []interface{} `json:"points"`
+		// Unit is only sent to the v2 series endpoint, the v1 endpoint doesn't accept it
+		Unit string `json:"-"`
+		// Sketches holds the points of distributions, which are sent to the sketches intake rather than as JSON
+		Sketches []SketchPoint `json:"-"`
 	}
 
 	// MetricValue represents a datapoint for a metric
@@ -37,18 +47,66 @@ type (
 		Timestamp time.Time
 	}
 
-	// Distribution is a type of metric that is aggregated over multiple hosts
+	// Distribution is a type of metric that is aggregated over multiple hosts. Its values are aggregated into a
+	// sketch per interval of sketchInterval.
 	Distribution struct {
-		Name   string
-		Tags   []string
-		Host   *string
-		Values []MetricValue
+		Name     string
+		Tags     []string
+		Host     *string
+		sketches map[int64]*ddsketch.DDSketchWithExactSummaryStatistics
+	}
+
+	// Gauge is a type of metric that reports the last value recorded in an interval
+	Gauge struct {
+		Name string
+		Tags []string
+		// Unit is the unit of the values, such as "byte" or "second", which is only sent to the v2 series endpoint
+		Unit  string
+		Value MetricValue
+	}
+
+	// Count is a type of metric that reports the sum of the values recorded in an interval
+	Count struct {
+		Name string
+		Tags []string
+		// Unit is the unit of the values, such as "byte" or "second", which is only sent to the v2 series endpoint
+		Unit  string
+		Value MetricValue
+	}
+
+	// Rate is a type of metric that reports the sum of the values recorded in an interval, divided by the length of the
+	// interval in seconds
+	Rate struct {
+		Name string
+		Tags []string
+		// Unit is the unit of the values, such as "byte" or "second", which is only sent to the v2 series endpoint
+		Unit  string
+		Value MetricValue
+	}
+
+	// Set is a type of metric that reports the number of unique values recorded in an interval
+	Set struct {
+		Name      string
+		Tags      []string
+		Values    map[string]struct{}
+		Timestamp time.Time
 	}
 )
 
-// AddPoint adds a point to the distribution metric
+// AddPoint adds a point to the sketch of the distribution covering timestamp
 func (d *Distribution) AddPoint(timestamp time.Time, value float64) {
-	d.Values = append(d.Values, MetricValue{Timestamp: timestamp, Value: value})
+	if d.sketches == nil {
+		d.sketches = map[int64]*ddsketch.DDSketchWithExactSummaryStatistics{}
+	}
+	interval := timestamp.Truncate(sketchInterval).Unix()
+	sketch, ok := d.sketches[interval]
+	if !ok {
+		sketch = newSketch()
+		d.sketches[interval] = sketch
+	}
+	if err := sketch.Add(value); err != nil {
+		logger.Error(fmt.Errorf("could not add value %f to distribution %s: %v", value, d.Name, err))
+	}
 }
 
 // ToBatchKey returns a key that can be used to batch the metric
@@ -61,36 +119,221 @@ func (d *Distribution) ToBatchKey() BatchKey {
 	}
 }
 
-// Join creates a union between two metric sets
+// Join merges the sketches of two distributions
 func (d *Distribution) Join(metric Metric) {
 	otherDist, ok := metric.(*Distribution)
 	if !ok {
 		return
 	}
-	for _, val := range otherDist.Values {
-		d.AddPoint(val.Timestamp, val.Value)
+	if d.sketches == nil {
+		d.sketches = map[int64]*ddsketch.DDSketchWithExactSummaryStatistics{}
+	}
+	for interval, otherSketch := range otherDist.sketches {
+		sketch, ok := d.sketches[interval]
+		if !ok {
+			d.sketches[interval] = otherSketch.Copy()
+			continue
+		}
+		if err := sketch.MergeWith(otherSketch); err != nil {
+			logger.Error(fmt.Errorf("could not merge distribution %s: %v", d.Name, err))
+		}
 	}
-
 }
 
-// ToAPIMetric converts a distribution into an API ready format.
+// ToAPIMetric converts a distribution into an API ready format, with a sketch point per interval.
 func (d *Distribution) ToAPIMetric(interval time.Duration) []APIMetric {
-	points := make([]interface{}, len(d.Values))
-
-	for i, val := range d.Values {
-		currentTime := float64(val.Timestamp.Unix())
+	timestamps := make([]int64, 0, len(d.sketches))
+	for timestamp := range d.sketches {
+		timestamps = append(timestamps, timestamp)
+	}
+	sort.Slice(timestamps, func(i, j int) bool { return timestamps[i] < timestamps[j] })
 
-		points[i] = []interface{}{currentTime, []interface{}{val.Value}}
+	points := make([]SketchPoint, len(timestamps))
+	for i, timestamp := range timestamps {
+		points[i] = toSketchPoint(timestamp, d.sketches[timestamp])
 	}
 
 	return []APIMetric{
-		APIMetric{
+		{
 			Name:       d.Name,
 			Host:       d.Host,
 			Tags:       d.Tags,
 			MetricType: DistributionType,
-			Points:     points,
-			Interval:   nil,
+			Sketches:   points,
+		},
+	}
+}
+
+// AddPoint sets the value of the gauge, unless it already holds a more recent one
+func (g *Gauge) AddPoint(timestamp time.Time, value float64) {
+	if timestamp.Before(g.Value.Timestamp) {
+		return
+	}
+	g.Value = MetricValue{Timestamp: timestamp, Value: value}
+}
+
+// ToBatchKey returns a key that can be used to batch the metric
+func (g *Gauge) ToBatchKey() BatchKey {
+	return BatchKey{
+		name:       g.Name,
+		tags:       g.Tags,
+		metricType: GaugeType,
+	}
+}
+
+// Join keeps the most recent value of the two gauges
+func (g *Gauge) Join(metric Metric) {
+	otherGauge, ok := metric.(*Gauge)
+	if !ok {
+		return
+	}
+	g.AddPoint(otherGauge.Value.Timestamp, otherGauge.Value.Value)
+}
+
+// ToAPIMetric converts a gauge into an API ready format.
+func (g *Gauge) ToAPIMetric(interval time.Duration) []APIMetric {
+	return []APIMetric{
+		{
+			Name:       g.Name,
+			Tags:       g.Tags,
+			MetricType: GaugeType,
+			Points:     []interface{}{[]interface{}{float64(g.Value.Timestamp.Unix()), g.Value.Value}},
+			Unit:       g.Unit,
+		},
+	}
+}
+
+// AddPoint adds a value to the count, which is reported with the most recent timestamp
+func (c *Count) AddPoint(timestamp time.Time, value float64) {
+	if timestamp.After(c.Value.Timestamp) {
+		c.Value.Timestamp = timestamp
+	}
+	c.Value.Value += value
+}
+
+// ToBatchKey returns a key that can be used to batch the metric
+func (c *Count) ToBatchKey() BatchKey {
+	return BatchKey{
+		name:       c.Name,
+		tags:       c.Tags,
+		metricType: CountType,
+	}
+}
+
+// Join adds up the two counts
+func (c *Count) Join(metric Metric) {
+	otherCount, ok := metric.(*Count)
+	if !ok {
+		return
+	}
+	c.AddPoint(otherCount.Value.Timestamp, otherCount.Value.Value)
+}
+
+// ToAPIMetric converts a count into an API ready format.
+func (c *Count) ToAPIMetric(interval time.Duration) []APIMetric {
+	seconds := float64(interval)
+	return []APIMetric{
+		{
+			Name:       c.Name,
+			Tags:       c.Tags,
+			MetricType: CountType,
+			Points:     []interface{}{[]interface{}{float64(c.Value.Timestamp.Unix()), c.Value.Value}},
+			Interval:   &seconds,
+			Unit:       c.Unit,
+		},
+	}
+}
+
+// AddPoint adds a value to the rate, which is reported with the most recent timestamp
+func (r *Rate) AddPoint(timestamp time.Time, value float64) {
+	if timestamp.After(r.Value.Timestamp) {
+		r.Value.Timestamp = timestamp
+	}
+	r.Value.Value += value
+}
+
+// ToBatchKey returns a key that can be used to batch the metric
+func (r *Rate) ToBatchKey() BatchKey {
+	return BatchKey{
+		name:       r.Name,
+		tags:       r.Tags,
+		metricType: RateType,
+	}
+}
+
+// Join adds up the values of the two rates
+func (r *Rate) Join(metric Metric) {
+	otherRate, ok := metric.(*Rate)
+	if !ok {
+		return
+	}
+	r.AddPoint(otherRate.Value.Timestamp, otherRate.Value.Value)
+}
+
+// ToAPIMetric converts a rate into an API ready format, with the sum of its values per second of the interval.
+func (r *Rate) ToAPIMetric(interval time.Duration) []APIMetric {
+	seconds := float64(interval)
+	value := r.Value.Value
+	if seconds > 0 {
+		value /= seconds
+	}
+	return []APIMetric{
+		{
+			Name:       r.Name,
+			Tags:       r.Tags,
+			MetricType: RateType,
+			Points:     []interface{}{[]interface{}{float64(r.Value.Timestamp.Unix()), value}},
+			Interval:   &seconds,
+			Unit:       r.Unit,
+		},
+	}
+}
+
+// AddValue adds a value to the set, which is reported with the most recent timestamp
+func (s *Set) AddValue(timestamp time.Time, value string) {
+	if s.Values == nil {
+		s.Values = map[string]struct{}{}
+	}
+	if timestamp.After(s.Timestamp) {
+		s.Timestamp = timestamp
+	}
+	s.Values[value] = struct{}{}
+}
+
+// AddPoint adds a numeric value to the set
+func (s *Set) AddPoint(timestamp time.Time, value float64) {
+	s.AddValue(timestamp, strconv.FormatFloat(value, 'f', -1, 64))
+}
+
+// ToBatchKey returns a key that can be used to batch the metric
+func (s *Set) ToBatchKey() BatchKey {
+	return BatchKey{
+		name:       s.Name,
+		tags:       s.Tags,
+		metricType: SetType,
+	}
+}
+
+// Join creates a union between the values of two sets
+func (s *Set) Join(metric Metric) {
+	otherSet, ok := metric.(*Set)
+	if !ok {
+		return
+	}
+	for value := range otherSet.Values {
+		s.AddValue(otherSet.Timestamp, value)
+	}
+}
+
+// ToAPIMetric converts a set into an API ready format. The API has no set type, so the number of unique values is
+// sent as a gauge.
+func (s *Set) ToAPIMetric(interval time.Duration) []APIMetric {
+	return []APIMetric{
+		{
+			Name:       s.Name,
+			Tags:       s.Tags,
+			MetricType: GaugeType,
+			Points:     []interface{}{[]interface{}{float64(s.Timestamp.Unix()), float64(len(s.Values))}},
 		},
 	}
 }
@@ -0,0 +1,199 @@
+/*
+ * Unless explicitly stated otherwise all files in this repository are licensed
+ * under the Apache License Version 2.0.
+ *
+ * This product includes software developed at Datadog (https://www.datadoghq.com/).
+ * Copyright 2021 Datadog, Inc.
+ */
+
+package metrics
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/DataDog/datadog-lambda-go/internal/logger"
+)
+
+const (
+	// maxMetricNameLength and maxTagLength are the longest metric names and tags accepted by Datadog
+	maxMetricNameLength = 200
+	maxTagLength        = 200
+
+	// TagOverflowDrop drops the tags whose values are over the cardinality limit of a metric
+	TagOverflowDrop = "drop"
+	// TagOverflowRewrite replaces the values over the cardinality limit of a metric with overflowTagValue
+	TagOverflowRewrite = "rewrite"
+
+	// overflowTagValue replaces the tag values over the cardinality limit when rewriting them
+	overflowTagValue = "overflow"
+)
+
+type (
+	// tagGuard limits the number of distinct values each tag key has for a metric, so that high cardinality values
+	// such as request ids don't reach the backend. It is shared by all the invocations of the execution environment.
+	tagGuard struct {
+		limit    int
+		overflow string
+		// values holds the distinct values seen for each metric and tag key. Tags without a key are grouped together.
+		values map[string]map[string]map[string]struct{}
+		// warned holds the metrics that a warning was already logged for
+		warned map[string]struct{}
+		mutex  sync.Mutex
+	}
+)
+
+// normalizeMetricName follows Datadog's rules for metric names: they start with a letter, and other characters than
+// ASCII letters, digits, underscores and periods are replaced by underscores. It returns false if nothing is left of
+// the name.
+func normalizeMetricName(name string) (string, bool) {
+	var b strings.Builder
+	lastUnderscore := false
+	for _, r := range name {
+		if b.Len() >= maxMetricNameLength {
+			break
+		}
+		isLetter := r < utf8.RuneSelf && unicode.IsLetter(r)
+		if b.Len() == 0 && !isLetter {
+			continue
+		}
+		if isLetter || r < utf8.RuneSelf && unicode.IsDigit(r) || r == '.' {
+			b.WriteRune(r)
+			lastUnderscore = false
+		} else if !lastUnderscore {
+			b.WriteRune('_')
+			lastUnderscore = true
+		}
+	}
+	result := strings.TrimRight(b.String(), "_")
+	return result, result != ""
+}
+
+// normalizeTag follows Datadog's rules for tags: they are lowercase, start with a letter, and other characters than
+// letters, digits, underscores, minuses, colons, periods and slashes are replaced by underscores. It returns false if
+// nothing is left of the tag.
+func normalizeTag(tag string) (string, bool) {
+	var b strings.Builder
+	lastUnderscore := false
+	for _, r := range strings.ToLower(tag) {
+		if b.Len()+utf8.RuneLen(r) > maxTagLength {
+			break
+		}
+		if b.Len() == 0 && !unicode.IsLetter(r) {
+			continue
+		}
+		if unicode.IsLetter(r) || unicode.IsDigit(r) || strings.ContainsRune("-:./", r) {
+			b.WriteRune(r)
+			lastUnderscore = false
+		} else if !lastUnderscore {
+			b.WriteRune('_')
+			lastUnderscore = true
+		}
+	}
+	result := strings.TrimRight(b.String(), "_")
+	return result, result != ""
+}
+
+// normalizeTags normalizes tags into a new slice, leaving out the ones nothing is left of
+func normalizeTags(tags []string) []string {
+	result := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		if normalized, ok := normalizeTag(tag); ok {
+			result = append(result, normalized)
+		}
+	}
+	return result
+}
+
+// makeTagGuard creates a guard allowing limit distinct values per tag key and metric. Zero or a negative limit turns
+// the guard off.
+func makeTagGuard(limit int, overflow string) *tagGuard {
+	if overflow != TagOverflowRewrite {
+		overflow = TagOverflowDrop
+	}
+	return &tagGuard{
+		limit:    limit,
+		overflow: overflow,
+		values:   map[string]map[string]map[string]struct{}{},
+		warned:   map[string]struct{}{},
+	}
+}
+
+// apply drops or rewrites the tags of metric whose values are over the limit. The tags are modified in place.
+func (g *tagGuard) apply(metric string, tags []string) []string {
+	if g.limit <= 0 {
+		return tags
+	}
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	keys, ok := g.values[metric]
+	if !ok {
+		keys = map[string]map[string]struct{}{}
+		g.values[metric] = keys
+	}
+
+	result := tags[:0]
+	var overLimit []string
+	for _, tag := range tags {
+		key, value, hasKey := strings.Cut(tag, ":")
+		if !hasKey {
+			key, value = "", tag
+		}
+		values, ok := keys[key]
+		if !ok {
+			values = map[string]struct{}{}
+			keys[key] = values
+		}
+		if _, seen := values[value]; !seen {
+			if len(values) >= g.limit {
+				overLimit = append(overLimit, tag)
+				if g.overflow == TagOverflowRewrite {
+					result = append(result, rewriteTag(key, hasKey))
+				}
+				continue
+			}
+			values[value] = struct{}{}
+		}
+		result = append(result, tag)
+	}
+
+	if len(overLimit) > 0 {
+		g.warnOnce(metric, fmt.Sprintf("tag values of metric %s are over the limit of %d distinct values per tag, %s tags such as %s", metric, g.limit, g.overflowVerb(), overLimit[0]))
+	}
+	return result
+}
+
+// dropMetric logs once that metric is dropped because nothing is left of its name once normalized
+func (g *tagGuard) dropMetric(metric string) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	g.warnOnce(metric, fmt.Sprintf("dropping metric %q: its name must contain a letter", metric))
+}
+
+// warnOnce logs message the first time something is dropped for metric. It must be called with the mutex held.
+func (g *tagGuard) warnOnce(metric string, message string) {
+	if _, ok := g.warned[metric]; ok {
+		return
+	}
+	g.warned[metric] = struct{}{}
+	logger.Warn(message)
+}
+
+func (g *tagGuard) overflowVerb() string {
+	if g.overflow == TagOverflowRewrite {
+		return "rewriting"
+	}
+	return "dropping"
+}
+
+// rewriteTag returns the tag replacing the values of key that are over the limit
+func rewriteTag(key string, hasKey bool) string {
+	if !hasKey {
+		return overflowTagValue
+	}
+	return fmt.Sprintf("%s:%s", key, overflowTagValue)
+}
@@ -0,0 +1,105 @@
+/*
+ * Unless explicitly stated otherwise all files in this repository are licensed
+ * under the Apache License Version 2.0.
+ *
+ * This product includes software developed at Datadog (https://www.datadoghq.com/).
+ * Copyright 2021 Datadog, Inc.
+ */
+
+package metrics
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNormalizeMetricName(t *testing.T) {
+	testCases := []struct {
+		name     string
+		expected string
+		ok       bool
+	}{
+		{"aws.lambda.enhanced.invocations", "aws.lambda.enhanced.invocations", true},
+		{"my-metric", "my_metric", true},
+		{"My Metric!!", "My_Metric", true},
+		{"__1st.metric", "st.metric", true},
+		{"café.orders", "caf_.orders", true},
+		{strings.Repeat("a", 250), strings.Repeat("a", maxMetricNameLength), true},
+		{"123", "", false},
+		{"", "", false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			name, ok := normalizeMetricName(tc.name)
+			assert.Equal(t, tc.ok, ok)
+			assert.Equal(t, tc.expected, name)
+		})
+	}
+}
+
+func TestNormalizeTag(t *testing.T) {
+	testCases := []struct {
+		tag      string
+		expected string
+		ok       bool
+	}{
+		{"env:prod", "env:prod", true},
+		{"Region:US-East-1", "region:us-east-1", true},
+		{"path:/api/v1/users", "path:/api/v1/users", true},
+		{"user name:John  Doe", "user_name:john_doe", true},
+		{"trailing:value!!", "trailing:value", true},
+		{"ünïcode:välue", "ünïcode:välue", true},
+		{"#hashtag", "hashtag", true},
+		{strings.Repeat("é", 150), strings.Repeat("é", maxTagLength/2), true},
+		{"::", "", false},
+		{"", "", false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.tag, func(t *testing.T) {
+			tag, ok := normalizeTag(tc.tag)
+			assert.Equal(t, tc.ok, ok)
+			assert.Equal(t, tc.expected, tag)
+		})
+	}
+}
+
+func TestTagGuardDropsValuesOverLimit(t *testing.T) {
+	guard := makeTagGuard(2, TagOverflowDrop)
+
+	assert.Equal(t, []string{"request:1", "env:prod"}, guard.apply("metric-1", []string{"request:1", "env:prod"}))
+	assert.Equal(t, []string{"request:2", "env:prod"}, guard.apply("metric-1", []string{"request:2", "env:prod"}))
+	output := captureOutput(func() {
+		assert.Equal(t, []string{"env:prod"}, guard.apply("metric-1", []string{"request:3", "env:prod"}))
+		assert.Equal(t, []string{"env:prod"}, guard.apply("metric-1", []string{"request:4", "env:prod"}))
+	})
+	// Values already seen are kept, and other metrics have their own limit
+	assert.Equal(t, []string{"request:1"}, guard.apply("metric-1", []string{"request:1"}))
+	assert.Equal(t, []string{"request:3"}, guard.apply("metric-2", []string{"request:3"}))
+
+	assert.Equal(t, 1, strings.Count(output, "over the limit of 2 distinct values per tag, dropping tags such as request:3"))
+}
+
+func TestTagGuardRewritesValuesOverLimit(t *testing.T) {
+	guard := makeTagGuard(1, TagOverflowRewrite)
+
+	assert.Equal(t, []string{"request:1", "a"}, guard.apply("metric-1", []string{"request:1", "a"}))
+	output := captureOutput(func() {
+		assert.Equal(t, []string{"request:overflow", "overflow"}, guard.apply("metric-1", []string{"request:2", "b"}))
+	})
+	assert.Contains(t, output, "rewriting tags such as request:2")
+}
+
+func TestTagGuardDisabled(t *testing.T) {
+	for _, limit := range []int{0, -1} {
+		guard := makeTagGuard(limit, TagOverflowDrop)
+		for i := 0; i < 10; i++ {
+			tag := fmt.Sprintf("request:%d", i)
+			assert.Equal(t, []string{tag}, guard.apply("metric-1", []string{tag}))
+		}
+	}
+}
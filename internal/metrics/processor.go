@@ -26,6 +26,9 @@ type (
 		AddMetric(metric Metric)
 		// StartProcessing begins processing metrics asynchronously
 		StartProcessing()
+		// Flush sends the metrics added so far and waits for the send to complete. The send is cancelled with ctx,
+		// typically the context of the invocation that just ended.
+		Flush(ctx context.Context)
 		// FinishProcessing shuts down the agent, and tries to flush any remaining metrics
 		FinishProcessing()
 		// Whether the processor is still processing
@@ -35,6 +38,7 @@ type (
 	processor struct {
 		context           context.Context
 		metricsChan       chan Metric
+		flushChan         chan flushRequest
 		timeService       TimeService
 		waitGroup         sync.WaitGroup
 		batchInterval     time.Duration
@@ -42,8 +46,16 @@ type (
 		batcher           *Batcher
 		shouldRetryOnFail bool
 		isProcessing      bool
+		isFinished        bool
+		finishMutex       sync.RWMutex
 		breaker           *gobreaker.CircuitBreaker
 	}
+
+	// flushRequest asks the processing goroutine to send the current batch, and is answered by closing done
+	flushRequest struct {
+		ctx  context.Context
+		done chan struct{}
+	}
 )
 
 // MakeProcessor creates a new metrics context
@@ -55,6 +67,7 @@ func MakeProcessor(ctx context.Context, client Client, timeService TimeService,
 	return &processor{
 		context:           ctx,
 		metricsChan:       make(chan Metric, 2000),
+		flushChan:         make(chan flushRequest),
 		batchInterval:     batchInterval,
 		waitGroup:         sync.WaitGroup{},
 		client:            client,
@@ -72,7 +85,7 @@ func MakeCircuitBreaker(circuitBreakerInterval time.Duration, circuitBreakerTime
 	}
 
 	st := gobreaker.Settings{
-		Name:        "post distribution_points",
+		Name:        "post metrics",
 		Interval:    circuitBreakerInterval,
 		Timeout:     circuitBreakerTimeout,
 		ReadyToTrip: readyToTrip,
@@ -81,6 +94,13 @@ func MakeCircuitBreaker(circuitBreakerInterval time.Duration, circuitBreakerTime
 }
 
 func (p *processor) AddMetric(metric Metric) {
+	p.finishMutex.RLock()
+	defer p.finishMutex.RUnlock()
+	if p.isFinished {
+		// The invocation was already reported, typically because the handler kept running past the flush deadline
+		logger.Debug("dropping metric added after the processor finished")
+		return
+	}
 	// We use a large buffer in the metrics channel, to make this operation non-blocking.
 	// However, if the channel does fill up, this will become a blocking operation.
 	p.metricsChan <- metric
@@ -95,7 +115,34 @@ func (p *processor) StartProcessing() {
 
 }
 
+func (p *processor) Flush(ctx context.Context) {
+	p.finishMutex.RLock()
+	defer p.finishMutex.RUnlock()
+	if p.isFinished {
+		return
+	}
+
+	if !p.isProcessing {
+		p.StartProcessing()
+	}
+	request := flushRequest{ctx: ctx, done: make(chan struct{})}
+	select {
+	case p.flushChan <- request:
+		<-request.done
+	case <-p.context.Done():
+		// The processing goroutine exits without flushing once its context is cancelled
+	}
+}
+
 func (p *processor) FinishProcessing() {
+	p.finishMutex.Lock()
+	if p.isFinished {
+		p.finishMutex.Unlock()
+		return
+	}
+	p.isFinished = true
+	p.finishMutex.Unlock()
+
 	if !p.isProcessing {
 		p.StartProcessing()
 	}
@@ -116,6 +163,8 @@ func (p *processor) processMetrics() {
 	shouldExit := false
 	for !shouldExit {
 		shouldSendBatch := false
+		sendContext := p.context
+		var flushed chan struct{}
 		// Batches metrics until timeout is reached
 		select {
 		case <-doneChan:
@@ -132,6 +181,12 @@ func (p *processor) processMetrics() {
 		case <-ticker.C:
 			// We are ready to send a batch to our backend
 			shouldSendBatch = true
+		case request := <-p.flushChan:
+			// The invocation ended, metrics added before the flush are still waiting in the channel
+			p.drainMetrics()
+			shouldSendBatch = true
+			sendContext = request.ctx
+			flushed = request.done
 		}
 		// Since the go select statement picks randomly if multiple values are available, it's possible the done channel was
 		// closed, but another channel was selected instead. We double check the done channel, to make sure this isn't he case.
@@ -144,16 +199,19 @@ func (p *processor) processMetrics() {
 		}
 
 		if shouldSendBatch {
+			sendMetricsBatch := func() error {
+				return p.sendMetricsBatch(sendContext)
+			}
 			_, err := p.breaker.Execute(func() (interface{}, error) {
-				if shouldExit && p.shouldRetryOnFail {
-					// If we are shutting down, and we just failed to send our last batch, do a retry
+				if (shouldExit || flushed != nil) && p.shouldRetryOnFail {
+					// If we are flushing or shutting down, and we just failed to send our last batch, do a retry
 					bo := backoff.WithMaxRetries(backoff.NewConstantBackOff(defaultRetryInterval), 2)
-					err := backoff.Retry(p.sendMetricsBatch, bo)
+					err := backoff.Retry(sendMetricsBatch, bo)
 					if err != nil {
 						return nil, fmt.Errorf("after retry: %v", err)
 					}
 				} else {
-					err := p.sendMetricsBatch()
+					err := sendMetricsBatch()
 					if err != nil {
 						return nil, fmt.Errorf("with no retry: %v", err)
 					}
@@ -164,22 +222,44 @@ func (p *processor) processMetrics() {
 				logger.Error(fmt.Errorf("failed to flush metrics to datadog API: %v", err))
 			}
 		}
+		if flushed != nil {
+			close(flushed)
+		}
 	}
 	ticker.Stop()
 	p.isProcessing = false
 	p.waitGroup.Done()
 }
 
-func (p *processor) sendMetricsBatch() error {
+// drainMetrics adds the metrics waiting in the channel to the batch, without blocking.
+func (p *processor) drainMetrics() {
+	for {
+		select {
+		case m, ok := <-p.metricsChan:
+			if !ok {
+				return
+			}
+			p.batcher.AddMetric(m)
+		default:
+			return
+		}
+	}
+}
+
+func (p *processor) sendMetricsBatch(ctx context.Context) error {
 	mts := p.batcher.ToAPIMetrics()
 	if len(mts) > 0 {
 		oldBatcher := p.batcher
 		p.batcher = MakeBatcher(p.batchInterval)
 
-		err := p.client.SendMetrics(mts)
+		err := p.client.SendMetrics(ctx, mts)
 		if err != nil {
 			if p.shouldRetryOnFail {
-				// If we want to retry on error, keep the metrics in the batcher until they are sent correctly.
+				// If we want to retry on error, keep the metrics in the batcher until they are sent correctly. When only
+				// some chunks of the batch failed, the metrics of the other chunks were sent and aren't kept.
+				if failed, ok := failedMetrics(err); ok {
+					oldBatcher.retain(failed)
+				}
 				p.batcher = oldBatcher
 			}
 			return err
@@ -187,3 +267,23 @@ func (p *processor) sendMetricsBatch() error {
 	}
 	return nil
 }
+
+// failedMetrics returns the metrics of the chunks that err reports as failed. It returns false if err isn't made of
+// chunk errors, in which case the whole batch is assumed to have failed.
+func failedMetrics(err error) ([]APIMetric, bool) {
+	switch err := err.(type) {
+	case *chunkError:
+		return err.metrics, true
+	case interface{ Unwrap() []error }:
+		var failed []APIMetric
+		for _, err := range err.Unwrap() {
+			metrics, ok := failedMetrics(err)
+			if !ok {
+				return nil, false
+			}
+			failed = append(failed, metrics...)
+		}
+		return failed, true
+	}
+	return nil, false
+}
@@ -45,7 +45,7 @@ func makeMockTimeService() mockTimeService {
 	}
 }
 
-func (mc *mockClient) SendMetrics(mts []APIMetric) error {
+func (mc *mockClient) SendMetrics(ctx context.Context, mts []APIMetric) error {
 	mc.sendMetricsCalledCount++
 	mc.batches <- mts
 	return mc.err
@@ -66,20 +66,24 @@ func TestProcessorBatches(t *testing.T) {
 	mts := makeMockTimeService()
 
 	mts.now, _ = time.Parse(time.RFC3339, "2006-01-02T15:04:05Z")
-	nowUnix := float64(mts.now.Unix())
+	nowUnix := mts.now.Truncate(sketchInterval).Unix()
 
 	processor := MakeProcessor(context.Background(), &mc, &mts, 1000, false, time.Hour*1000, time.Hour*1000, math.MaxUint32)
 
 	d1 := Distribution{
-		Name:   "metric-1",
-		Tags:   []string{"a", "b", "c"},
-		Values: []MetricValue{{Timestamp: mts.now, Value: 1}, {Timestamp: mts.now, Value: 2}, {Timestamp: mts.now, Value: 3}},
+		Name: "metric-1",
+		Tags: []string{"a", "b", "c"},
 	}
+	d1.AddPoint(mts.now, 1)
+	d1.AddPoint(mts.now, 2)
+	d1.AddPoint(mts.now, 3)
 	d2 := Distribution{
-		Name:   "metric-1",
-		Tags:   []string{"a", "b", "c"},
-		Values: []MetricValue{{Timestamp: mts.now, Value: 4}, {Timestamp: mts.now, Value: 5}, {Timestamp: mts.now, Value: 6}},
+		Name: "metric-1",
+		Tags: []string{"a", "b", "c"},
 	}
+	d2.AddPoint(mts.now, 4)
+	d2.AddPoint(mts.now, 5)
+	d2.AddPoint(mts.now, 6)
 
 	processor.AddMetric(&d1)
 	processor.AddMetric(&d2)
@@ -93,14 +97,16 @@ func TestProcessorBatches(t *testing.T) {
 		Name:       "metric-1",
 		Tags:       []string{"a", "b", "c"},
 		MetricType: DistributionType,
-		Points: []interface{}{
-			[]interface{}{nowUnix, []interface{}{float64(1)}},
-			[]interface{}{nowUnix, []interface{}{float64(2)}},
-			[]interface{}{nowUnix, []interface{}{float64(3)}},
-			[]interface{}{nowUnix, []interface{}{float64(4)}},
-			[]interface{}{nowUnix, []interface{}{float64(5)}},
-			[]interface{}{nowUnix, []interface{}{float64(6)}},
-		},
+		Sketches: []SketchPoint{{
+			Timestamp: nowUnix,
+			Count:     6,
+			Min:       1,
+			Max:       6,
+			Sum:       21,
+			Avg:       3.5,
+			Keys:      []int32{1338, 1383, 1409, 1427, 1442, 1454},
+			Counts:    []uint32{1, 1, 1, 1, 1, 1},
+		}},
 	}}, firstBatch)
 }
 
@@ -109,33 +115,35 @@ func TestProcessorBatchesPerTick(t *testing.T) {
 	mts := makeMockTimeService()
 
 	firstTime, _ := time.Parse(time.RFC3339, "2006-01-02T15:04:05Z")
-	firstTimeUnix := float64(firstTime.Unix())
+	firstTimeUnix := firstTime.Truncate(sketchInterval).Unix()
 	secondTime, _ := time.Parse(time.RFC3339, "2007-01-02T15:04:05Z")
-	secondTimeUnix := float64(secondTime.Unix())
+	secondTimeUnix := secondTime.Truncate(sketchInterval).Unix()
 	mts.now = firstTime
 
 	processor := MakeProcessor(context.Background(), &mc, &mts, 1000, false, time.Hour*1000, time.Hour*1000, math.MaxUint32)
 
 	d1 := Distribution{
-		Name:   "metric-1",
-		Tags:   []string{"a", "b", "c"},
-		Values: []MetricValue{{Timestamp: firstTime, Value: 1}, {Timestamp: firstTime, Value: 2}},
+		Name: "metric-1",
+		Tags: []string{"a", "b", "c"},
 	}
+	d1.AddPoint(firstTime, 1)
+	d1.AddPoint(firstTime, 2)
 	d2 := Distribution{
-		Name:   "metric-1",
-		Tags:   []string{"a", "b", "c"},
-		Values: []MetricValue{{Timestamp: firstTime, Value: 3}},
+		Name: "metric-1",
+		Tags: []string{"a", "b", "c"},
 	}
+	d2.AddPoint(firstTime, 3)
 	d3 := Distribution{
-		Name:   "metric-1",
-		Tags:   []string{"a", "b", "c"},
-		Values: []MetricValue{{Timestamp: secondTime, Value: 4}, {Timestamp: secondTime, Value: 5}},
+		Name: "metric-1",
+		Tags: []string{"a", "b", "c"},
 	}
+	d3.AddPoint(secondTime, 4)
+	d3.AddPoint(secondTime, 5)
 	d4 := Distribution{
-		Name:   "metric-1",
-		Tags:   []string{"a", "b", "c"},
-		Values: []MetricValue{{Timestamp: secondTime, Value: 6}},
+		Name: "metric-1",
+		Tags: []string{"a", "b", "c"},
 	}
+	d4.AddPoint(secondTime, 6)
 
 	processor.StartProcessing()
 
@@ -162,22 +170,32 @@ func TestProcessorBatchesPerTick(t *testing.T) {
 				Name:       "metric-1",
 				Tags:       []string{"a", "b", "c"},
 				MetricType: DistributionType,
-				Points: []interface{}{
-					[]interface{}{firstTimeUnix, []interface{}{float64(1)}},
-					[]interface{}{firstTimeUnix, []interface{}{float64(2)}},
-					[]interface{}{firstTimeUnix, []interface{}{float64(3)}},
-				},
+				Sketches: []SketchPoint{{
+					Timestamp: firstTimeUnix,
+					Count:     3,
+					Min:       1,
+					Max:       3,
+					Sum:       6,
+					Avg:       2,
+					Keys:      []int32{1338, 1383, 1409},
+					Counts:    []uint32{1, 1, 1},
+				}},
 			}},
 		[]APIMetric{
 			{
 				Name:       "metric-1",
 				Tags:       []string{"a", "b", "c"},
 				MetricType: DistributionType,
-				Points: []interface{}{
-					[]interface{}{secondTimeUnix, []interface{}{float64(4)}},
-					[]interface{}{secondTimeUnix, []interface{}{float64(5)}},
-					[]interface{}{secondTimeUnix, []interface{}{float64(6)}},
-				},
+				Sketches: []SketchPoint{{
+					Timestamp: secondTimeUnix,
+					Count:     3,
+					Min:       4,
+					Max:       6,
+					Sum:       15,
+					Avg:       5,
+					Keys:      []int32{1427, 1442, 1454},
+					Counts:    []uint32{1, 1, 1},
+				}},
 			}},
 	}, batches)
 }
@@ -192,10 +210,12 @@ func TestProcessorPerformsRetry(t *testing.T) {
 	processor := MakeProcessor(context.Background(), &mc, &mts, 1000, shouldRetry, time.Hour*1000, time.Hour*1000, math.MaxUint32)
 
 	d1 := Distribution{
-		Name:   "metric-1",
-		Tags:   []string{"a", "b", "c"},
-		Values: []MetricValue{{Timestamp: mts.now, Value: 1}, {Timestamp: mts.now, Value: 2}, {Timestamp: mts.now, Value: 3}},
+		Name: "metric-1",
+		Tags: []string{"a", "b", "c"},
 	}
+	d1.AddPoint(mts.now, 1)
+	d1.AddPoint(mts.now, 2)
+	d1.AddPoint(mts.now, 3)
 
 	mc.err = errors.New("Some error")
 
@@ -217,10 +237,12 @@ func TestProcessorCancelsWithContext(t *testing.T) {
 	processor := MakeProcessor(ctx, &mc, &mts, 1000, shouldRetry, time.Hour*1000, time.Hour*1000, math.MaxUint32)
 
 	d1 := Distribution{
-		Name:   "metric-1",
-		Tags:   []string{"a", "b", "c"},
-		Values: []MetricValue{{Timestamp: mts.now, Value: 1}, {Timestamp: mts.now, Value: 2}, {Timestamp: mts.now, Value: 3}},
+		Name: "metric-1",
+		Tags: []string{"a", "b", "c"},
 	}
+	d1.AddPoint(mts.now, 1)
+	d1.AddPoint(mts.now, 2)
+	d1.AddPoint(mts.now, 3)
 
 	processor.AddMetric(&d1)
 	// After calling cancelFunc, no metrics should be processed/sent
@@ -243,10 +265,12 @@ func TestProcessorBatchesWithOpeningCircuitBreaker(t *testing.T) {
 	processor := MakeProcessor(context.Background(), &mc, &mts, 1000, false, time.Hour*1000, time.Hour*1000, circuitBreakerTotalFailures)
 
 	d1 := Distribution{
-		Name:   "metric-1",
-		Tags:   []string{"a", "b", "c"},
-		Values: []MetricValue{{Timestamp: mts.now, Value: 1}, {Timestamp: mts.now, Value: 2}, {Timestamp: mts.now, Value: 3}},
+		Name: "metric-1",
+		Tags: []string{"a", "b", "c"},
 	}
+	d1.AddPoint(mts.now, 1)
+	d1.AddPoint(mts.now, 2)
+	d1.AddPoint(mts.now, 3)
 
 	mc.err = errors.New("Some error")
 
@@ -257,3 +281,138 @@ func TestProcessorBatchesWithOpeningCircuitBreaker(t *testing.T) {
 	// It should have retried 3 times, but circuit breaker opened at the second time
 	assert.Equal(t, 1, mc.sendMetricsCalledCount)
 }
+
+func TestProcessorDropsMetricsAfterFinishing(t *testing.T) {
+	mc := makeMockClient()
+	mts := makeMockTimeService()
+
+	processor := MakeProcessor(context.Background(), &mc, &mts, 1000, false, time.Hour*1000, time.Hour*1000, math.MaxUint32)
+	processor.StartProcessing()
+	processor.FinishProcessing()
+
+	d1 := Distribution{
+		Name: "metric-1",
+		Tags: []string{"a", "b", "c"},
+	}
+	d1.AddPoint(mts.now, 1)
+	assert.NotPanics(t, func() {
+		processor.AddMetric(&d1)
+		processor.FinishProcessing()
+	})
+	assert.Equal(t, 0, mc.sendMetricsCalledCount)
+}
+
+func TestProcessorFlush(t *testing.T) {
+	mc := makeMockClient()
+	mts := makeMockTimeService()
+
+	processor := MakeProcessor(context.Background(), &mc, &mts, 1000, false, time.Hour*1000, time.Hour*1000, math.MaxUint32)
+	processor.StartProcessing()
+
+	d1 := Distribution{
+		Name: "metric-1",
+		Tags: []string{"a", "b", "c"},
+	}
+	d1.AddPoint(mts.now, 1)
+	for i := 1; i <= 2; i++ {
+		processor.AddMetric(&d1)
+		processor.Flush(context.Background())
+		assert.Equal(t, i, mc.sendMetricsCalledCount)
+		assert.True(t, processor.IsProcessing())
+	}
+
+	// Flushing without metrics doesn't send anything
+	processor.Flush(context.Background())
+	assert.Equal(t, 2, mc.sendMetricsCalledCount)
+
+	processor.FinishProcessing()
+	assert.False(t, processor.IsProcessing())
+}
+
+func TestProcessorRetriesAcrossFlushes(t *testing.T) {
+	mc := makeMockClient()
+	mts := makeMockTimeService()
+
+	shouldRetry := true
+	processor := MakeProcessor(context.Background(), &mc, &mts, 1000, shouldRetry, time.Hour*1000, time.Hour*1000, math.MaxUint32)
+	processor.StartProcessing()
+
+	d1 := Distribution{
+		Name: "metric-1",
+		Tags: []string{"a", "b", "c"},
+	}
+	d1.AddPoint(mts.now, 1)
+	mc.err = errors.New("Some error")
+	processor.AddMetric(&d1)
+	processor.Flush(context.Background())
+	assert.Equal(t, 3, mc.sendMetricsCalledCount)
+
+	// The metrics that failed to send are sent with the next flush
+	mc.err = nil
+	processor.Flush(context.Background())
+	assert.Equal(t, 4, mc.sendMetricsCalledCount)
+	for i := 0; i < 4; i++ {
+		batch := <-mc.batches
+		assert.Len(t, batch, 1)
+	}
+	processor.FinishProcessing()
+}
+
+func TestProcessorCircuitBreakerAcrossFlushes(t *testing.T) {
+	mc := makeMockClient()
+	mts := makeMockTimeService()
+
+	processor := MakeProcessor(context.Background(), &mc, &mts, 1000, false, time.Hour*1000, time.Hour*1000, 1)
+	processor.StartProcessing()
+
+	d1 := Distribution{
+		Name: "metric-1",
+		Tags: []string{"a", "b", "c"},
+	}
+	d1.AddPoint(mts.now, 1)
+	mc.err = errors.New("Some error")
+	for i := 0; i < 4; i++ {
+		processor.AddMetric(&d1)
+		processor.Flush(context.Background())
+	}
+	// The breaker opens after the second failure, and stays open for the next flushes
+	assert.Equal(t, 2, mc.sendMetricsCalledCount)
+	processor.FinishProcessing()
+}
+
+func TestProcessorRetriesOnlyFailedChunks(t *testing.T) {
+	mc := makeMockClient()
+	mts := makeMockTimeService()
+
+	shouldRetry := true
+	processor := MakeProcessor(context.Background(), &mc, &mts, 1000, shouldRetry, time.Hour*1000, time.Hour*1000, math.MaxUint32)
+	processor.StartProcessing()
+
+	g1 := Gauge{Name: "metric-1", Tags: []string{"a"}}
+	g1.AddPoint(mts.now, 1)
+	g2 := Gauge{Name: "metric-2", Tags: []string{"a"}}
+	g2.AddPoint(mts.now, 2)
+	failedChunk := &chunkError{
+		index:   1,
+		total:   2,
+		metrics: []APIMetric{{Name: "metric-1", Tags: []string{"a"}, MetricType: GaugeType}},
+		err:     errors.New("Some error"),
+	}
+	mc.err = errors.Join(failedChunk)
+	processor.AddMetric(&g1)
+	processor.AddMetric(&g2)
+	processor.Flush(context.Background())
+	assert.Equal(t, 3, mc.sendMetricsCalledCount)
+
+	mc.err = nil
+	processor.Flush(context.Background())
+	assert.Equal(t, 4, mc.sendMetricsCalledCount)
+
+	assert.Len(t, <-mc.batches, 2)
+	for i := 0; i < 3; i++ {
+		batch := <-mc.batches
+		assert.Len(t, batch, 1)
+		assert.Equal(t, "metric-1", batch[0].Name)
+	}
+	processor.FinishProcessing()
+}
@@ -0,0 +1,114 @@
+/*
+ * Unless explicitly stated otherwise all files in this repository are licensed
+ * under the Apache License Version 2.0.
+ *
+ * This product includes software developed at Datadog (https://www.datadoghq.com/).
+ * Copyright 2021 Datadog, Inc.
+ */
+
+package metrics
+
+import (
+	"encoding/json"
+	"errors"
+)
+
+// Series metrics are sent to the v2 series intake, which types the points of each metric and carries their unit and
+// origin. Functions don't run on a host of their own, so no host resource is sent. The v1 series intake remains a
+// fallback for sites and proxies that don't expose it.
+const (
+	// seriesAPIV1 and seriesAPIV2 are the versions of the series endpoint
+	seriesAPIV1 = 1
+	seriesAPIV2 = 2
+
+	// seriesV2TypeCount, seriesV2TypeRate and seriesV2TypeGauge are the metric types of the v2 series intake
+	seriesV2TypeCount = 1
+	seriesV2TypeRate  = 2
+	seriesV2TypeGauge = 3
+
+	// seriesV2OriginProductServerless is the origin product of metrics sent from serverless environments
+	seriesV2OriginProductServerless = 1
+)
+
+// errEndpointNotFound is returned when the intake doesn't expose the endpoint a payload was posted to
+var errEndpointNotFound = errors.New("endpoint not found")
+
+type (
+	seriesV2Model struct {
+		Series []seriesV2Metric `json:"series"`
+	}
+
+	seriesV2Metric struct {
+		Metric   string           `json:"metric"`
+		Type     int              `json:"type"`
+		Points   []seriesV2Point  `json:"points"`
+		Tags     []string         `json:"tags,omitempty"`
+		Unit     string           `json:"unit,omitempty"`
+		Interval int64            `json:"interval,omitempty"`
+		Metadata seriesV2Metadata `json:"metadata"`
+	}
+
+	seriesV2Point struct {
+		Timestamp int64   `json:"timestamp"`
+		Value     float64 `json:"value"`
+	}
+
+	seriesV2Metadata struct {
+		Origin seriesV2Origin `json:"origin"`
+	}
+
+	seriesV2Origin struct {
+		Product int `json:"product"`
+	}
+)
+
+// marshalSeriesV2Model encodes metrics as a payload of the v2 series intake
+func marshalSeriesV2Model(metrics []APIMetric) ([]byte, error) {
+	pm := seriesV2Model{Series: make([]seriesV2Metric, 0, len(metrics))}
+	for _, metric := range metrics {
+		pm.Series = append(pm.Series, toSeriesV2Metric(metric))
+	}
+	return json.Marshal(pm)
+}
+
+func toSeriesV2Metric(metric APIMetric) seriesV2Metric {
+	series := seriesV2Metric{
+		Metric:   metric.Name,
+		Type:     seriesV2TypeGauge,
+		Points:   toSeriesV2Points(metric.Points),
+		Tags:     metric.Tags,
+		Unit:     metric.Unit,
+		Metadata: seriesV2Metadata{Origin: seriesV2Origin{Product: seriesV2OriginProductServerless}},
+	}
+	switch metric.MetricType {
+	case CountType:
+		series.Type = seriesV2TypeCount
+	case RateType:
+		series.Type = seriesV2TypeRate
+	}
+	if metric.Interval != nil {
+		series.Interval = int64(*metric.Interval)
+	}
+	return series
+}
+
+// toSeriesV2Points converts the [timestamp, value] pairs of the v1 format into typed points
+func toSeriesV2Points(points []interface{}) []seriesV2Point {
+	result := make([]seriesV2Point, 0, len(points))
+	for _, point := range points {
+		pair, ok := point.([]interface{})
+		if !ok || len(pair) != 2 {
+			continue
+		}
+		timestamp, ok := pair[0].(float64)
+		if !ok {
+			continue
+		}
+		value, ok := pair[1].(float64)
+		if !ok {
+			continue
+		}
+		result = append(result, seriesV2Point{Timestamp: int64(timestamp), Value: value})
+	}
+	return result
+}
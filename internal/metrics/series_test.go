@@ -0,0 +1,69 @@
+/*
+ * Unless explicitly stated otherwise all files in this repository are licensed
+ * under the Apache License Version 2.0.
+ *
+ * This product includes software developed at Datadog (https://www.datadoghq.com/).
+ * Copyright 2021 Datadog, Inc.
+ */
+
+package metrics
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMarshalSeriesV2Model(t *testing.T) {
+	tm := time.Unix(1000, 0)
+	gauge := Gauge{Name: "metric-1", Tags: []string{"a:b"}, Unit: "byte"}
+	gauge.AddPoint(tm, 2)
+	count := Count{Name: "metric-2", Unit: "second"}
+	count.AddPoint(tm, 3)
+	rate := Rate{Name: "metric-3"}
+	rate.AddPoint(tm, 20)
+
+	am := append(gauge.ToAPIMetric(10), count.ToAPIMetric(10)...)
+	am = append(am, rate.ToAPIMetric(10)...)
+	payload, err := marshalSeriesV2Model(am)
+
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"series":[
+		{
+			"metric":"metric-1",
+			"type":3,
+			"points":[{"timestamp":1000,"value":2}],
+			"tags":["a:b"],
+			"unit":"byte",
+			"metadata":{"origin":{"product":1}}
+		},
+		{
+			"metric":"metric-2",
+			"type":1,
+			"points":[{"timestamp":1000,"value":3}],
+			"unit":"second",
+			"interval":10,
+			"metadata":{"origin":{"product":1}}
+		},
+		{
+			"metric":"metric-3",
+			"type":2,
+			"points":[{"timestamp":1000,"value":2}],
+			"interval":10,
+			"metadata":{"origin":{"product":1}}
+		}
+	]}`, string(payload))
+}
+
+func TestPayloadSizeLimits(t *testing.T) {
+	cl := MakeAPIClient(APIClientOptions{baseAPIURL: "https://api.datadoghq.com"})
+
+	maxSize, maxUncompressedSize := cl.payloadSizeLimits(cl.routeURL(seriesV2Route))
+	assert.Equal(t, maxSeriesV2PayloadSize, maxSize)
+	assert.Equal(t, maxSeriesV2UncompressedPayloadSize, maxUncompressedSize)
+
+	maxSize, maxUncompressedSize = cl.payloadSizeLimits(cl.routeURL(seriesV1Route))
+	assert.Equal(t, maxPayloadSize, maxSize)
+	assert.Equal(t, maxUncompressedPayloadSize, maxUncompressedSize)
+}
@@ -0,0 +1,182 @@
+/*
+ * Unless explicitly stated otherwise all files in this repository are licensed
+ * under the Apache License Version 2.0.
+ *
+ * This product includes software developed at Datadog (https://www.datadoghq.com/).
+ * Copyright 2021 Datadog, Inc.
+ */
+
+package metrics
+
+import (
+	"math"
+	"sort"
+	"time"
+
+	"github.com/DataDog/sketches-go/ddsketch"
+	"github.com/DataDog/sketches-go/ddsketch/mapping"
+	"github.com/DataDog/sketches-go/ddsketch/store"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// Distributions are aggregated into sketches as points are added, so that the memory they use and the size of the
+// payload stay bounded however many points are recorded. The sketches index values the same way as the sketches of
+// the Datadog Agent, so that their bins can be submitted as is to the sketches intake.
+const (
+	// sketchRelativeAccuracy is the relative accuracy of the Agent's sketches
+	sketchRelativeAccuracy = 1.0 / 128
+	// sketchMinValue is the smallest magnitude the Agent's sketches tell apart from zero
+	sketchMinValue = 1e-9
+	// sketchMaxBins is the number of bins above which the lowest bins of a sketch are collapsed together
+	sketchMaxBins = 4096
+	// sketchMaxKey is the largest key of the Agent's sketches
+	sketchMaxKey = math.MaxInt16
+	// sketchMaxBinCount is the largest count of a single bin of the Agent's sketches. Larger counts are split
+	// between several bins with the same key.
+	sketchMaxBinCount = math.MaxUint16
+	// sketchInterval is the length of the intervals a distribution has a sketch for, like in the Agent
+	sketchInterval = 10 * time.Second
+)
+
+var (
+	// sketchGamma is the ratio between the bounds of a bin
+	sketchGamma = 1 + 2*sketchRelativeAccuracy
+	// sketchKeyBias shifts the keys so that sketchMinValue is the lower bound of the first key
+	sketchKeyBias = 1 - int(math.Floor(math.Log(sketchMinValue)/math.Log1p(2*sketchRelativeAccuracy)))
+	// sketchMapping rounds values to the nearest key, like the Agent does, instead of truncating them
+	sketchMapping, _ = mapping.NewLogarithmicMappingWithGamma(sketchGamma, float64(sketchKeyBias)+0.5)
+)
+
+type (
+	// SketchPoint summarizes the values of a distribution over an interval, in the format of the sketches intake
+	SketchPoint struct {
+		Timestamp int64
+		Count     int64
+		Min       float64
+		Max       float64
+		Sum       float64
+		Avg       float64
+		Keys      []int32
+		Counts    []uint32
+	}
+)
+
+// newSketch creates an empty sketch using the Agent's index mapping
+func newSketch() *ddsketch.DDSketchWithExactSummaryStatistics {
+	return ddsketch.NewDDSketchWithExactSummaryStatistics(sketchMapping, func() store.Store {
+		return store.NewCollapsingLowestDenseStore(sketchMaxBins)
+	})
+}
+
+// toSketchPoint converts a sketch into the format of the sketches intake
+func toSketchPoint(timestamp int64, sketch *ddsketch.DDSketchWithExactSummaryStatistics) SketchPoint {
+	point := SketchPoint{
+		Timestamp: timestamp,
+		Count:     int64(sketch.GetCount()),
+		Sum:       sketch.GetSum(),
+	}
+	point.Min, _ = sketch.GetMinValue()
+	point.Max, _ = sketch.GetMaxValue()
+	if sketch.GetCount() > 0 {
+		point.Avg = point.Sum / sketch.GetCount()
+	}
+
+	// Indexes can be clamped into the same key, so the counts are added up before being split into bins
+	counts := map[int32]float64{}
+	if zeroCount := sketch.GetZeroCount(); zeroCount > 0 {
+		counts[0] += zeroCount
+	}
+	sketch.GetPositiveValueStore().ForEach(func(index int, count float64) bool {
+		counts[toSketchKey(index)] += count
+		return false
+	})
+	sketch.GetNegativeValueStore().ForEach(func(index int, count float64) bool {
+		counts[-toSketchKey(index)] += count
+		return false
+	})
+
+	keys := make([]int32, 0, len(counts))
+	for key := range counts {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i] < keys[j] })
+
+	for _, key := range keys {
+		for remaining := uint64(math.Round(counts[key])); remaining > 0; {
+			n := remaining
+			if n > sketchMaxBinCount {
+				n = sketchMaxBinCount
+			}
+			point.Keys = append(point.Keys, key)
+			point.Counts = append(point.Counts, uint32(n))
+			remaining -= n
+		}
+	}
+	return point
+}
+
+// toSketchKey converts the index of a positive value into a key of the Agent's sketches. Values too small to be
+// indexed by the Agent fall into the zero key.
+func toSketchKey(index int) int32 {
+	if index < 1 {
+		return 0
+	}
+	if index > sketchMaxKey {
+		return sketchMaxKey
+	}
+	return int32(index)
+}
+
+// marshalSketchPayload encodes distributions as a SketchPayload protobuf message, as expected by the sketches intake
+func marshalSketchPayload(metrics []APIMetric) ([]byte, error) {
+	var payload []byte
+	for _, metric := range metrics {
+		payload = protowire.AppendTag(payload, 1, protowire.BytesType)
+		payload = protowire.AppendBytes(payload, marshalSketch(metric))
+	}
+	return payload, nil
+}
+
+func marshalSketch(metric APIMetric) []byte {
+	var b []byte
+	b = protowire.AppendTag(b, 1, protowire.BytesType)
+	b = protowire.AppendString(b, metric.Name)
+	if metric.Host != nil {
+		b = protowire.AppendTag(b, 2, protowire.BytesType)
+		b = protowire.AppendString(b, *metric.Host)
+	}
+	for _, tag := range metric.Tags {
+		b = protowire.AppendTag(b, 4, protowire.BytesType)
+		b = protowire.AppendString(b, tag)
+	}
+	for _, point := range metric.Sketches {
+		b = protowire.AppendTag(b, 7, protowire.BytesType)
+		b = protowire.AppendBytes(b, marshalDogsketch(point))
+	}
+	return b
+}
+
+func marshalDogsketch(point SketchPoint) []byte {
+	var b []byte
+	b = protowire.AppendTag(b, 1, protowire.VarintType)
+	b = protowire.AppendVarint(b, uint64(point.Timestamp))
+	b = protowire.AppendTag(b, 2, protowire.VarintType)
+	b = protowire.AppendVarint(b, uint64(point.Count))
+	for i, value := range []float64{point.Min, point.Max, point.Avg, point.Sum} {
+		b = protowire.AppendTag(b, protowire.Number(3+i), protowire.Fixed64Type)
+		b = protowire.AppendFixed64(b, math.Float64bits(value))
+	}
+
+	var keys, counts []byte
+	for _, key := range point.Keys {
+		keys = protowire.AppendVarint(keys, protowire.EncodeZigZag(int64(key)))
+	}
+	for _, count := range point.Counts {
+		counts = protowire.AppendVarint(counts, uint64(count))
+	}
+	b = protowire.AppendTag(b, 7, protowire.BytesType)
+	b = protowire.AppendBytes(b, keys)
+	b = protowire.AppendTag(b, 8, protowire.BytesType)
+	b = protowire.AppendBytes(b, counts)
+	return b
+}
@@ -0,0 +1,150 @@
+/*
+ * Unless explicitly stated otherwise all files in this repository are licensed
+ * under the Apache License Version 2.0.
+ *
+ * This product includes software developed at Datadog (https://www.datadoghq.com/).
+ * Copyright 2021 Datadog, Inc.
+ */
+
+package metrics
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+func TestSketchPointUsesAgentKeys(t *testing.T) {
+	sketch := newSketch()
+	for _, value := range []float64{-2, 0, 1e-12, 1, 2} {
+		assert.NoError(t, sketch.Add(value))
+	}
+
+	point := toSketchPoint(10, sketch)
+
+	assert.Equal(t, SketchPoint{
+		Timestamp: 10,
+		Count:     5,
+		Min:       -2,
+		Max:       2,
+		Sum:       sketch.GetSum(),
+		Avg:       sketch.GetSum() / 5,
+		Keys:      []int32{-1383, 0, 1338, 1383},
+		Counts:    []uint32{1, 2, 1, 1},
+	}, point)
+}
+
+func TestSketchPointSplitsLargeBins(t *testing.T) {
+	sketch := newSketch()
+	assert.NoError(t, sketch.AddWithCount(1, 70000))
+
+	point := toSketchPoint(10, sketch)
+
+	assert.Equal(t, []int32{1338, 1338}, point.Keys)
+	assert.Equal(t, []uint32{65535, 4465}, point.Counts)
+}
+
+func TestDistributionSizeIsBounded(t *testing.T) {
+	tm := time.Now().Truncate(sketchInterval)
+	distribution := Distribution{Name: "metric-1"}
+	for i := 0; i < 100000; i++ {
+		distribution.AddPoint(tm, float64(i%1000))
+	}
+
+	apiMetrics := distribution.ToAPIMetric(10)
+
+	assert.Len(t, apiMetrics[0].Sketches, 1)
+	point := apiMetrics[0].Sketches[0]
+	assert.Equal(t, int64(100000), point.Count)
+	assert.Equal(t, float64(0), point.Min)
+	assert.Equal(t, float64(999), point.Max)
+	assert.LessOrEqual(t, len(point.Keys), sketchMaxBins)
+	payload, err := marshalSketchPayload(apiMetrics)
+	assert.NoError(t, err)
+	assert.Less(t, len(payload), 2000)
+}
+
+func TestDistributionHasASketchPerInterval(t *testing.T) {
+	tm := time.Now().Truncate(sketchInterval)
+	d1 := Distribution{Name: "metric-1"}
+	d1.AddPoint(tm, 1)
+	d1.AddPoint(tm.Add(sketchInterval-time.Second), 2)
+	d2 := Distribution{Name: "metric-1"}
+	d2.AddPoint(tm.Add(sketchInterval), 3)
+
+	d1.Join(&d2)
+	points := d1.ToAPIMetric(10)[0].Sketches
+
+	assert.Len(t, points, 2)
+	assert.Equal(t, tm.Unix(), points[0].Timestamp)
+	assert.Equal(t, int64(2), points[0].Count)
+	assert.Equal(t, tm.Add(sketchInterval).Unix(), points[1].Timestamp)
+	assert.Equal(t, int64(1), points[1].Count)
+}
+
+func TestMarshalSketchPayload(t *testing.T) {
+	host := "my-host"
+	payload, err := marshalSketchPayload([]APIMetric{
+		{
+			Name:       "metric-1",
+			Host:       &host,
+			Tags:       []string{"a", "b"},
+			MetricType: DistributionType,
+			Sketches: []SketchPoint{
+				{Timestamp: 10, Count: 2, Min: -2, Max: 2, Sum: 0, Avg: 0, Keys: []int32{-1383, 1383}, Counts: []uint32{1, 1}},
+			},
+		},
+	})
+	assert.NoError(t, err)
+
+	sketches := consumeFields(t, payload)
+	assert.Len(t, sketches[1], 1)
+
+	sketch := consumeFields(t, sketches[1][0].([]byte))
+	assert.Equal(t, []interface{}{[]byte("metric-1")}, sketch[1])
+	assert.Equal(t, []interface{}{[]byte("my-host")}, sketch[2])
+	assert.Equal(t, []interface{}{[]byte("a"), []byte("b")}, sketch[4])
+	assert.Len(t, sketch[7], 1)
+
+	dogsketch := consumeFields(t, sketch[7][0].([]byte))
+	assert.Equal(t, []interface{}{uint64(10)}, dogsketch[1])
+	assert.Equal(t, []interface{}{uint64(2)}, dogsketch[2])
+	assert.Equal(t, []interface{}{math.Float64bits(-2)}, dogsketch[3])
+	assert.Equal(t, []interface{}{math.Float64bits(2)}, dogsketch[4])
+
+	keys := dogsketch[7][0].([]byte)
+	first, n := protowire.ConsumeVarint(keys)
+	second, _ := protowire.ConsumeVarint(keys[n:])
+	assert.Equal(t, int64(-1383), protowire.DecodeZigZag(first))
+	assert.Equal(t, int64(1383), protowire.DecodeZigZag(second))
+	assert.Equal(t, []interface{}{[]byte{1, 1}}, dogsketch[8])
+}
+
+// consumeFields decodes the fields of a protobuf message by field number
+func consumeFields(t *testing.T, b []byte) map[protowire.Number][]interface{} {
+	fields := map[protowire.Number][]interface{}{}
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		assert.GreaterOrEqual(t, n, 0)
+		b = b[n:]
+
+		var value interface{}
+		switch typ {
+		case protowire.VarintType:
+			value, n = protowire.ConsumeVarint(b)
+		case protowire.Fixed64Type:
+			value, n = protowire.ConsumeFixed64(b)
+		case protowire.BytesType:
+			value, n = protowire.ConsumeBytes(b)
+		default:
+			t.Fatalf("unexpected wire type %d", typ)
+		}
+		assert.GreaterOrEqual(t, n, 0)
+		fields[num] = append(fields[num], value)
+		b = b[n:]
+	}
+	return fields
+}
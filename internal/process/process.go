@@ -0,0 +1,16 @@
+/*
+ * Unless explicitly stated otherwise all files in this repository are licensed
+ * under the Apache License Version 2.0.
+ *
+ * This product includes software developed at Datadog (https://www.datadoghq.com/).
+ * Copyright 2021 Datadog, Inc.
+ */
+
+package process
+
+import "time"
+
+// StartTime approximates the start of the init phase, as package variables are initialized right after the Go runtime
+// starts. It is shared by the cold start span and the init duration enhanced metric so that both report the same
+// phase.
+var StartTime = time.Now()
@@ -0,0 +1,100 @@
+/*
+ * Unless explicitly stated otherwise all files in this repository are licensed
+ * under the Apache License Version 2.0.
+ *
+ * This product includes software developed at Datadog (https://www.datadoghq.com/).
+ * Copyright 2021 Datadog, Inc.
+ */
+
+package trace
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/DataDog/datadog-lambda-go/internal/logger"
+	"github.com/DataDog/datadog-lambda-go/internal/process"
+	"github.com/aws/aws-lambda-go/lambdacontext"
+	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace"
+	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace/tracer"
+)
+
+const (
+	// coldStartSpanName is the operation name of the span covering the init phase of the function
+	coldStartSpanName = "aws.lambda.load"
+	// initStepSpanName is the operation name of the spans timing the init steps reported with StartInitStep
+	initStepSpanName = "aws.lambda.init"
+)
+
+type initStep struct {
+	name  string
+	start time.Time
+	end   time.Time
+}
+
+var (
+	initStepsMutex sync.Mutex
+	initSteps      []initStep
+	coldStartDone  bool
+)
+
+// StartInitStep records the start of a step of the function initialization, such as loading configuration or
+// creating SDK clients, and returns the function to call when the step completes. Steps are reported as children
+// of the cold start span of the first invocation. Steps completing after it has been reported, or when cold start
+// tracing is disabled, are dropped.
+func StartInitStep(name string) func() {
+	start := time.Now()
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			initStepsMutex.Lock()
+			defer initStepsMutex.Unlock()
+			if coldStartDone {
+				logger.Debug(fmt.Sprintf("dropping init step %s, which completed after the cold start", name))
+				return
+			}
+			initSteps = append(initSteps, initStep{name: name, start: start, end: time.Now()})
+		})
+	}
+}
+
+// skipColdStartSpans drops the init steps recorded so far, and the ones completing later, when the cold start isn't
+// reported.
+func skipColdStartSpans() {
+	initStepsMutex.Lock()
+	defer initStepsMutex.Unlock()
+	coldStartDone = true
+	initSteps = nil
+}
+
+// startColdStartSpans reports the init phase as a span covering the time from process start to the first
+// invocation, with a child span for each init step recorded so far. It only does so once per process.
+func startColdStartSpans(parent ddtrace.Span, end time.Time) {
+	initStepsMutex.Lock()
+	defer initStepsMutex.Unlock()
+	if coldStartDone {
+		return
+	}
+	coldStartDone = true
+
+	coldStartSpan := tracer.StartSpan(
+		coldStartSpanName,
+		tracer.SpanType("serverless"),
+		tracer.ChildOf(parent.Context()),
+		tracer.ResourceName(lambdacontext.FunctionName),
+		tracer.StartTime(process.StartTime),
+	)
+	for _, step := range initSteps {
+		stepSpan := tracer.StartSpan(
+			initStepSpanName,
+			tracer.SpanType("serverless"),
+			tracer.ChildOf(coldStartSpan.Context()),
+			tracer.ResourceName(step.name),
+			tracer.StartTime(step.start),
+		)
+		stepSpan.Finish(tracer.FinishTime(step.end))
+	}
+	initSteps = nil
+	coldStartSpan.Finish(tracer.FinishTime(end))
+}
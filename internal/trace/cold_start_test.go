@@ -0,0 +1,125 @@
+/*
+ * Unless explicitly stated otherwise all files in this repository are licensed
+ * under the Apache License Version 2.0.
+ *
+ * This product includes software developed at Datadog (https://www.datadoghq.com/).
+ * Copyright 2021 Datadog, Inc.
+ */
+
+package trace
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/DataDog/datadog-lambda-go/internal/extension"
+	"github.com/DataDog/datadog-lambda-go/internal/process"
+	"github.com/aws/aws-lambda-go/lambdacontext"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace/mocktracer"
+)
+
+func resetColdStart() {
+	initSteps = nil
+	coldStartDone = false
+}
+
+func TestStartInitStep(t *testing.T) {
+	resetColdStart()
+	defer resetColdStart()
+
+	done := StartInitStep("load-config")
+	done()
+	done()
+
+	assert.Len(t, initSteps, 1)
+	assert.Equal(t, "load-config", initSteps[0].name)
+	assert.False(t, initSteps[0].end.Before(initSteps[0].start))
+}
+
+func TestListenerColdStartSpans(t *testing.T) {
+	resetColdStart()
+	defer resetColdStart()
+	mt := mocktracer.Start()
+	defer mt.Stop()
+
+	StartInitStep("create-clients")()
+
+	l := Listener{
+		ddTraceEnabled:        true,
+		extensionManager:      extension.BuildExtensionManager(false),
+		traceContextExtractor: withPropagationStyles(DefaultTraceExtractor, DefaultPropagationStyleExtract),
+		coldStartTracing:      true,
+	}
+	lambdacontext.FunctionName = "MockFunctionName"
+	for _, coldStart := range []bool{true, false} {
+		//nolint
+		ctx := context.WithValue(lambdacontext.NewContext(context.Background(), &mockLambdaContext), "cold_start", coldStart)
+		ctx = l.HandlerStarted(ctx, json.RawMessage(`{}`))
+		l.HandlerFinished(ctx, nil)
+	}
+
+	spans := mt.FinishedSpans()
+	assert.Len(t, spans, 4)
+	step, load, execution := spans[0], spans[1], spans[2]
+
+	assert.Equal(t, "aws.lambda.init", step.OperationName())
+	assert.Equal(t, "create-clients", step.Tag("resource.name"))
+	assert.Equal(t, load.SpanID(), step.ParentID())
+
+	assert.Equal(t, "aws.lambda.load", load.OperationName())
+	assert.Equal(t, "MockFunctionName", load.Tag("resource.name"))
+	assert.True(t, process.StartTime.Equal(load.StartTime()))
+	assert.False(t, load.FinishTime().After(execution.StartTime().Add(time.Millisecond)))
+	assert.Equal(t, execution.SpanID(), load.ParentID())
+
+	assert.Equal(t, "aws.lambda", execution.OperationName())
+	assert.Equal(t, "aws.lambda", spans[3].OperationName())
+	assert.Empty(t, initSteps)
+
+	StartInitStep("lazy-client")()
+	assert.Empty(t, initSteps)
+}
+
+func TestListenerColdStartTracingDisabled(t *testing.T) {
+	resetColdStart()
+	defer resetColdStart()
+	mt := mocktracer.Start()
+	defer mt.Stop()
+
+	StartInitStep("create-clients")()
+
+	l := Listener{
+		ddTraceEnabled:        true,
+		extensionManager:      extension.BuildExtensionManager(false),
+		traceContextExtractor: withPropagationStyles(DefaultTraceExtractor, DefaultPropagationStyleExtract),
+	}
+	//nolint
+	ctx := context.WithValue(lambdacontext.NewContext(context.Background(), &mockLambdaContext), "cold_start", true)
+	ctx = l.HandlerStarted(ctx, json.RawMessage(`{}`))
+	l.HandlerFinished(ctx, nil)
+
+	spans := mt.FinishedSpans()
+	assert.Len(t, spans, 1)
+	assert.Equal(t, "aws.lambda", spans[0].OperationName())
+
+	StartInitStep("lazy-client")()
+	assert.Empty(t, initSteps)
+}
+
+func TestListenerColdStartTraceDisabled(t *testing.T) {
+	resetColdStart()
+	defer resetColdStart()
+
+	StartInitStep("create-clients")()
+
+	l := Listener{coldStartTracing: true}
+	//nolint
+	ctx := context.WithValue(context.Background(), "cold_start", true)
+	l.HandlerStarted(ctx, json.RawMessage(`{}`))
+
+	StartInitStep("lazy-client")()
+	assert.Empty(t, initSteps)
+}
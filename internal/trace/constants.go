@@ -13,6 +13,21 @@ const (
 	parentIDHeader         = "x-datadog-parent-id"
 	samplingPriorityHeader = "x-datadog-sampling-priority"
 	originHeader           = "x-datadog-origin"
+	tagsHeader             = "x-datadog-tags"
+
+	traceparentHeader = "traceparent"
+	tracestateHeader  = "tracestate"
+
+	b3TraceIDHeader = "x-b3-traceid"
+	b3SpanIDHeader  = "x-b3-spanid"
+	b3SampledHeader = "x-b3-sampled"
+	b3FlagsHeader   = "x-b3-flags"
+	b3SingleHeader  = "b3"
+)
+
+const (
+	// traceIDUpperTag is the propagated tag holding the upper 64 bits of a 128-bit trace id, in hex
+	traceIDUpperTag = "_dd.p.tid"
 )
 
 const (
@@ -27,3 +42,10 @@ const (
 	xraySubsegmentKey       = "trace"
 	xraySubsegmentNamespace = "datadog"
 )
+
+const (
+	// datadogAttributeName is the name of the message attribute in which producers store a Datadog trace context.
+	datadogAttributeName = "_datadog"
+	// snsNotificationType is the type of the SNS envelope delivered to SQS when raw message delivery is off
+	snsNotificationType = "Notification"
+)
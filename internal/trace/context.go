@@ -43,8 +43,18 @@ type contextKeytype int
 // traceContextKey is the key used to store a TraceContext in a TraceContext object
 var traceContextKey = new(contextKeytype)
 
-// DefaultTraceExtractor is the default trace extractor. Extracts root trace from API Gateway headers.
-var DefaultTraceExtractor = getHeadersFromEventHeaders
+// spanLinksKey is the key used to store the span links of the function execution span in a context object
+var spanLinksKey = new(contextKeytype)
+
+// inferredSpanKey is the key used to store the inferred span of the trigger in a context object
+var inferredSpanKey = new(contextKeytype)
+
+// invocationSpansKey is the key used to store the spans of the current invocation in a context object
+var invocationSpansKey = new(contextKeytype)
+
+// DefaultTraceExtractor is the default trace extractor. Extracts root trace from the payload of events sent by
+// SQS, SNS, Kinesis, DynamoDB Streams and EventBridge, or from the headers of API Gateway and other HTTP events.
+var DefaultTraceExtractor = ChainExtractors(getHeadersFromEventSource, getHeadersFromEventHeaders)
 
 // contextWithRootTraceContext uses the incoming event and context object payloads to determine
 // the root TraceContext and then adds that TraceContext to the context object.
@@ -78,6 +88,9 @@ func contextWithRootTraceContext(ctx context.Context, ev json.RawMessage, mergeX
 	mergedTraceContext[traceIDHeader] = datadogTraceContext[traceIDHeader]
 	mergedTraceContext[samplingPriorityHeader] = datadogTraceContext[samplingPriorityHeader]
 	mergedTraceContext[parentIDHeader] = xrayTraceContext[parentIDHeader]
+	if tags, ok := datadogTraceContext[tagsHeader]; ok {
+		mergedTraceContext[tagsHeader] = tags
+	}
 	return context.WithValue(ctx, traceContextKey, mergedTraceContext), nil
 }
 
@@ -99,6 +112,9 @@ func ConvertCurrentXrayTraceContext(ctx context.Context) TraceContext {
 		newTraceContext[traceIDHeader] = xrayTraceContext[traceIDHeader]
 		newTraceContext[samplingPriorityHeader] = xrayTraceContext[samplingPriorityHeader]
 		newTraceContext[parentIDHeader] = parentID
+		if tags, ok := xrayTraceContext[tagsHeader]; ok {
+			newTraceContext[tagsHeader] = tags
+		}
 
 		return newTraceContext
 	}
@@ -132,9 +148,11 @@ func getTraceContext(ctx context.Context, headers map[string]string) (TraceConte
 	tc := TraceContext{}
 
 	traceID := headers[traceIDHeader]
+	traceIDFromContext := false
 	if traceID == "" {
 		if val, ok := ctx.Value(extension.DdTraceId).(string); ok {
 			traceID = val
+			traceIDFromContext = true
 		}
 	}
 	if traceID == "" {
@@ -168,6 +186,14 @@ func getTraceContext(ctx context.Context, headers map[string]string) (TraceConte
 		tc[originHeader] = origin
 	}
 
+	// propagated tags carry the upper 64 bits of 128-bit trace ids in _dd.p.tid, so they are only taken from the context
+	// along with the trace id
+	if tags, ok := headers[tagsHeader]; ok && tags != "" {
+		tc[tagsHeader] = tags
+	} else if tags, ok := ctx.Value(extension.DdTags).(string); ok && tags != "" && traceIDFromContext {
+		tc[tagsHeader] = tags
+	}
+
 	tc[samplingPriorityHeader] = samplingPriority
 	tc[traceIDHeader] = traceID
 	tc[parentIDHeader] = parentID
@@ -178,7 +204,7 @@ func getTraceContext(ctx context.Context, headers map[string]string) (TraceConte
 // getHeadersFromEventHeaders extracts the Datadog trace context from an incoming
 // Lambda event payload's headers and multivalueHeaders, with headers taking precedence
 // then creates a dummy X-Ray subsegment containing this information.
-// This is used as the DefaultTraceExtractor.
+// This is used by the DefaultTraceExtractor when the event source doesn't carry a trace context in its payload.
 func getHeadersFromEventHeaders(ctx context.Context, ev json.RawMessage) map[string]string {
 	eh := eventWithHeaders{}
 
@@ -225,11 +251,16 @@ func convertXrayTraceContextFromLambdaContext(ctx context.Context) (TraceContext
 	if err != nil {
 		return traceCtx, fmt.Errorf("Couldn't read parent id from X-Ray: %v", err)
 	}
+	traceIDUpper, err := convertXRayTraceIDToDatadogTraceIDUpper(header.TraceID)
+	if err != nil {
+		return traceCtx, fmt.Errorf("Couldn't read trace id from X-Ray: %v", err)
+	}
 	samplingPriority := convertXRaySamplingDecision(header.SamplingDecision)
 
 	traceCtx[traceIDHeader] = traceID
 	traceCtx[parentIDHeader] = parentID
 	traceCtx[samplingPriorityHeader] = samplingPriority
+	traceCtx[tagsHeader] = traceIDUpperTag + "=" + traceIDUpper
 	return traceCtx, nil
 }
 
@@ -246,15 +277,11 @@ func getXrayTraceHeaderFromContext(ctx context.Context) *header.Header {
 	return nil
 }
 
-// Converts the last 63 bits of an X-Ray trace ID (hex) to a Datadog trace id (uint64).
+// Converts the last 64 bits of an X-Ray trace ID (hex) to the lower 64 bits of a Datadog trace id (uint64).
 func convertXRayTraceIDToDatadogTraceID(traceID string) (string, error) {
-	parts := strings.Split(traceID, "-")
-
-	if len(parts) != 3 {
-		return "0", fmt.Errorf("invalid x-ray trace id; expected 3 components in id")
-	}
-	if len(parts[2]) != 24 {
-		return "0", fmt.Errorf("x-ray trace id should be 96 bits")
+	parts, err := splitXRayTraceID(traceID)
+	if err != nil {
+		return "0", err
 	}
 
 	traceIDLength := len(parts[2]) - 16
@@ -263,10 +290,41 @@ func convertXRayTraceIDToDatadogTraceID(traceID string) (string, error) {
 	if err != nil {
 		return "0", fmt.Errorf("while converting xray trace id: %v", err)
 	}
-	apmTraceID = 0x7FFFFFFFFFFFFFFF & apmTraceID // The APM Trace ID is restricted to 63 bits, so make sure the 64th bit is always 0
 	return strconv.FormatUint(apmTraceID, 10), nil
 }
 
+// Converts the epoch and the first 32 bits of the unique identifier of an X-Ray trace ID to the upper 64 bits
+// of a Datadog trace id, in the hex format of the _dd.p.tid tag. Together with convertXRayTraceIDToDatadogTraceID,
+// this maps the X-Ray trace id to a 128-bit Datadog trace id carrying the same 128 bits.
+func convertXRayTraceIDToDatadogTraceIDUpper(traceID string) (string, error) {
+	parts, err := splitXRayTraceID(traceID)
+	if err != nil {
+		return "", err
+	}
+
+	upper := strings.ToLower(parts[1] + parts[2][:8])
+	if _, err := convertHexIDToUint64(upper); err != nil {
+		return "", fmt.Errorf("while converting xray trace id: %v", err)
+	}
+	return upper, nil
+}
+
+// splitXRayTraceID splits an X-Ray trace ID into its version, epoch and unique identifier.
+func splitXRayTraceID(traceID string) ([]string, error) {
+	parts := strings.Split(traceID, "-")
+
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("invalid x-ray trace id; expected 3 components in id")
+	}
+	if len(parts[1]) != 8 {
+		return nil, fmt.Errorf("x-ray trace id epoch should be 32 bits")
+	}
+	if len(parts[2]) != 24 {
+		return nil, fmt.Errorf("x-ray trace id should be 96 bits")
+	}
+	return parts, nil
+}
+
 func convertHexIDToUint64(hexNumber string) (uint64, error) {
 	ba, err := hex.DecodeString(hexNumber)
 	if err != nil {
@@ -297,7 +355,7 @@ func convertXRaySamplingDecision(decision header.SamplingDecision) string {
 
 // ConvertTraceContextToSpanContext converts a TraceContext object to a SpanContext that can be used by dd-trace.
 func ConvertTraceContextToSpanContext(traceCtx TraceContext) (ddtrace.SpanContext, error) {
-	spanCtx, err := propagator.Extract(tracer.TextMapCarrier(traceCtx))
+	spanCtx, err := propagator.Extract(tracer.TextMapCarrier(propagationCarrier(traceCtx)))
 
 	if err != nil {
 		logger.Debug("Could not convert TraceContext to a SpanContext (most likely TraceContext was empty)")
@@ -307,6 +365,16 @@ func ConvertTraceContextToSpanContext(traceCtx TraceContext) (ddtrace.SpanContex
 	return spanCtx, nil
 }
 
+// getTraceIDUpper returns the upper 64 bits of the 128-bit trace id of a SpanContext, or 0 for 64-bit trace ids.
+func getTraceIDUpper(spanCtx ddtrace.SpanContext) uint64 {
+	w3cSpanCtx, ok := spanCtx.(ddtrace.SpanContextW3C)
+	if !ok {
+		return 0
+	}
+	traceID := w3cSpanCtx.TraceID128Bytes()
+	return binary.BigEndian.Uint64(traceID[:8])
+}
+
 // propagator is able to extract a SpanContext object from a TraceContext object
 var propagator = tracer.NewPropagator(&tracer.PropagatorConfig{
 	TraceHeader:    traceIDHeader,
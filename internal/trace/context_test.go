@@ -18,13 +18,15 @@ import (
 	"github.com/aws/aws-xray-sdk-go/v2/header"
 	"github.com/aws/aws-xray-sdk-go/v2/xray"
 	"github.com/stretchr/testify/assert"
+	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace"
 )
 
 const (
 	mockXRayEntityID      = "0b11cc4230d3e09e"
 	mockXRayTraceID       = "1-5ce31dc2-2c779014b90ce44db5e03875"
 	convertedXRayEntityID = "797643193680388254"
-	convertedXRayTraceID  = "4110911582297405557"
+	convertedXRayTraceID  = "13334283619152181365"
+	convertedXRayTraceTag = "_dd.p.tid=5ce31dc22c779014"
 )
 
 func mockLambdaXRayTraceContext(ctx context.Context, traceID, parentID string, sampled bool) context.Context {
@@ -232,6 +234,17 @@ func TestConvertXRayTraceID(t *testing.T) {
 	assert.Equal(t, convertedXRayTraceID, output)
 }
 
+func TestConvertXRayTraceIDUpper(t *testing.T) {
+	output, err := convertXRayTraceIDToDatadogTraceIDUpper(mockXRayTraceID)
+	assert.NoError(t, err)
+	assert.Equal(t, "5ce31dc22c779014", output)
+}
+
+func TestConvertXRayTraceIDUpperInvalidEpoch(t *testing.T) {
+	_, err := convertXRayTraceIDToDatadogTraceIDUpper("1-5ce31d-2c779014b90ce44db5e03875")
+	assert.Error(t, err)
+}
+
 func TestConvertXRayTraceIDTooShort(t *testing.T) {
 	output, err := convertXRayTraceIDToDatadogTraceID("1-5ce31dc2-5e03875")
 	assert.Error(t, err)
@@ -321,6 +334,7 @@ func TestContextWithRootTraceContextMergeXrayTracesNoDatadogContext(t *testing.T
 		traceIDHeader:          convertedXRayTraceID,
 		parentIDHeader:         convertedXRayEntityID,
 		samplingPriorityHeader: "2",
+		tagsHeader:             convertedXRayTraceTag,
 	}
 	assert.Equal(t, expected, traceContext)
 }
@@ -339,3 +353,51 @@ func TestContextWithRootTraceContextMergeXrayTracesWithDatadogContext(t *testing
 	}
 	assert.Equal(t, expected, traceContext)
 }
+
+func TestGetDatadogTraceContextWithTraceIDUpper(t *testing.T) {
+	headers := map[string]string{
+		traceIDHeader:          "1231452342",
+		parentIDHeader:         "45678910",
+		samplingPriorityHeader: "2",
+		tagsHeader:             "_dd.p.tid=64fe8b2a57d3eff7",
+	}
+
+	tc, ok := getTraceContext(context.Background(), headers)
+	assert.True(t, ok)
+	assert.Equal(t, "_dd.p.tid=64fe8b2a57d3eff7", tc[tagsHeader])
+
+	ctx := mockTraceContext("1231452342", "45678910", "2", "")
+	ctx = context.WithValue(ctx, extension.DdTags, "_dd.p.tid=80f198ee56343ba8")
+	tc, ok = getTraceContext(ctx, map[string]string{})
+	assert.True(t, ok)
+	assert.Equal(t, "_dd.p.tid=80f198ee56343ba8", tc[tagsHeader])
+}
+
+func TestGetDatadogTraceContextIgnoresContextTagsOfAnotherTrace(t *testing.T) {
+	headers := map[string]string{
+		traceIDHeader:          "1231452342",
+		parentIDHeader:         "45678910",
+		samplingPriorityHeader: "2",
+	}
+	ctx := mockTraceContext("9876543210", "12345", "1", "")
+	ctx = context.WithValue(ctx, extension.DdTags, "_dd.p.tid=80f198ee56343ba8")
+
+	tc, ok := getTraceContext(ctx, headers)
+	assert.True(t, ok)
+	assert.Equal(t, "1231452342", tc[traceIDHeader])
+	assert.NotContains(t, tc, tagsHeader)
+}
+
+func TestConvertTraceContextToSpanContextWithTraceIDUpper(t *testing.T) {
+	traceCtx := TraceContext{
+		traceIDHeader:          convertedXRayTraceID,
+		parentIDHeader:         convertedXRayEntityID,
+		samplingPriorityHeader: "2",
+		tagsHeader:             convertedXRayTraceTag,
+	}
+
+	spanCtx, err := ConvertTraceContextToSpanContext(traceCtx)
+	assert.NoError(t, err)
+	assert.Equal(t, "5ce31dc22c779014b90ce44db5e03875", spanCtx.(ddtrace.SpanContextW3C).TraceID128())
+	assert.Equal(t, uint64(0x5ce31dc22c779014), getTraceIDUpper(spanCtx))
+}
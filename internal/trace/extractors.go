@@ -0,0 +1,288 @@
+/*
+ * Unless explicitly stated otherwise all files in this repository are licensed
+ * under the Apache License Version 2.0.
+ *
+ * This product includes software developed at Datadog (https://www.datadoghq.com/).
+ * Copyright 2021 Datadog, Inc.
+ */
+
+package trace
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+
+	"github.com/DataDog/datadog-lambda-go/internal/logger"
+	"github.com/DataDog/datadog-lambda-go/internal/trigger"
+	"github.com/aws/aws-lambda-go/events"
+	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace"
+)
+
+// recordExtractors read the trace context of every record of an event, by event source.
+var recordExtractors = map[trigger.EventSource]func(ev json.RawMessage) []map[string]string{
+	trigger.SQS:         getTraceContextsFromSQSEvent,
+	trigger.SNS:         getTraceContextsFromSNSEvent,
+	trigger.Kinesis:     getTraceContextsFromKinesisEvent,
+	trigger.DynamoDB:    getTraceContextsFromDynamoDBEvent,
+	trigger.EventBridge: getTraceContextsFromEventBridgeEvent,
+}
+
+// ChainExtractors returns a ContextExtractor that calls each extractor in turn, and returns the
+// headers of the first one that finds any.
+func ChainExtractors(extractors ...ContextExtractor) ContextExtractor {
+	return func(ctx context.Context, ev json.RawMessage) map[string]string {
+		for _, extractor := range extractors {
+			if headers := extractor(ctx, ev); len(headers) > 0 {
+				return headers
+			}
+		}
+		return map[string]string{}
+	}
+}
+
+// getHeadersFromEventSource extracts the Datadog trace context from an event sent by an asynchronous
+// event source (SQS, SNS, Kinesis, DynamoDB Streams or EventBridge). For batch events the trace context
+// of the first record is used, the other records are turned into span links by getSpanLinksFromEvent.
+func getHeadersFromEventSource(ctx context.Context, ev json.RawMessage) map[string]string {
+	traceContexts := getTraceContextsFromEvent(ctx, ev)
+	if len(traceContexts) == 0 {
+		return map[string]string{}
+	}
+	return traceContexts[0]
+}
+
+// getSpanLinksFromEvent returns a span link for every record of a batch event past the first one
+// that carries a trace context in one of the given propagation styles. The first record is used as
+// the parent of the function execution span instead.
+func getSpanLinksFromEvent(ctx context.Context, ev json.RawMessage, styles []PropagationStyle) []ddtrace.SpanLink {
+	traceContexts := getTraceContextsFromEvent(ctx, ev)
+	if len(traceContexts) < 2 {
+		return nil
+	}
+
+	links := []ddtrace.SpanLink{}
+	for _, headers := range traceContexts[1:] {
+		traceContext := extractPropagatedHeaders(headers, styles)
+		if len(traceContext) == 0 {
+			continue
+		}
+		spanContext, err := ConvertTraceContextToSpanContext(traceContext)
+		if err != nil {
+			continue
+		}
+		links = append(links, ddtrace.SpanLink{
+			TraceID:     spanContext.TraceID(),
+			TraceIDHigh: getTraceIDUpper(spanContext),
+			SpanID:      spanContext.SpanID(),
+		})
+	}
+	return links
+}
+
+// getTraceContextsFromEvent returns the trace context found in each record of an event, or nil if
+// the event source doesn't carry trace contexts in its payload.
+func getTraceContextsFromEvent(ctx context.Context, ev json.RawMessage) []map[string]string {
+	extract, ok := recordExtractors[eventSource(ctx, ev)]
+	if !ok {
+		return nil
+	}
+	return extract(ev)
+}
+
+// eventSource returns the source of the event parsed by the wrapper, or detects it when ctx doesn't hold the event
+func eventSource(ctx context.Context, ev json.RawMessage) trigger.EventSource {
+	if event, ok := trigger.FromContext(ctx); ok {
+		return event.Source
+	}
+	return trigger.Detect(ev)
+}
+
+// getTraceContextsFromSQSEvent returns the trace context found in each record of an SQS event,
+// in record order. Records without a trace context get an empty map.
+func getTraceContextsFromSQSEvent(ev json.RawMessage) []map[string]string {
+	sqsEvent := events.SQSEvent{}
+	if err := json.Unmarshal(ev, &sqsEvent); err != nil {
+		return nil
+	}
+	traceContexts := make([]map[string]string, len(sqsEvent.Records))
+	for i, record := range sqsEvent.Records {
+		traceContexts[i] = getHeadersFromSQSMessage(record)
+	}
+	return traceContexts
+}
+
+// getTraceContextsFromSNSEvent returns the trace context found in each record of an SNS event,
+// in record order.
+func getTraceContextsFromSNSEvent(ev json.RawMessage) []map[string]string {
+	snsEvent := events.SNSEvent{}
+	if err := json.Unmarshal(ev, &snsEvent); err != nil {
+		return nil
+	}
+	traceContexts := make([]map[string]string, len(snsEvent.Records))
+	for i, record := range snsEvent.Records {
+		traceContexts[i] = getHeadersFromSNSMessageAttributes(record.SNS.MessageAttributes)
+	}
+	return traceContexts
+}
+
+// getTraceContextsFromKinesisEvent returns the trace context found in each record of a Kinesis event,
+// in record order. Producers store it under the "_datadog" key of the JSON record data.
+func getTraceContextsFromKinesisEvent(ev json.RawMessage) []map[string]string {
+	kinesisEvent := events.KinesisEvent{}
+	if err := json.Unmarshal(ev, &kinesisEvent); err != nil {
+		return nil
+	}
+
+	traceContexts := make([]map[string]string, len(kinesisEvent.Records))
+	for i, record := range kinesisEvent.Records {
+		data := struct {
+			Datadog json.RawMessage `json:"_datadog"`
+		}{}
+		if err := json.Unmarshal(record.Kinesis.Data, &data); err != nil {
+			// The record data isn't always JSON, in which case it can't carry a trace context
+			traceContexts[i] = map[string]string{}
+			continue
+		}
+		traceContexts[i] = unmarshalDatadogAttribute(data.Datadog)
+	}
+	return traceContexts
+}
+
+// getTraceContextsFromDynamoDBEvent returns the trace context found in each record of a DynamoDB Streams
+// event, in record order. It is read from the "_datadog" attribute of the new image of the item, stored
+// either as a map of strings or as a string holding a JSON object.
+func getTraceContextsFromDynamoDBEvent(ev json.RawMessage) []map[string]string {
+	dynamoDBEvent := events.DynamoDBEvent{}
+	if err := json.Unmarshal(ev, &dynamoDBEvent); err != nil {
+		return nil
+	}
+
+	traceContexts := make([]map[string]string, len(dynamoDBEvent.Records))
+	for i, record := range dynamoDBEvent.Records {
+		traceContexts[i] = getHeadersFromDynamoDBImage(record.Change.NewImage)
+	}
+	return traceContexts
+}
+
+// getTraceContextsFromEventBridgeEvent returns the trace context stored under the "_datadog" key of the
+// detail of an EventBridge event.
+func getTraceContextsFromEventBridgeEvent(ev json.RawMessage) []map[string]string {
+	eventBridgeEvent := events.EventBridgeEvent{}
+	if err := json.Unmarshal(ev, &eventBridgeEvent); err != nil {
+		return nil
+	}
+
+	detail := struct {
+		Datadog json.RawMessage `json:"_datadog"`
+	}{}
+	if err := json.Unmarshal(eventBridgeEvent.Detail, &detail); err != nil {
+		return []map[string]string{{}}
+	}
+	return []map[string]string{unmarshalDatadogAttribute(detail.Datadog)}
+}
+
+// getHeadersFromSQSMessage reads the trace context of an SQS message. When the message was fanned out
+// from an SNS topic without raw message delivery, the trace context is in the SNS envelope found in
+// the message body rather than in the SQS message attributes.
+func getHeadersFromSQSMessage(message events.SQSMessage) map[string]string {
+	if _, ok := message.MessageAttributes[datadogAttributeName]; ok {
+		return getHeadersFromSQSMessageAttributes(message.MessageAttributes)
+	}
+	if !strings.HasPrefix(strings.TrimSpace(message.Body), "{") {
+		return map[string]string{}
+	}
+
+	envelope := events.SNSEntity{}
+	if err := json.Unmarshal([]byte(message.Body), &envelope); err != nil {
+		return map[string]string{}
+	}
+	if envelope.Type != snsNotificationType || envelope.TopicArn == "" {
+		return map[string]string{}
+	}
+	return getHeadersFromSNSMessageAttributes(envelope.MessageAttributes)
+}
+
+// getHeadersFromSQSMessageAttributes reads the trace context from the "_datadog" message attribute,
+// which holds a JSON object of headers either as a String or as a Binary value.
+func getHeadersFromSQSMessageAttributes(attributes map[string]events.SQSMessageAttribute) map[string]string {
+	attribute, ok := attributes[datadogAttributeName]
+	if !ok {
+		return map[string]string{}
+	}
+
+	var payload []byte
+	switch {
+	case attribute.StringValue != nil:
+		payload = []byte(*attribute.StringValue)
+	case len(attribute.BinaryValue) > 0:
+		payload = attribute.BinaryValue
+	}
+	return unmarshalDatadogAttribute(payload)
+}
+
+// getHeadersFromSNSMessageAttributes reads the trace context from the "_datadog" message attribute.
+// SNS attributes have a Type and a Value, Binary values being base64 encoded.
+func getHeadersFromSNSMessageAttributes(attributes map[string]interface{}) map[string]string {
+	attribute, ok := attributes[datadogAttributeName].(map[string]interface{})
+	if !ok {
+		return map[string]string{}
+	}
+	value, _ := attribute["Value"].(string)
+
+	var payload []byte
+	switch attribute["Type"] {
+	case "String":
+		payload = []byte(value)
+	case "Binary":
+		decoded, err := base64.StdEncoding.DecodeString(value)
+		if err != nil {
+			logger.Debug("Couldn't decode the binary _datadog attribute of an SNS message")
+			return map[string]string{}
+		}
+		payload = decoded
+	}
+	return unmarshalDatadogAttribute(payload)
+}
+
+// getHeadersFromDynamoDBImage reads the trace context from the "_datadog" attribute of a DynamoDB item.
+func getHeadersFromDynamoDBImage(image map[string]events.DynamoDBAttributeValue) map[string]string {
+	attribute, ok := image[datadogAttributeName]
+	if !ok {
+		return map[string]string{}
+	}
+
+	switch attribute.DataType() {
+	case events.DataTypeString:
+		return unmarshalDatadogAttribute([]byte(attribute.String()))
+	case events.DataTypeMap:
+		headers := map[string]string{}
+		for k, v := range attribute.Map() {
+			if v.DataType() == events.DataTypeString {
+				headers[strings.ToLower(k)] = v.String()
+			}
+		}
+		return headers
+	}
+	return map[string]string{}
+}
+
+// unmarshalDatadogAttribute decodes the JSON object stored in a "_datadog" attribute into a map of
+// lowercase headers.
+func unmarshalDatadogAttribute(payload []byte) map[string]string {
+	headers := map[string]string{}
+	if len(payload) == 0 {
+		return headers
+	}
+
+	raw := map[string]string{}
+	if err := json.Unmarshal(payload, &raw); err != nil {
+		logger.Debug("Couldn't unmarshal the _datadog attribute, it should be a JSON object of strings")
+		return headers
+	}
+	for k, v := range raw {
+		headers[strings.ToLower(k)] = v
+	}
+	return headers
+}
@@ -0,0 +1,229 @@
+/*
+ * Unless explicitly stated otherwise all files in this repository are licensed
+ * under the Apache License Version 2.0.
+ *
+ * This product includes software developed at Datadog (https://www.datadoghq.com/).
+ * Copyright 2021 Datadog, Inc.
+ */
+
+package trace
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/DataDog/datadog-lambda-go/internal/trigger"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDefaultTraceExtractorSQSBatch(t *testing.T) {
+	ctx := context.Background()
+	ev := loadRawJSON(t, "../testdata/sqs-event-batch.json")
+
+	headers := DefaultTraceExtractor(ctx, *ev)
+
+	expected := map[string]string{
+		traceIDHeader:          "1111",
+		parentIDHeader:         "2222",
+		samplingPriorityHeader: "1",
+	}
+	assert.Equal(t, expected, headers)
+}
+
+func TestDefaultTraceExtractorFallsBackToHeaders(t *testing.T) {
+	ctx := context.Background()
+	ev := loadRawJSON(t, "../testdata/apig-event-with-headers.json")
+
+	headers := DefaultTraceExtractor(ctx, *ev)
+
+	assert.Equal(t, "1231452342", headers[traceIDHeader])
+	assert.Equal(t, "45678910", headers[parentIDHeader])
+}
+
+func TestGetTraceContextsFromSQSEventBinaryAttribute(t *testing.T) {
+	ev := loadRawJSON(t, "../testdata/sqs-event-batch.json")
+
+	traceContexts := getTraceContextsFromSQSEvent(*ev)
+
+	assert.Len(t, traceContexts, 3)
+	assert.Equal(t, map[string]string{
+		traceIDHeader:          "3333",
+		parentIDHeader:         "4444",
+		samplingPriorityHeader: "1",
+	}, traceContexts[1])
+	assert.Empty(t, traceContexts[2])
+}
+
+func TestGetTraceContextsFromEventNoRecords(t *testing.T) {
+	ev := loadRawJSON(t, "../testdata/apig-event-no-headers.json")
+
+	assert.Nil(t, getTraceContextsFromEvent(context.Background(), *ev))
+}
+
+func TestGetTraceContextsFromEventUsesParsedEvent(t *testing.T) {
+	ev := loadRawJSON(t, "../testdata/sqs-event-batch.json")
+
+	ctx := trigger.ContextWithEvent(context.Background(), trigger.Parse(context.Background(), *ev))
+	assert.Len(t, getTraceContextsFromEvent(ctx, *ev), 3)
+
+	// The source parsed by the wrapper is trusted rather than detected again
+	ctx = trigger.ContextWithEvent(context.Background(), trigger.Event{Source: trigger.Unknown})
+	assert.Nil(t, getTraceContextsFromEvent(ctx, *ev))
+}
+
+func TestGetSpanLinksFromEventSQSBatch(t *testing.T) {
+	ev := loadRawJSON(t, "../testdata/sqs-event-batch.json")
+
+	links := getSpanLinksFromEvent(context.Background(), *ev, DefaultPropagationStyleExtract)
+
+	assert.Len(t, links, 1)
+	assert.Equal(t, uint64(3333), links[0].TraceID)
+	assert.Equal(t, uint64(4444), links[0].SpanID)
+}
+
+func TestGetSpanLinksFromEventTraceIDUpper(t *testing.T) {
+	ev := []byte(`{"Records":[
+		{"eventSource":"aws:sqs","messageAttributes":{}},
+		{"eventSource":"aws:sqs","messageAttributes":{"_datadog":{"dataType":"String","stringValue":"{\"traceparent\":\"00-80f198ee56343ba864fe8b2a57d3eff7-00f067aa0ba902b7-01\"}"}}}
+	]}`)
+
+	links := getSpanLinksFromEvent(context.Background(), ev, DefaultPropagationStyleExtract)
+
+	assert.Len(t, links, 1)
+	assert.Equal(t, uint64(0x80f198ee56343ba8), links[0].TraceIDHigh)
+	assert.Equal(t, uint64(0x64fe8b2a57d3eff7), links[0].TraceID)
+	assert.Equal(t, uint64(0x00f067aa0ba902b7), links[0].SpanID)
+}
+
+func TestGetSpanLinksFromEventNotBatch(t *testing.T) {
+	ev := loadRawJSON(t, "../testdata/apig-event-with-headers.json")
+
+	assert.Empty(t, getSpanLinksFromEvent(context.Background(), *ev, DefaultPropagationStyleExtract))
+}
+
+func TestDefaultTraceExtractorSNS(t *testing.T) {
+	ctx := context.Background()
+	ev := loadRawJSON(t, "../testdata/sns-event.json")
+
+	headers := DefaultTraceExtractor(ctx, *ev)
+
+	expected := map[string]string{
+		traceIDHeader:          "5555",
+		parentIDHeader:         "6666",
+		samplingPriorityHeader: "1",
+	}
+	assert.Equal(t, expected, headers)
+}
+
+func TestDefaultTraceExtractorSQSFromSNS(t *testing.T) {
+	ctx := context.Background()
+	ev := loadRawJSON(t, "../testdata/sqs-event-from-sns.json")
+
+	headers := DefaultTraceExtractor(ctx, *ev)
+
+	expected := map[string]string{
+		traceIDHeader:          "5555",
+		parentIDHeader:         "6666",
+		samplingPriorityHeader: "1",
+	}
+	assert.Equal(t, expected, headers)
+}
+
+func TestGetHeadersFromSQSMessagePlainBody(t *testing.T) {
+	message := events.SQSMessage{Body: "test message"}
+
+	assert.Empty(t, getHeadersFromSQSMessage(message))
+}
+
+func TestGetHeadersFromSNSMessageAttributesInvalidBinary(t *testing.T) {
+	attributes := map[string]interface{}{
+		datadogAttributeName: map[string]interface{}{"Type": "Binary", "Value": "not base64!"},
+	}
+
+	assert.Empty(t, getHeadersFromSNSMessageAttributes(attributes))
+}
+
+func TestDefaultTraceExtractorKinesisBatch(t *testing.T) {
+	ctx := context.Background()
+	ev := loadRawJSON(t, "../testdata/kinesis-event-batch.json")
+
+	headers := DefaultTraceExtractor(ctx, *ev)
+
+	expected := map[string]string{
+		traceIDHeader:          "7777",
+		parentIDHeader:         "8888",
+		samplingPriorityHeader: "1",
+	}
+	assert.Equal(t, expected, headers)
+
+	links := getSpanLinksFromEvent(context.Background(), *ev, DefaultPropagationStyleExtract)
+	assert.Len(t, links, 1)
+	assert.Equal(t, uint64(9999), links[0].TraceID)
+	assert.Equal(t, uint64(1010), links[0].SpanID)
+}
+
+func TestDefaultTraceExtractorDynamoDB(t *testing.T) {
+	ctx := context.Background()
+	ev := loadRawJSON(t, "../testdata/dynamodb-event.json")
+
+	headers := DefaultTraceExtractor(ctx, *ev)
+
+	expected := map[string]string{
+		traceIDHeader:          "7777",
+		parentIDHeader:         "8888",
+		samplingPriorityHeader: "1",
+	}
+	assert.Equal(t, expected, headers)
+}
+
+func TestDefaultTraceExtractorEventBridge(t *testing.T) {
+	ctx := context.Background()
+	ev := loadRawJSON(t, "../testdata/eventbridge-event.json")
+
+	headers := DefaultTraceExtractor(ctx, *ev)
+
+	expected := map[string]string{
+		traceIDHeader:          "7777",
+		parentIDHeader:         "8888",
+		samplingPriorityHeader: "1",
+	}
+	assert.Equal(t, expected, headers)
+}
+
+func TestGetHeadersFromDynamoDBImageString(t *testing.T) {
+	image := map[string]events.DynamoDBAttributeValue{
+		datadogAttributeName: events.NewStringAttribute(`{"X-Datadog-Trace-Id":"1","x-datadog-parent-id":"2"}`),
+	}
+
+	expected := map[string]string{
+		traceIDHeader:  "1",
+		parentIDHeader: "2",
+	}
+	assert.Equal(t, expected, getHeadersFromDynamoDBImage(image))
+}
+
+func TestGetTraceContextsFromKinesisEventNonJSONData(t *testing.T) {
+	ev := []byte(`{"Records":[{"eventSource":"aws:kinesis","kinesis":{"data":"aGVsbG8="}}]}`)
+
+	traceContexts := getTraceContextsFromKinesisEvent(ev)
+
+	assert.Len(t, traceContexts, 1)
+	assert.Empty(t, traceContexts[0])
+}
+
+func TestChainExtractors(t *testing.T) {
+	empty := func(ctx context.Context, ev json.RawMessage) map[string]string {
+		return map[string]string{}
+	}
+	first := func(ctx context.Context, ev json.RawMessage) map[string]string {
+		return map[string]string{traceIDHeader: "1"}
+	}
+	second := func(ctx context.Context, ev json.RawMessage) map[string]string {
+		return map[string]string{traceIDHeader: "2"}
+	}
+
+	assert.Equal(t, map[string]string{traceIDHeader: "1"}, ChainExtractors(empty, first, second)(context.Background(), nil))
+	assert.Empty(t, ChainExtractors(empty)(context.Background(), nil))
+}
@@ -0,0 +1,464 @@
+/*
+ * Unless explicitly stated otherwise all files in this repository are licensed
+ * under the Apache License Version 2.0.
+ *
+ * This product includes software developed at Datadog (https://www.datadoghq.com/).
+ * Copyright 2021 Datadog, Inc.
+ */
+
+package trace
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/DataDog/datadog-lambda-go/internal/logger"
+	"github.com/DataDog/datadog-lambda-go/internal/trigger"
+	"github.com/aws/aws-lambda-go/events"
+	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace"
+	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace/ext"
+	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace/tracer"
+)
+
+// httpEvent holds the fields of API Gateway, Function URL and ALB events used to build inferred spans.
+type httpEvent struct {
+	Resource       string            `json:"resource"`
+	Path           string            `json:"path"`
+	HTTPMethod     string            `json:"httpMethod"`
+	Headers        map[string]string `json:"headers"`
+	RequestContext struct {
+		DomainName       string `json:"domainName"`
+		RequestID        string `json:"requestId"`
+		APIID            string `json:"apiId"`
+		Stage            string `json:"stage"`
+		ResourcePath     string `json:"resourcePath"`
+		HTTPMethod       string `json:"httpMethod"`
+		RouteKey         string `json:"routeKey"`
+		EventType        string `json:"eventType"`
+		ConnectionID     string `json:"connectionId"`
+		MessageDirection string `json:"messageDirection"`
+		RequestTimeEpoch int64  `json:"requestTimeEpoch"`
+		TimeEpoch        int64  `json:"timeEpoch"`
+		HTTP             struct {
+			Method    string `json:"method"`
+			Path      string `json:"path"`
+			SourceIP  string `json:"sourceIp"`
+			UserAgent string `json:"userAgent"`
+		} `json:"http"`
+		ELB struct {
+			TargetGroupArn string `json:"targetGroupArn"`
+		} `json:"elb"`
+	} `json:"requestContext"`
+}
+
+// inferredSpanInfo describes the span synthesized for the managed service that triggered the function.
+type inferredSpanInfo struct {
+	operationName string
+	service       string
+	resource      string
+	startTime     time.Time
+	// async is set for queue and stream triggers, whose span ends when the function starts running
+	async bool
+	tags  map[string]string
+}
+
+// inferredSpanBuilders build the inferred span of each supported event source.
+var inferredSpanBuilders = map[trigger.EventSource]func(ev json.RawMessage) (inferredSpanInfo, error){
+	trigger.APIGatewayREST:      fromHTTPEvent(inferAPIGatewayRESTSpan),
+	trigger.APIGatewayHTTP:      fromHTTPEvent(inferAPIGatewayHTTPSpan),
+	trigger.APIGatewayWebsocket: fromHTTPEvent(inferAPIGatewayWebsocketSpan),
+	trigger.FunctionURL:         fromHTTPEvent(inferFunctionURLSpan),
+	trigger.ALB:                 fromHTTPEvent(inferALBSpan),
+	trigger.SQS:                 inferSQSSpan,
+	trigger.SNS:                 inferSNSSpan,
+	trigger.Kinesis:             inferKinesisSpan,
+	trigger.EventBridge:         inferEventBridgeSpan,
+	trigger.S3:                  inferS3Span,
+	trigger.DynamoDB:            inferDynamoDBSpan,
+}
+
+// startInferredSpan starts a span representing the managed service that triggered the function, parented to
+// the root trace context. It returns false if the event source doesn't get an inferred span. The span of
+// asynchronous triggers is to be finished as soon as the function execution span is started.
+func startInferredSpan(ctx context.Context, ev json.RawMessage) (span ddtrace.Span, async bool, ok bool) {
+	build, ok := inferredSpanBuilders[eventSource(ctx, ev)]
+	if !ok {
+		return nil, false, false
+	}
+
+	info, err := build(ev)
+	if err != nil {
+		logger.Debug(fmt.Sprintf("could not read event for inferred span: %v", err))
+		return nil, false, false
+	}
+	if info.startTime.IsZero() {
+		info.startTime = time.Now()
+	}
+	synchronicity := "sync"
+	if info.async {
+		synchronicity = "async"
+	}
+
+	opts := []tracer.StartSpanOption{
+		tracer.SpanType("web"),
+		tracer.ServiceName(info.service),
+		tracer.ResourceName(info.resource),
+		tracer.StartTime(info.startTime),
+		tracer.Tag("operation_name", info.operationName),
+		tracer.Tag("_inferred_span.tag_source", "self"),
+		tracer.Tag("_inferred_span.synchronicity", synchronicity),
+	}
+	if rootTraceContext, ok := ctx.Value(traceContextKey).(TraceContext); ok {
+		if parentSpanContext, err := ConvertTraceContextToSpanContext(rootTraceContext); err == nil {
+			opts = append(opts, tracer.ChildOf(parentSpanContext))
+		}
+	}
+	for k, v := range info.tags {
+		if v != "" {
+			opts = append(opts, tracer.Tag(k, v))
+		}
+	}
+
+	return tracer.StartSpan(info.operationName, opts...), info.async, true
+}
+
+// fromHTTPEvent adapts the inferred span builder of an HTTP trigger to raw events, with lowercased headers.
+func fromHTTPEvent(build func(ev httpEvent) inferredSpanInfo) func(ev json.RawMessage) (inferredSpanInfo, error) {
+	return func(ev json.RawMessage) (inferredSpanInfo, error) {
+		event := httpEvent{}
+		if err := json.Unmarshal(ev, &event); err != nil {
+			return inferredSpanInfo{}, err
+		}
+		lowercaseHeaders := make(map[string]string, len(event.Headers))
+		for k, v := range event.Headers {
+			lowercaseHeaders[strings.ToLower(k)] = v
+		}
+		event.Headers = lowercaseHeaders
+		return build(event), nil
+	}
+}
+
+// finishInferredSpan tags the inferred span with the status code of the function response, unless it is zero, and
+// finishes it. Server errors mark the span as an error.
+func finishInferredSpan(span ddtrace.Span, statusCode int) {
+	var err error
+	if statusCode != 0 {
+		span.SetTag(ext.HTTPCode, strconv.Itoa(statusCode))
+		err = statusCodeError(statusCode)
+	}
+	span.Finish(tracer.WithError(err))
+}
+
+func inferAPIGatewayRESTSpan(ev httpEvent) inferredSpanInfo {
+	rc := ev.RequestContext
+	method := rc.HTTPMethod
+	if method == "" {
+		method = ev.HTTPMethod
+	}
+	route := rc.ResourcePath
+	if route == "" {
+		route = ev.Resource
+	}
+	return inferredSpanInfo{
+		operationName: "aws.apigateway",
+		service:       serviceFromDomain(rc.DomainName, "aws.apigateway"),
+		resource:      method + " " + route,
+		startTime:     timeFromEpochMillis(rc.RequestTimeEpoch),
+		tags: map[string]string{
+			"http.method":    method,
+			"http.url":       "https://" + rc.DomainName + ev.Path,
+			"http.route":     route,
+			"endpoint":       ev.Path,
+			"domain_name":    rc.DomainName,
+			"apiid":          rc.APIID,
+			"apiname":        rc.APIID,
+			"stage":          rc.Stage,
+			"request_id":     rc.RequestID,
+			"resource_names": method + " " + route,
+		},
+	}
+}
+
+func inferAPIGatewayHTTPSpan(ev httpEvent) inferredSpanInfo {
+	rc := ev.RequestContext
+	resource := rc.RouteKey
+	route := ""
+	if _, path, found := strings.Cut(rc.RouteKey, " "); found {
+		route = path
+	}
+	if resource == "" || resource == "$default" {
+		resource = rc.HTTP.Method + " " + rc.HTTP.Path
+	}
+	return inferredSpanInfo{
+		operationName: "aws.httpapi",
+		service:       serviceFromDomain(rc.DomainName, "aws.httpapi"),
+		resource:      resource,
+		startTime:     timeFromEpochMillis(rc.TimeEpoch),
+		tags: map[string]string{
+			"http.method":       rc.HTTP.Method,
+			"http.url":          "https://" + rc.DomainName + rc.HTTP.Path,
+			"http.route":        route,
+			"http.user_agent":   rc.HTTP.UserAgent,
+			"network.client.ip": rc.HTTP.SourceIP,
+			"endpoint":          rc.HTTP.Path,
+			"domain_name":       rc.DomainName,
+			"apiid":             rc.APIID,
+			"apiname":           rc.APIID,
+			"stage":             rc.Stage,
+			"request_id":        rc.RequestID,
+			"resource_names":    resource,
+		},
+	}
+}
+
+func inferAPIGatewayWebsocketSpan(ev httpEvent) inferredSpanInfo {
+	rc := ev.RequestContext
+	return inferredSpanInfo{
+		operationName: "aws.apigateway.websocket",
+		service:       serviceFromDomain(rc.DomainName, "aws.apigateway.websocket"),
+		resource:      rc.RouteKey,
+		startTime:     timeFromEpochMillis(rc.RequestTimeEpoch),
+		tags: map[string]string{
+			"http.url":          "https://" + rc.DomainName,
+			"endpoint":          rc.RouteKey,
+			"domain_name":       rc.DomainName,
+			"apiid":             rc.APIID,
+			"apiname":           rc.APIID,
+			"stage":             rc.Stage,
+			"request_id":        rc.RequestID,
+			"connection_id":     rc.ConnectionID,
+			"event_type":        rc.EventType,
+			"message_direction": rc.MessageDirection,
+			"resource_names":    rc.RouteKey,
+		},
+	}
+}
+
+func inferFunctionURLSpan(ev httpEvent) inferredSpanInfo {
+	rc := ev.RequestContext
+	resource := rc.HTTP.Method + " " + rc.HTTP.Path
+	return inferredSpanInfo{
+		operationName: "aws.lambda.url",
+		service:       serviceFromDomain(rc.DomainName, "aws.lambda.url"),
+		resource:      resource,
+		startTime:     timeFromEpochMillis(rc.TimeEpoch),
+		tags: map[string]string{
+			"http.method":       rc.HTTP.Method,
+			"http.url":          "https://" + rc.DomainName + rc.HTTP.Path,
+			"http.user_agent":   rc.HTTP.UserAgent,
+			"network.client.ip": rc.HTTP.SourceIP,
+			"endpoint":          rc.HTTP.Path,
+			"domain_name":       rc.DomainName,
+			"request_id":        rc.RequestID,
+			"resource_names":    resource,
+		},
+	}
+}
+
+// ALB events carry no timestamp, so the span starts when the invocation does.
+func inferALBSpan(ev httpEvent) inferredSpanInfo {
+	host := ev.Headers["host"]
+	scheme := ev.Headers["x-forwarded-proto"]
+	if scheme == "" {
+		scheme = "http"
+	}
+	resource := ev.HTTPMethod + " " + ev.Path
+	return inferredSpanInfo{
+		operationName: "aws.elb",
+		service:       serviceFromDomain(host, "aws.elb"),
+		resource:      resource,
+		tags: map[string]string{
+			"http.method":      ev.HTTPMethod,
+			"http.url":         scheme + "://" + host + ev.Path,
+			"http.user_agent":  ev.Headers["user-agent"],
+			"endpoint":         ev.Path,
+			"domain_name":      host,
+			"target_group_arn": ev.RequestContext.ELB.TargetGroupArn,
+			"resource_names":   resource,
+		},
+	}
+}
+
+// The inferred spans of batch events describe their first record, which is the one the function execution
+// span is parented to.
+
+func inferSQSSpan(ev json.RawMessage) (inferredSpanInfo, error) {
+	sqsEvent := events.SQSEvent{}
+	if err := json.Unmarshal(ev, &sqsEvent); err != nil {
+		return inferredSpanInfo{}, err
+	}
+	record := sqsEvent.Records[0]
+	queueName := resourceNameFromARN(record.EventSourceARN)
+	startTime := time.Time{}
+	if sentTimestamp, err := strconv.ParseInt(record.Attributes["SentTimestamp"], 10, 64); err == nil {
+		startTime = timeFromEpochMillis(sentTimestamp)
+	}
+	return inferredSpanInfo{
+		operationName: "aws.sqs",
+		service:       "sqs",
+		resource:      queueName,
+		startTime:     startTime,
+		async:         true,
+		tags: map[string]string{
+			"queuename":        queueName,
+			"event_source_arn": record.EventSourceARN,
+			"receipt_handle":   record.ReceiptHandle,
+			"sender_id":        record.Attributes["SenderId"],
+			"message_id":       record.MessageId,
+			"resource_names":   queueName,
+		},
+	}, nil
+}
+
+func inferSNSSpan(ev json.RawMessage) (inferredSpanInfo, error) {
+	snsEvent := events.SNSEvent{}
+	if err := json.Unmarshal(ev, &snsEvent); err != nil {
+		return inferredSpanInfo{}, err
+	}
+	record := snsEvent.Records[0].SNS
+	topicName := resourceNameFromARN(record.TopicArn)
+	return inferredSpanInfo{
+		operationName: "aws.sns",
+		service:       "sns",
+		resource:      topicName,
+		startTime:     record.Timestamp,
+		async:         true,
+		tags: map[string]string{
+			"topicname":      topicName,
+			"topic_arn":      record.TopicArn,
+			"message_id":     record.MessageID,
+			"type":           record.Type,
+			"subject":        record.Subject,
+			"resource_names": topicName,
+		},
+	}, nil
+}
+
+func inferKinesisSpan(ev json.RawMessage) (inferredSpanInfo, error) {
+	kinesisEvent := events.KinesisEvent{}
+	if err := json.Unmarshal(ev, &kinesisEvent); err != nil {
+		return inferredSpanInfo{}, err
+	}
+	record := kinesisEvent.Records[0]
+	streamName := resourceNameFromARN(record.EventSourceArn)
+	shardID, _, _ := strings.Cut(record.EventID, ":")
+	return inferredSpanInfo{
+		operationName: "aws.kinesis",
+		service:       "kinesis",
+		resource:      streamName,
+		startTime:     record.Kinesis.ApproximateArrivalTimestamp.Time,
+		async:         true,
+		tags: map[string]string{
+			"streamname":       streamName,
+			"shardid":          shardID,
+			"event_source_arn": record.EventSourceArn,
+			"event_id":         record.EventID,
+			"event_name":       record.EventName,
+			"event_version":    record.EventVersion,
+			"partition_key":    record.Kinesis.PartitionKey,
+			"resource_names":   streamName,
+		},
+	}, nil
+}
+
+func inferEventBridgeSpan(ev json.RawMessage) (inferredSpanInfo, error) {
+	eventBridgeEvent := events.EventBridgeEvent{}
+	if err := json.Unmarshal(ev, &eventBridgeEvent); err != nil {
+		return inferredSpanInfo{}, err
+	}
+	return inferredSpanInfo{
+		operationName: "aws.eventbridge",
+		service:       "eventbridge",
+		resource:      eventBridgeEvent.Source,
+		startTime:     eventBridgeEvent.Time,
+		async:         true,
+		tags: map[string]string{
+			"detail_type":    eventBridgeEvent.DetailType,
+			"event_id":       eventBridgeEvent.ID,
+			"resource_names": eventBridgeEvent.Source,
+		},
+	}, nil
+}
+
+func inferS3Span(ev json.RawMessage) (inferredSpanInfo, error) {
+	s3Event := events.S3Event{}
+	if err := json.Unmarshal(ev, &s3Event); err != nil {
+		return inferredSpanInfo{}, err
+	}
+	record := s3Event.Records[0]
+	bucketName := record.S3.Bucket.Name
+	return inferredSpanInfo{
+		operationName: "aws.s3",
+		service:       "s3",
+		resource:      bucketName,
+		startTime:     record.EventTime,
+		async:         true,
+		tags: map[string]string{
+			"bucketname":     bucketName,
+			"bucket_arn":     record.S3.Bucket.Arn,
+			"object_key":     record.S3.Object.Key,
+			"object_size":    strconv.FormatInt(record.S3.Object.Size, 10),
+			"object_etag":    record.S3.Object.ETag,
+			"event_name":     record.EventName,
+			"resource_names": bucketName,
+		},
+	}, nil
+}
+
+func inferDynamoDBSpan(ev json.RawMessage) (inferredSpanInfo, error) {
+	dynamoDBEvent := events.DynamoDBEvent{}
+	if err := json.Unmarshal(ev, &dynamoDBEvent); err != nil {
+		return inferredSpanInfo{}, err
+	}
+	record := dynamoDBEvent.Records[0]
+	// The stream ARN has the form arn:aws:dynamodb:region:account:table/TableName/stream/label
+	tableName := ""
+	if _, resource, found := strings.Cut(record.EventSourceArn, ":table/"); found {
+		tableName, _, _ = strings.Cut(resource, "/")
+	}
+	return inferredSpanInfo{
+		operationName: "aws.dynamodb",
+		service:       "dynamodb",
+		resource:      tableName,
+		startTime:     record.Change.ApproximateCreationDateTime.Time,
+		async:         true,
+		tags: map[string]string{
+			"tablename":        tableName,
+			"event_source_arn": record.EventSourceArn,
+			"event_id":         record.EventID,
+			"event_name":       record.EventName,
+			"event_version":    record.EventVersion,
+			"stream_view_type": record.Change.StreamViewType,
+			"size_bytes":       strconv.FormatInt(record.Change.SizeBytes, 10),
+			"resource_names":   tableName,
+		},
+	}, nil
+}
+
+// resourceNameFromARN returns the last component of an ARN, such as the name of an SQS queue, SNS topic
+// or Kinesis stream.
+func resourceNameFromARN(arn string) string {
+	if i := strings.LastIndexAny(arn, ":/"); i >= 0 {
+		return arn[i+1:]
+	}
+	return arn
+}
+
+// serviceFromDomain returns the domain name the trigger was called on, used as the service of inferred spans.
+func serviceFromDomain(domain string, fallback string) string {
+	if domain == "" {
+		return fallback
+	}
+	return domain
+}
+
+func timeFromEpochMillis(epochMillis int64) time.Time {
+	if epochMillis <= 0 {
+		return time.Time{}
+	}
+	return time.UnixMilli(epochMillis)
+}
@@ -0,0 +1,285 @@
+/*
+ * Unless explicitly stated otherwise all files in this repository are licensed
+ * under the Apache License Version 2.0.
+ *
+ * This product includes software developed at Datadog (https://www.datadoghq.com/).
+ * Copyright 2021 Datadog, Inc.
+ */
+
+package trace
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-lambda-go/lambdacontext"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace/mocktracer"
+)
+
+func TestStartInferredSpan(t *testing.T) {
+	testcases := []struct {
+		fixture       string
+		operationName string
+		service       string
+		resource      string
+		startTime     time.Time
+		async         bool
+		tags          map[string]interface{}
+	}{
+		{
+			fixture:       "apig-rest-event.json",
+			operationName: "aws.apigateway",
+			service:       "abcdef1234.execute-api.us-east-1.amazonaws.com",
+			resource:      "GET /users/{id}",
+			startTime:     time.UnixMilli(1583349317135),
+			tags: map[string]interface{}{
+				"http.method": "GET",
+				"http.url":    "https://abcdef1234.execute-api.us-east-1.amazonaws.com/users/42",
+				"http.route":  "/users/{id}",
+				"stage":       "prod",
+				"apiid":       "abcdef1234",
+				"request_id":  "c6af9ac6-7b61-11e6-9a41-93e8deadbeef",
+			},
+		},
+		{
+			fixture:       "http-api-event.json",
+			operationName: "aws.httpapi",
+			service:       "id.execute-api.us-east-1.amazonaws.com",
+			resource:      "POST /my/path",
+			startTime:     time.UnixMilli(1583348638390),
+			tags: map[string]interface{}{
+				"http.method":       "POST",
+				"http.url":          "https://id.execute-api.us-east-1.amazonaws.com/my/path",
+				"http.route":        "/my/path",
+				"http.user_agent":   "agent",
+				"network.client.ip": "192.0.2.1",
+				"stage":             "$default",
+			},
+		},
+		{
+			fixture:       "websocket-event.json",
+			operationName: "aws.apigateway.websocket",
+			service:       "abcdefghij.execute-api.us-east-1.amazonaws.com",
+			resource:      "$connect",
+			startTime:     time.UnixMilli(1675966303004),
+			tags: map[string]interface{}{
+				"connection_id": "ABCD1234=",
+				"event_type":    "CONNECT",
+				"stage":         "dev",
+			},
+		},
+		{
+			fixture:       "function-url-event.json",
+			operationName: "aws.lambda.url",
+			service:       "a1b2c3d4e5f6.lambda-url.us-east-1.on.aws",
+			resource:      "POST /my/path",
+			startTime:     time.UnixMilli(1583348638390),
+			tags: map[string]interface{}{
+				"http.method": "POST",
+				"http.url":    "https://a1b2c3d4e5f6.lambda-url.us-east-1.on.aws/my/path",
+			},
+		},
+		{
+			fixture:       "alb-event.json",
+			operationName: "aws.elb",
+			service:       "lambda-alb-123578498.us-east-1.elb.amazonaws.com",
+			resource:      "GET /lambda",
+			tags: map[string]interface{}{
+				"http.method":      "GET",
+				"http.url":         "http://lambda-alb-123578498.us-east-1.elb.amazonaws.com/lambda",
+				"target_group_arn": "arn:aws:elasticloadbalancing:us-east-1:123456789012:targetgroup/lambda-target/abcdef",
+			},
+		},
+		{
+			fixture:       "sqs-event-batch.json",
+			operationName: "aws.sqs",
+			service:       "sqs",
+			resource:      "my-queue",
+			startTime:     time.UnixMilli(1545082649183),
+			async:         true,
+			tags: map[string]interface{}{
+				"queuename":        "my-queue",
+				"event_source_arn": "arn:aws:sqs:us-east-1:123456789012:my-queue",
+				"sender_id":        "AIDAIENQZJOLO23YVJ4VO",
+				"message_id":       "059f36b4-87a3-44ab-83d2-661975061981",
+			},
+		},
+		{
+			fixture:       "sns-event.json",
+			operationName: "aws.sns",
+			service:       "sns",
+			resource:      "my-topic",
+			startTime:     time.Date(2019, 1, 2, 12, 45, 7, 0, time.UTC),
+			async:         true,
+			tags: map[string]interface{}{
+				"topicname":  "my-topic",
+				"topic_arn":  "arn:aws:sns:us-east-1:123456789012:my-topic",
+				"message_id": "95df01b4-ee98-5cb9-9903-4c221d41eb5e",
+				"subject":    "example subject",
+			},
+		},
+		{
+			fixture:       "kinesis-event-batch.json",
+			operationName: "aws.kinesis",
+			service:       "kinesis",
+			resource:      "my-stream",
+			startTime:     time.UnixMilli(1545084650987),
+			async:         true,
+			tags: map[string]interface{}{
+				"streamname":    "my-stream",
+				"shardid":       "shardId-000000000006",
+				"partition_key": "partitionKey-1",
+				"event_name":    "aws:kinesis:record",
+			},
+		},
+		{
+			fixture:       "eventbridge-event.json",
+			operationName: "aws.eventbridge",
+			service:       "eventbridge",
+			resource:      "my.event.bus.source",
+			startTime:     time.Date(2023, 1, 2, 12, 45, 7, 0, time.UTC),
+			async:         true,
+			tags: map[string]interface{}{
+				"detail_type": "OrderCreated",
+			},
+		},
+		{
+			fixture:       "s3-event.json",
+			operationName: "aws.s3",
+			service:       "s3",
+			resource:      "example-bucket",
+			startTime:     time.Date(2019, 9, 3, 19, 37, 27, 192000000, time.UTC),
+			async:         true,
+			tags: map[string]interface{}{
+				"bucketname":  "example-bucket",
+				"bucket_arn":  "arn:aws:s3:::example-bucket",
+				"object_key":  "test/key",
+				"object_size": "1024",
+				"event_name":  "ObjectCreated:Put",
+			},
+		},
+		{
+			fixture:       "dynamodb-event.json",
+			operationName: "aws.dynamodb",
+			service:       "dynamodb",
+			resource:      "ExampleTableWithStream",
+			startTime:     time.Unix(1428537600, 0),
+			async:         true,
+			tags: map[string]interface{}{
+				"tablename":        "ExampleTableWithStream",
+				"event_name":       "INSERT",
+				"stream_view_type": "NEW_AND_OLD_IMAGES",
+				"size_bytes":       "26",
+			},
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.fixture, func(t *testing.T) {
+			mt := mocktracer.Start()
+			defer mt.Stop()
+
+			ev := loadRawJSON(t, "../testdata/"+tc.fixture)
+			before := time.Now()
+			span, async, ok := startInferredSpan(context.Background(), *ev)
+			assert.True(t, ok)
+			assert.Equal(t, tc.async, async)
+			span.Finish()
+
+			finishedSpan := mt.FinishedSpans()[0]
+			assert.Equal(t, tc.operationName, finishedSpan.OperationName())
+			assert.Equal(t, tc.service, finishedSpan.Tag("service.name"))
+			assert.Equal(t, tc.resource, finishedSpan.Tag("resource.name"))
+			assert.Equal(t, "web", finishedSpan.Tag("span.type"))
+			assert.Equal(t, "self", finishedSpan.Tag("_inferred_span.tag_source"))
+			if tc.async {
+				assert.Equal(t, "async", finishedSpan.Tag("_inferred_span.synchronicity"))
+			} else {
+				assert.Equal(t, "sync", finishedSpan.Tag("_inferred_span.synchronicity"))
+			}
+			if tc.startTime.IsZero() {
+				assert.False(t, finishedSpan.StartTime().Before(before))
+			} else {
+				assert.WithinDuration(t, tc.startTime, finishedSpan.StartTime(), time.Millisecond)
+			}
+			for k, v := range tc.tags {
+				assert.Equal(t, v, finishedSpan.Tag(k), k)
+			}
+		})
+	}
+}
+
+func TestStartInferredSpanUnknownEvent(t *testing.T) {
+	for _, fixture := range []string{"non-proxy-no-headers.json", "invalid.json"} {
+		ev := loadRawJSON(t, "../testdata/"+fixture)
+		_, _, ok := startInferredSpan(context.Background(), *ev)
+		assert.False(t, ok, fixture)
+	}
+}
+
+func TestStartInferredSpanWithRootTraceContext(t *testing.T) {
+	mt := mocktracer.Start()
+	defer mt.Stop()
+
+	ctx := context.WithValue(context.Background(), traceContextKey, traceContextFromEvent)
+	ev := loadRawJSON(t, "../testdata/http-api-event.json")
+	span, _, _ := startInferredSpan(ctx, *ev)
+	span.Finish()
+
+	finishedSpan := mt.FinishedSpans()[0]
+	assert.Equal(t, uint64(1231452342), finishedSpan.TraceID())
+	assert.Equal(t, uint64(45678910), finishedSpan.ParentID())
+}
+
+func TestFinishInferredSpanStatusCode(t *testing.T) {
+	mt := mocktracer.Start()
+	defer mt.Stop()
+
+	ev := loadRawJSON(t, "../testdata/http-api-event.json")
+	span, _, _ := startInferredSpan(context.Background(), *ev)
+	finishInferredSpan(span, 201)
+
+	assert.Equal(t, "201", mt.FinishedSpans()[0].Tag("http.status_code"))
+}
+
+func TestFinishInferredSpanServerError(t *testing.T) {
+	mt := mocktracer.Start()
+	defer mt.Stop()
+
+	ev := loadRawJSON(t, "../testdata/alb-event.json")
+	span, _, _ := startInferredSpan(context.Background(), *ev)
+	finishInferredSpan(span, 503)
+
+	finishedSpan := mt.FinishedSpans()[0]
+	assert.Equal(t, "503", finishedSpan.Tag("http.status_code"))
+	assert.NotNil(t, finishedSpan.Tag("error"))
+}
+
+func TestStartFunctionExecutionSpanWithInferredSpan(t *testing.T) {
+	mt := mocktracer.Start()
+	defer mt.Stop()
+
+	lambdacontext.FunctionName = "MockFunctionName"
+	ctx := lambdacontext.NewContext(context.Background(), &mockLambdaContext)
+	ctx = context.WithValue(ctx, traceContextKey, traceContextFromEvent)
+	ev := loadRawJSON(t, "../testdata/apig-rest-event.json")
+	span, _, _ := startInferredSpan(ctx, *ev)
+	ctx = context.WithValue(ctx, inferredSpanKey, span)
+
+	executionSpan, _ := startFunctionExecutionSpan(ctx, false, false)
+	executionSpan.Finish()
+	span.Finish()
+
+	finishedSpans := mt.FinishedSpans()
+	assert.Equal(t, "aws.lambda", finishedSpans[0].OperationName())
+	assert.Equal(t, span.Context().SpanID(), finishedSpans[0].ParentID())
+	assert.Equal(t, uint64(1231452342), finishedSpans[0].TraceID())
+}
+
+func TestResourceNameFromARN(t *testing.T) {
+	assert.Equal(t, "my-queue", resourceNameFromARN("arn:aws:sqs:us-east-1:123456789012:my-queue"))
+	assert.Equal(t, "my-stream", resourceNameFromARN("arn:aws:kinesis:us-east-1:123456789012:stream/my-stream"))
+	assert.Equal(t, "", resourceNameFromARN(""))
+}
@@ -11,16 +11,21 @@ package trace
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/DataDog/datadog-lambda-go/internal/extension"
 	"github.com/DataDog/datadog-lambda-go/internal/logger"
+	"github.com/DataDog/datadog-lambda-go/internal/trigger"
 	"github.com/DataDog/datadog-lambda-go/internal/version"
 	"github.com/aws/aws-lambda-go/lambdacontext"
 	"go.opentelemetry.io/otel"
 	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace"
+	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace/ext"
 	ddotel "gopkg.in/DataDog/dd-trace-go.v1/ddtrace/opentelemetry"
 	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace/tracer"
 )
@@ -34,7 +39,12 @@ type (
 		otelTracerEnabled        bool
 		extensionManager         *extension.ExtensionManager
 		traceContextExtractor    ContextExtractor
+		propagationStyleExtract  []PropagationStyle
+		coldStartTracing         bool
+		capturePayload           bool
+		payloadTagger            payloadTagger
 		tracerOptions            []tracer.StartOption
+		globalTags               []string
 	}
 
 	// Config gives options for how the Listener should work
@@ -44,25 +54,58 @@ type (
 		UniversalInstrumentation bool
 		OtelTracerEnabled        bool
 		TraceContextExtractor    ContextExtractor
-		TracerOptions            []tracer.StartOption
+		// PropagationStyleExtract lists the propagation styles read from events, in order of precedence.
+		// It defaults to DefaultPropagationStyleExtract.
+		PropagationStyleExtract []PropagationStyle
+		// ColdStartTracing reports the init phase of the function as a span on the first invocation.
+		ColdStartTracing bool
+		// CapturePayload tags the function execution span with the flattened invocation event and response.
+		CapturePayload bool
+		// CapturePayloadMaxDepth is the depth past which captured payloads are tagged as JSON strings.
+		// It defaults to DefaultCapturePayloadMaxDepth.
+		CapturePayloadMaxDepth int
+		// CapturePayloadRedactedKeys lists payload fields redacted in addition to DefaultCapturePayloadRedactedKeys.
+		CapturePayloadRedactedKeys []string
+		TracerOptions              []tracer.StartOption
+		// GlobalTags are the unified service tags, as "key:value" strings. The service, env and version tags set the
+		// service, environment and version of the tracer, the others are added as global tags.
+		GlobalTags []string
 	}
 )
 
-// The function execution span is the top-level span representing the current Lambda function execution
-var functionExecutionSpan ddtrace.Span
+// invocationSpans holds the spans of a single invocation, so that concurrent invocations don't share them
+type invocationSpans struct {
+	// functionExecutionSpan is the top-level span representing the Lambda function execution
+	functionExecutionSpan ddtrace.Span
+	// inferredSpan represents the managed service that triggered the execution, when the extension doesn't create
+	// it. It is only set while the span is still open.
+	inferredSpan ddtrace.Span
+}
 
 var tracerInitialized = false
 
 // MakeListener initializes a new trace lambda Listener
 func MakeListener(config Config, extensionManager *extension.ExtensionManager) Listener {
+	if config.PropagationStyleExtract == nil {
+		config.PropagationStyleExtract = DefaultPropagationStyleExtract
+	}
+	if config.TraceContextExtractor == nil {
+		config.TraceContextExtractor = DefaultTraceExtractor
+	}
+
 	l := Listener{
 		ddTraceEnabled:           config.DDTraceEnabled,
 		mergeXrayTraces:          config.MergeXrayTraces,
 		universalInstrumentation: config.UniversalInstrumentation,
 		otelTracerEnabled:        config.OtelTracerEnabled,
 		extensionManager:         extensionManager,
-		traceContextExtractor:    config.TraceContextExtractor,
+		traceContextExtractor:    withPropagationStyles(config.TraceContextExtractor, config.PropagationStyleExtract),
+		propagationStyleExtract:  config.PropagationStyleExtract,
+		coldStartTracing:         config.ColdStartTracing,
+		capturePayload:           config.CapturePayload,
+		payloadTagger:            newPayloadTagger(config.CapturePayloadMaxDepth, config.CapturePayloadRedactedKeys),
 		tracerOptions:            config.TracerOptions,
+		globalTags:               config.GlobalTags,
 	}
 
 	if l.ddTraceEnabled && !tracerInitialized {
@@ -78,13 +121,15 @@ func (l *Listener) initTracer() {
 		serviceName = "aws.lambda"
 	}
 	extensionNotRunning := !l.extensionManager.IsExtensionRunning()
-	opts := append([]tracer.StartOption{
+	opts := []tracer.StartOption{
 		tracer.WithService(serviceName),
 		tracer.WithLambdaMode(extensionNotRunning),
 		tracer.WithStatsComputation(false), // Disabled: stats computation adds an HTTP round-trip to the extension on every flush, causing per-invocation latency overhead in Lambda
 		tracer.WithGlobalTag("_dd.origin", "lambda"),
 		tracer.WithSendRetries(2),
-	}, l.tracerOptions...)
+	}
+	opts = append(opts, globalTagOptions(l.globalTags)...)
+	opts = append(opts, l.tracerOptions...)
 	if l.otelTracerEnabled {
 		provider := ddotel.NewTracerProvider(
 			opts...,
@@ -98,21 +143,75 @@ func (l *Listener) initTracer() {
 	tracerInitialized = true
 }
 
+// globalTagOptions converts the unified service tags into tracer options. Options passed by the user come after them,
+// so that they take precedence.
+func globalTagOptions(tags []string) []tracer.StartOption {
+	var opts []tracer.StartOption
+	for _, tag := range tags {
+		key, value, _ := strings.Cut(tag, ":")
+		switch key {
+		case "service":
+			opts = append(opts, tracer.WithService(value))
+		case "env":
+			opts = append(opts, tracer.WithEnv(value))
+		case "version":
+			opts = append(opts, tracer.WithServiceVersion(value))
+		default:
+			opts = append(opts, tracer.WithGlobalTag(key, value))
+		}
+	}
+	return opts
+}
+
 // HandlerStarted starts the function execution span if Datadog tracing is enabled
 func (l *Listener) HandlerStarted(ctx context.Context, msg json.RawMessage) context.Context {
+	if coldStart, _ := ctx.Value("cold_start").(bool); coldStart && !(l.ddTraceEnabled && l.coldStartTracing) {
+		skipColdStartSpans()
+	}
 	if !l.ddTraceEnabled {
 		return ctx
 	}
+	startTime := time.Now()
 
 	if l.universalInstrumentation && l.extensionManager.IsExtensionRunning() {
 		ctx = l.extensionManager.SendStartInvocationRequest(ctx, msg)
 	}
 
 	ctx, _ = contextWithRootTraceContext(ctx, msg, l.mergeXrayTraces, l.traceContextExtractor)
+	if links := getSpanLinksFromEvent(ctx, msg, l.propagationStyleExtract); len(links) > 0 {
+		ctx = context.WithValue(ctx, spanLinksKey, links)
+	}
 
 	isDdServerlessSpan := l.universalInstrumentation && l.extensionManager.IsExtensionRunning()
-	functionExecutionSpan, ctx = startFunctionExecutionSpan(ctx, l.mergeXrayTraces, isDdServerlessSpan)
 
+	spans := &invocationSpans{}
+
+	// The extension creates the inferred span itself when it handles the invocation
+	isAsyncInferredSpan := false
+	if !isDdServerlessSpan {
+		if span, async, ok := startInferredSpan(ctx, msg); ok {
+			spans.inferredSpan = span
+			isAsyncInferredSpan = async
+			ctx = context.WithValue(ctx, inferredSpanKey, span)
+		}
+	}
+
+	spans.functionExecutionSpan, ctx = startFunctionExecutionSpan(ctx, l.mergeXrayTraces, isDdServerlessSpan)
+	functionExecutionSpan := spans.functionExecutionSpan
+	if l.capturePayload {
+		l.payloadTagger.tagRequest(functionExecutionSpan, msg)
+	}
+	if coldStart, _ := ctx.Value("cold_start").(bool); coldStart && l.coldStartTracing {
+		startColdStartSpans(functionExecutionSpan, startTime)
+	}
+
+	// The inferred span of queue and stream triggers covers the time spent in the service before the function ran
+	if isAsyncInferredSpan {
+		spans.inferredSpan.Finish()
+		spans.inferredSpan = nil
+	}
+
+	ctx = context.WithValue(ctx, invocationSpansKey, spans)
 	// Add the span to the context so the user can create child spans
 	ctx = tracer.ContextWithSpan(ctx, functionExecutionSpan)
 
@@ -121,19 +220,55 @@ func (l *Listener) HandlerStarted(ctx context.Context, msg json.RawMessage) cont
 
 // HandlerFinished ends the function execution span and stops the tracer
 func (l *Listener) HandlerFinished(ctx context.Context, err error) {
-	if functionExecutionSpan != nil {
-		functionExecutionSpan.Finish(tracer.WithError(err))
+	spans, ok := ctx.Value(invocationSpansKey).(*invocationSpans)
+	if !ok {
+		spans = &invocationSpans{}
+	}
+	statusCode, hasStatusCode := getResponseStatusCode(ctx)
+
+	if functionExecutionSpan := spans.functionExecutionSpan; functionExecutionSpan != nil {
+		if l.capturePayload {
+			l.payloadTagger.tagResponse(functionExecutionSpan, ctx.Value(extension.DdLambdaResponse))
+		}
+		spanErr := err
+		if hasStatusCode {
+			functionExecutionSpan.SetTag(ext.HTTPCode, strconv.Itoa(statusCode))
+			// A server error response marks the span as an error even if the handler didn't return one
+			if spanErr == nil {
+				spanErr = statusCodeError(statusCode)
+			}
+		}
+		finishOpts := []tracer.FinishOption{tracer.WithError(spanErr)}
+		// Errors such as handler panics carry the stack where they occurred, which is more useful than the current one
+		var st extension.StackTracer
+		if errors.As(spanErr, &st) {
+			functionExecutionSpan.SetTag(ext.ErrorStack, string(st.Stack()))
+			finishOpts = append(finishOpts, tracer.NoDebugStack())
+		}
+		functionExecutionSpan.Finish(finishOpts...)
 
-		finishConfig := ddtrace.FinishConfig{Error: err}
+		finishConfig := ddtrace.FinishConfig{Error: spanErr}
 
 		if l.universalInstrumentation && l.extensionManager.IsExtensionRunning() {
 			l.extensionManager.SendEndInvocationRequest(ctx, functionExecutionSpan, finishConfig)
 		}
 	}
 
+	if spans.inferredSpan != nil {
+		finishInferredSpan(spans.inferredSpan, statusCode)
+	}
+
 	tracer.Flush()
 }
 
+// HandlerTimedOut marks the function execution span as timed out, then ends it like HandlerFinished
+func (l *Listener) HandlerTimedOut(ctx context.Context, err error) {
+	if spans, ok := ctx.Value(invocationSpansKey).(*invocationSpans); ok && spans.functionExecutionSpan != nil {
+		spans.functionExecutionSpan.SetTag("timeout", true)
+	}
+	l.HandlerFinished(ctx, err)
+}
+
 // startFunctionExecutionSpan starts a span that represents the current Lambda function execution
 // and returns the span so that it can be finished when the function execution is complete
 func startFunctionExecutionSpan(ctx context.Context, mergeXrayTraces bool, isDdServerlessSpan bool) (tracer.Span, context.Context) {
@@ -154,6 +289,12 @@ func startFunctionExecutionSpan(ctx context.Context, mergeXrayTraces bool, isDdS
 	if err == nil {
 		parentSpanContext = convertedSpanContext
 	}
+	hasRootTraceContext := parentSpanContext != nil
+
+	// The inferred span of the trigger, if any, sits between the root trace context and the function execution span
+	if span, ok := ctx.Value(inferredSpanKey).(ddtrace.Span); ok {
+		parentSpanContext = span.Context()
+	}
 
 	resourceName := lambdacontext.FunctionName
 	if isDdServerlessSpan {
@@ -161,8 +302,7 @@ func startFunctionExecutionSpan(ctx context.Context, mergeXrayTraces bool, isDdS
 		resourceName = string(extension.DdSeverlessSpan)
 	}
 
-	span := tracer.StartSpan(
-		"aws.lambda", // This operation name will be replaced with the value of the service tag by the Forwarder
+	opts := []tracer.StartSpanOption{
 		tracer.SpanType("serverless"),
 		tracer.ChildOf(parentSpanContext),
 		tracer.ResourceName(resourceName),
@@ -174,9 +314,23 @@ func startFunctionExecutionSpan(ctx context.Context, mergeXrayTraces bool, isDdS
 		tracer.Tag("functionname", strings.ToLower(lambdacontext.FunctionName)),
 		tracer.Tag("datadog_lambda", version.DDLambdaVersion),
 		tracer.Tag("dd_trace", version.DDTraceVersion),
+	}
+	if triggerTags, ok := trigger.TagsFromContext(ctx); ok {
+		for k, v := range triggerTags {
+			opts = append(opts, tracer.Tag(k, v))
+		}
+	}
+	// Records of a batch event other than the one used as the parent are linked to the span
+	if links, ok := ctx.Value(spanLinksKey).([]ddtrace.SpanLink); ok {
+		opts = append(opts, tracer.WithSpanLinks(links))
+	}
+
+	span := tracer.StartSpan(
+		"aws.lambda", // This operation name will be replaced with the value of the service tag by the Forwarder
+		opts...,
 	)
 
-	if parentSpanContext != nil && mergeXrayTraces {
+	if hasRootTraceContext && mergeXrayTraces {
 		// This tag will cause the Forwarder to drop the span (to avoid redundancy with X-Ray)
 		span.SetTag("_dd.parent_source", "xray")
 	}
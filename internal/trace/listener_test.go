@@ -10,12 +10,16 @@ package trace
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"testing"
 
 	"github.com/DataDog/datadog-lambda-go/internal/extension"
+	"github.com/DataDog/datadog-lambda-go/internal/trigger"
+	"github.com/aws/aws-lambda-go/events"
 	"github.com/aws/aws-lambda-go/lambdacontext"
 	"github.com/stretchr/testify/assert"
+	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace"
 	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace/mocktracer"
 )
 
@@ -174,3 +178,237 @@ func TestStartFunctionExecutionSpanWithExtension(t *testing.T) {
 	assert.Equal(t, string(extension.DdSeverlessSpan), finishedSpan.Tag("resource.name"))
 	assert.Equal(t, fmt.Sprint(span.Context().SpanID()), ctx.Value(extension.DdSpanId).(string))
 }
+
+func TestStartFunctionExecutionSpanWithSpanLinks(t *testing.T) {
+	ctx := context.Background()
+
+	lambdacontext.FunctionName = "MockFunctionName"
+	ctx = lambdacontext.NewContext(ctx, &mockLambdaContext)
+	ctx = context.WithValue(ctx, traceContextKey, traceContextFromEvent)
+	ctx = context.WithValue(ctx, spanLinksKey, []ddtrace.SpanLink{{TraceID: 3333, SpanID: 4444}})
+	//nolint
+	ctx = context.WithValue(ctx, "cold_start", true)
+
+	mt := mocktracer.Start()
+	defer mt.Stop()
+
+	span, _ := startFunctionExecutionSpan(ctx, false, false)
+	span.Finish()
+	finishedSpan := mt.FinishedSpans()[0]
+
+	assert.Equal(t, []ddtrace.SpanLink{{TraceID: 3333, SpanID: 4444}}, finishedSpan.Links())
+}
+
+func TestListenerAsyncInferredSpan(t *testing.T) {
+	mt := mocktracer.Start()
+	defer mt.Stop()
+
+	l := Listener{
+		ddTraceEnabled:        true,
+		extensionManager:      extension.BuildExtensionManager(false),
+		traceContextExtractor: withPropagationStyles(DefaultTraceExtractor, DefaultPropagationStyleExtract),
+	}
+	lambdacontext.FunctionName = "MockFunctionName"
+	ctx := lambdacontext.NewContext(context.Background(), &mockLambdaContext)
+	ev := loadRawJSON(t, "../testdata/sqs-event-batch.json")
+
+	ctx = l.HandlerStarted(ctx, *ev)
+
+	// The SQS span is finished as soon as the function starts running
+	finishedSpans := mt.FinishedSpans()
+	assert.Len(t, finishedSpans, 1)
+	assert.Equal(t, "aws.sqs", finishedSpans[0].OperationName())
+	assert.Equal(t, uint64(1111), finishedSpans[0].TraceID())
+	assert.Equal(t, uint64(2222), finishedSpans[0].ParentID())
+
+	l.HandlerFinished(ctx, nil)
+
+	finishedSpans = mt.FinishedSpans()
+	assert.Len(t, finishedSpans, 2)
+	assert.Equal(t, "aws.lambda", finishedSpans[1].OperationName())
+	assert.Equal(t, finishedSpans[0].SpanID(), finishedSpans[1].ParentID())
+}
+
+func TestStartFunctionExecutionSpanWithTriggerTags(t *testing.T) {
+	ctx := context.Background()
+
+	lambdacontext.FunctionName = "MockFunctionName"
+	ctx = lambdacontext.NewContext(ctx, &mockLambdaContext)
+	ctx = context.WithValue(ctx, traceContextKey, traceContextFromEvent)
+	ctx = trigger.ContextWithEvent(ctx, trigger.Event{Source: trigger.SQS, Tags: map[string]string{
+		trigger.EventSourceTag:    "sqs",
+		trigger.EventSourceARNTag: "arn:aws:sqs:us-east-1:123456789012:my-queue",
+	}})
+	//nolint
+	ctx = context.WithValue(ctx, "cold_start", true)
+
+	mt := mocktracer.Start()
+	defer mt.Stop()
+
+	span, _ := startFunctionExecutionSpan(ctx, false, false)
+	span.Finish()
+	finishedSpan := mt.FinishedSpans()[0]
+
+	assert.Equal(t, "sqs", finishedSpan.Tag("function_trigger.event_source"))
+	assert.Equal(t, "arn:aws:sqs:us-east-1:123456789012:my-queue", finishedSpan.Tag("function_trigger.event_source_arn"))
+}
+
+func TestListenerHandlerFinishedStatusCode(t *testing.T) {
+	testcases := []struct {
+		name       string
+		source     trigger.EventSource
+		response   interface{}
+		err        error
+		statusCode interface{}
+		errorMsg   interface{}
+	}{
+		{"ok", trigger.APIGatewayREST, events.APIGatewayProxyResponse{StatusCode: 200}, nil, "200", nil},
+		{"server error", trigger.APIGatewayREST, events.APIGatewayProxyResponse{StatusCode: 500}, nil, "500", "HTTP response status code 500 Internal Server Error"},
+		{"handler error", trigger.APIGatewayREST, events.APIGatewayProxyResponse{StatusCode: 500}, fmt.Errorf("oops"), "500", "oops"},
+		{"custom response", trigger.FunctionURL, map[string]interface{}{"statusCode": 502}, nil, "502", "HTTP response status code 502 Bad Gateway"},
+		{"not an HTTP response", trigger.APIGatewayREST, "hello", nil, nil, nil},
+		{"direct invocation", trigger.Unknown, map[string]interface{}{"statusCode": 500}, nil, nil, nil},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			mt := mocktracer.Start()
+			defer mt.Stop()
+
+			l := Listener{
+				ddTraceEnabled:        true,
+				extensionManager:      extension.BuildExtensionManager(false),
+				traceContextExtractor: withPropagationStyles(DefaultTraceExtractor, DefaultPropagationStyleExtract),
+			}
+			lambdacontext.FunctionName = "MockFunctionName"
+			ctx := lambdacontext.NewContext(context.Background(), &mockLambdaContext)
+			ctx = trigger.ContextWithEvent(ctx, trigger.Event{Source: tc.source})
+			ev := loadRawJSON(t, "../testdata/non-proxy-no-headers.json")
+
+			ctx = l.HandlerStarted(ctx, *ev)
+			ctx = context.WithValue(ctx, extension.DdLambdaResponse, tc.response)
+			l.HandlerFinished(ctx, tc.err)
+
+			finishedSpan := mt.FinishedSpans()[0]
+			assert.Equal(t, "aws.lambda", finishedSpan.OperationName())
+			assert.Equal(t, tc.statusCode, finishedSpan.Tag("http.status_code"))
+			assert.Equal(t, tc.errorMsg, finishedSpan.Tag("error.message"))
+		})
+	}
+}
+
+func TestListenerCapturePayload(t *testing.T) {
+	mt := mocktracer.Start()
+	defer mt.Stop()
+
+	l := Listener{
+		ddTraceEnabled:        true,
+		extensionManager:      extension.BuildExtensionManager(false),
+		traceContextExtractor: withPropagationStyles(DefaultTraceExtractor, DefaultPropagationStyleExtract),
+		capturePayload:        true,
+		payloadTagger:         newPayloadTagger(0, nil),
+	}
+	lambdacontext.FunctionName = "MockFunctionName"
+	ctx := lambdacontext.NewContext(context.Background(), &mockLambdaContext)
+	ev := json.RawMessage(`{"user": "jane", "password": "hunter2"}`)
+
+	ctx = l.HandlerStarted(ctx, ev)
+	ctx = context.WithValue(ctx, extension.DdLambdaResponse, map[string]string{"greeting": "hello jane"})
+	l.HandlerFinished(ctx, nil)
+
+	finishedSpan := mt.FinishedSpans()[0]
+	assert.Equal(t, "jane", finishedSpan.Tag("function.request.user"))
+	assert.Equal(t, "redacted", finishedSpan.Tag("function.request.password"))
+	assert.Equal(t, "hello jane", finishedSpan.Tag("function.response.greeting"))
+}
+
+func TestListenerCapturePayloadDisabled(t *testing.T) {
+	mt := mocktracer.Start()
+	defer mt.Stop()
+
+	l := Listener{
+		ddTraceEnabled:        true,
+		extensionManager:      extension.BuildExtensionManager(false),
+		traceContextExtractor: withPropagationStyles(DefaultTraceExtractor, DefaultPropagationStyleExtract),
+	}
+	lambdacontext.FunctionName = "MockFunctionName"
+	ctx := lambdacontext.NewContext(context.Background(), &mockLambdaContext)
+
+	ctx = l.HandlerStarted(ctx, json.RawMessage(`{"user": "jane"}`))
+	l.HandlerFinished(ctx, nil)
+
+	assert.Nil(t, mt.FinishedSpans()[0].Tag("function.request.user"))
+}
+
+func TestListenerHandlerTimedOut(t *testing.T) {
+	mt := mocktracer.Start()
+	defer mt.Stop()
+
+	l := Listener{
+		ddTraceEnabled:        true,
+		extensionManager:      extension.BuildExtensionManager(false),
+		traceContextExtractor: withPropagationStyles(DefaultTraceExtractor, DefaultPropagationStyleExtract),
+	}
+	lambdacontext.FunctionName = "MockFunctionName"
+	ctx := lambdacontext.NewContext(context.Background(), &mockLambdaContext)
+
+	ctx = l.HandlerStarted(ctx, json.RawMessage(`{}`))
+	l.HandlerTimedOut(ctx, fmt.Errorf("timed out"))
+
+	finishedSpan := mt.FinishedSpans()[0]
+	assert.Equal(t, "true", finishedSpan.Tag("timeout"))
+	assert.Equal(t, "timed out", finishedSpan.Tag("error.message"))
+}
+
+type mockStackError struct{}
+
+func (mockStackError) Error() string { return "panic: oops" }
+
+func (mockStackError) Stack() []byte { return []byte("goroutine 1 [running]:\nmain.handler()") }
+
+func TestListenerHandlerFinishedErrorStack(t *testing.T) {
+	mt := mocktracer.Start()
+	defer mt.Stop()
+
+	l := Listener{
+		ddTraceEnabled:        true,
+		extensionManager:      extension.BuildExtensionManager(false),
+		traceContextExtractor: withPropagationStyles(DefaultTraceExtractor, DefaultPropagationStyleExtract),
+	}
+	lambdacontext.FunctionName = "MockFunctionName"
+	ctx := lambdacontext.NewContext(context.Background(), &mockLambdaContext)
+
+	ctx = l.HandlerStarted(ctx, json.RawMessage(`{}`))
+	l.HandlerFinished(ctx, mockStackError{})
+
+	finishedSpan := mt.FinishedSpans()[0]
+	assert.Equal(t, "panic: oops", finishedSpan.Tag("error.message"))
+	assert.Equal(t, "goroutine 1 [running]:\nmain.handler()", finishedSpan.Tag("error.stack"))
+}
+
+func TestListenerConcurrentInvocations(t *testing.T) {
+	mt := mocktracer.Start()
+	defer mt.Stop()
+
+	l := Listener{
+		ddTraceEnabled:        true,
+		extensionManager:      extension.BuildExtensionManager(false),
+		traceContextExtractor: withPropagationStyles(DefaultTraceExtractor, DefaultPropagationStyleExtract),
+	}
+	lambdacontext.FunctionName = "MockFunctionName"
+	firstCtx := l.HandlerStarted(lambdacontext.NewContext(context.Background(), &lambdacontext.LambdaContext{AwsRequestID: "first"}), json.RawMessage(`{}`))
+	secondCtx := l.HandlerStarted(lambdacontext.NewContext(context.Background(), &lambdacontext.LambdaContext{AwsRequestID: "second"}), json.RawMessage(`{}`))
+
+	firstSpans := firstCtx.Value(invocationSpansKey).(*invocationSpans)
+	secondSpans := secondCtx.Value(invocationSpansKey).(*invocationSpans)
+	assert.NotEqual(t, firstSpans.functionExecutionSpan.Context().SpanID(), secondSpans.functionExecutionSpan.Context().SpanID())
+
+	l.HandlerFinished(firstCtx, nil)
+	l.HandlerFinished(secondCtx, nil)
+
+	requestIDs := map[interface{}]int{}
+	for _, span := range mt.FinishedSpans() {
+		requestIDs[span.Tag("request_id")]++
+	}
+	assert.Equal(t, map[interface{}]int{"first": 1, "second": 1}, requestIDs)
+}
@@ -0,0 +1,205 @@
+/*
+ * Unless explicitly stated otherwise all files in this repository are licensed
+ * under the Apache License Version 2.0.
+ *
+ * This product includes software developed at Datadog (https://www.datadoghq.com/).
+ * Copyright 2021 Datadog, Inc.
+ */
+
+package trace
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/DataDog/datadog-lambda-go/internal/logger"
+	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace"
+)
+
+const (
+	// requestPayloadTagPrefix prefixes the tags holding the captured event of an invocation
+	requestPayloadTagPrefix = "function.request"
+	// responsePayloadTagPrefix prefixes the tags holding the captured response of an invocation
+	responsePayloadTagPrefix = "function.response"
+	// redactedPayloadValue replaces the values of redacted payload fields
+	redactedPayloadValue = "redacted"
+
+	// DefaultCapturePayloadMaxDepth is the depth past which captured payloads are tagged as JSON strings.
+	DefaultCapturePayloadMaxDepth = 10
+	// rawPayloadMaxLength is the number of bytes of a payload that isn't JSON kept in its tag. Such payloads can't be
+	// redacted, so only their start is captured.
+	rawPayloadMaxLength = 256
+)
+
+// DefaultCapturePayloadRedactedKeys lists the payload fields whose values are never captured. Keys are matched
+// case-insensitively.
+var DefaultCapturePayloadRedactedKeys = []string{
+	"password", "passwd", "pwd", "secret", "token", "access_token", "refresh_token", "id_token",
+	"authorization", "x-authorization", "proxy-authorization", "cookie", "set-cookie",
+	"x-api-key", "api_key", "apikey", "x-amz-security-token", "client_secret",
+}
+
+// payloadTagger flattens JSON payloads into span tags.
+type payloadTagger struct {
+	maxDepth     int
+	redactedKeys map[string]struct{}
+}
+
+func newPayloadTagger(maxDepth int, redactedKeys []string) payloadTagger {
+	if maxDepth <= 0 {
+		maxDepth = DefaultCapturePayloadMaxDepth
+	}
+	keys := make(map[string]struct{}, len(DefaultCapturePayloadRedactedKeys)+len(redactedKeys))
+	for _, key := range DefaultCapturePayloadRedactedKeys {
+		keys[strings.ToLower(key)] = struct{}{}
+	}
+	for _, key := range redactedKeys {
+		keys[strings.ToLower(key)] = struct{}{}
+	}
+	return payloadTagger{maxDepth: maxDepth, redactedKeys: keys}
+}
+
+// tagRequest tags span with the flattened fields of the invocation event.
+func (pt payloadTagger) tagRequest(span ddtrace.Span, ev json.RawMessage) {
+	pt.tagPayload(span, requestPayloadTagPrefix, ev)
+}
+
+// tagResponse tags span with the flattened fields of the handler response.
+func (pt payloadTagger) tagResponse(span ddtrace.Span, response interface{}) {
+	var content []byte
+	switch r := response.(type) {
+	case json.RawMessage:
+		content = r
+	case []byte:
+		content = r
+	default:
+		var err error
+		if content, err = json.Marshal(response); err != nil {
+			logger.Debug(fmt.Sprintf("could not capture the response payload: %v", err))
+			return
+		}
+	}
+	pt.tagPayload(span, responsePayloadTagPrefix, content)
+}
+
+func (pt payloadTagger) tagPayload(span ddtrace.Span, prefix string, payload []byte) {
+	value, ok := decodeJSON(payload)
+	if !ok {
+		// Payloads that aren't JSON are captured as is, truncated
+		span.SetTag(prefix, truncatePayload(payload, rawPayloadMaxLength))
+		return
+	}
+	tags := map[string]string{}
+	pt.flatten(tags, prefix, value, 0)
+	for k, v := range tags {
+		span.SetTag(k, v)
+	}
+}
+
+// flatten adds a tag for each scalar of value, keyed by its path. Objects and arrays found deeper than the max
+// depth are tagged as JSON strings, and strings holding JSON objects or arrays, such as the body of API Gateway
+// events, are flattened too.
+func (pt payloadTagger) flatten(tags map[string]string, key string, value interface{}, depth int) {
+	switch v := decodeJSONString(value).(type) {
+	case map[string]interface{}:
+		if depth >= pt.maxDepth {
+			tags[key] = encodeJSON(pt.redact(v))
+			return
+		}
+		for k, child := range v {
+			if _, redacted := pt.redactedKeys[strings.ToLower(k)]; redacted {
+				tags[key+"."+k] = redactedPayloadValue
+				continue
+			}
+			pt.flatten(tags, key+"."+k, child, depth+1)
+		}
+	case []interface{}:
+		if depth >= pt.maxDepth {
+			tags[key] = encodeJSON(pt.redact(v))
+			return
+		}
+		for i, child := range v {
+			pt.flatten(tags, key+"."+strconv.Itoa(i), child, depth+1)
+		}
+	case string:
+		tags[key] = v
+	case json.Number:
+		tags[key] = v.String()
+	case bool:
+		tags[key] = strconv.FormatBool(v)
+	case nil:
+		tags[key] = "null"
+	}
+}
+
+// redact returns a copy of value in which the values of redacted keys are replaced, so that it can be tagged as
+// a JSON string.
+func (pt payloadTagger) redact(value interface{}) interface{} {
+	switch v := decodeJSONString(value).(type) {
+	case map[string]interface{}:
+		redacted := make(map[string]interface{}, len(v))
+		for k, child := range v {
+			if _, ok := pt.redactedKeys[strings.ToLower(k)]; ok {
+				redacted[k] = redactedPayloadValue
+			} else {
+				redacted[k] = pt.redact(child)
+			}
+		}
+		return redacted
+	case []interface{}:
+		redacted := make([]interface{}, len(v))
+		for i, child := range v {
+			redacted[i] = pt.redact(child)
+		}
+		return redacted
+	}
+	return value
+}
+
+// truncatePayload returns the first maxLength bytes of payload as a string, without splitting a UTF-8 character.
+func truncatePayload(payload []byte, maxLength int) string {
+	if len(payload) <= maxLength {
+		return string(payload)
+	}
+	end := maxLength
+	for end > 0 && !utf8.RuneStart(payload[end]) {
+		end--
+	}
+	return string(payload[:end]) + "..."
+}
+
+// decodeJSONString returns the decoded object or array held by a string value, or value itself.
+func decodeJSONString(value interface{}) interface{} {
+	s, ok := value.(string)
+	if !ok {
+		return value
+	}
+	if trimmed := strings.TrimSpace(s); strings.HasPrefix(trimmed, "{") || strings.HasPrefix(trimmed, "[") {
+		if decoded, ok := decodeJSON([]byte(trimmed)); ok {
+			return decoded
+		}
+	}
+	return value
+}
+
+func decodeJSON(content []byte) (interface{}, bool) {
+	decoder := json.NewDecoder(bytes.NewReader(content))
+	decoder.UseNumber()
+	var value interface{}
+	if err := decoder.Decode(&value); err != nil || decoder.More() {
+		return nil, false
+	}
+	return value, true
+}
+
+func encodeJSON(value interface{}) string {
+	content, err := json.Marshal(value)
+	if err != nil {
+		return ""
+	}
+	return string(content)
+}
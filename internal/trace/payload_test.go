@@ -0,0 +1,144 @@
+/*
+ * Unless explicitly stated otherwise all files in this repository are licensed
+ * under the Apache License Version 2.0.
+ *
+ * This product includes software developed at Datadog (https://www.datadoghq.com/).
+ * Copyright 2021 Datadog, Inc.
+ */
+
+package trace
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace/mocktracer"
+	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace/tracer"
+)
+
+func captureTags(t *testing.T, tag func(pt payloadTagger, span tracer.Span), pt payloadTagger) map[string]interface{} {
+	mt := mocktracer.Start()
+	defer mt.Stop()
+
+	span := tracer.StartSpan("aws.lambda")
+	tag(pt, span)
+	span.Finish()
+
+	tags := map[string]interface{}{}
+	for k, v := range mt.FinishedSpans()[0].Tags() {
+		if strings.HasPrefix(k, "function.") {
+			tags[k] = v
+		}
+	}
+	return tags
+}
+
+func TestTagRequestFlattensEvent(t *testing.T) {
+	ev := json.RawMessage(`{"id": 42, "ok": true, "missing": null, "user": {"name": "jane", "roles": ["admin", "dev"]}}`)
+	tags := captureTags(t, func(pt payloadTagger, span tracer.Span) { pt.tagRequest(span, ev) }, newPayloadTagger(0, nil))
+
+	assert.Equal(t, map[string]interface{}{
+		"function.request.id":           "42",
+		"function.request.ok":           "true",
+		"function.request.missing":      "null",
+		"function.request.user.name":    "jane",
+		"function.request.user.roles.0": "admin",
+		"function.request.user.roles.1": "dev",
+	}, tags)
+}
+
+func TestTagRequestRedactsKeys(t *testing.T) {
+	ev := json.RawMessage(`{"headers": {"Authorization": "Bearer abc", "Accept": "*/*"}, "password": "hunter2", "card": "4242"}`)
+	tags := captureTags(t, func(pt payloadTagger, span tracer.Span) { pt.tagRequest(span, ev) }, newPayloadTagger(0, []string{"Card"}))
+
+	assert.Equal(t, map[string]interface{}{
+		"function.request.headers.Authorization": "redacted",
+		"function.request.headers.Accept":        "*/*",
+		"function.request.password":              "redacted",
+		"function.request.card":                  "redacted",
+	}, tags)
+}
+
+func TestTagRequestMaxDepth(t *testing.T) {
+	ev := json.RawMessage(`{"a": {"b": {"c": 1, "token": "abc"}}, "list": [[1, 2]]}`)
+	tags := captureTags(t, func(pt payloadTagger, span tracer.Span) { pt.tagRequest(span, ev) }, newPayloadTagger(2, nil))
+
+	assert.Equal(t, map[string]interface{}{
+		"function.request.a.b":    `{"c":1,"token":"redacted"}`,
+		"function.request.list.0": `[1,2]`,
+	}, tags)
+}
+
+func TestTagRequestFlattensJSONBody(t *testing.T) {
+	ev := json.RawMessage(`{"body": "{\"name\": \"jane\", \"secret\": \"s3cr3t\"}", "text": "{not json"}`)
+	tags := captureTags(t, func(pt payloadTagger, span tracer.Span) { pt.tagRequest(span, ev) }, newPayloadTagger(0, nil))
+
+	assert.Equal(t, map[string]interface{}{
+		"function.request.body.name":   "jane",
+		"function.request.body.secret": "redacted",
+		"function.request.text":        "{not json",
+	}, tags)
+}
+
+func TestTagRequestNotJSON(t *testing.T) {
+	ev := json.RawMessage(`hello world`)
+	tags := captureTags(t, func(pt payloadTagger, span tracer.Span) { pt.tagRequest(span, ev) }, newPayloadTagger(0, nil))
+
+	assert.Equal(t, map[string]interface{}{"function.request": "hello world"}, tags)
+}
+
+func TestTagRequestNotJSONTruncated(t *testing.T) {
+	ev := json.RawMessage(strings.Repeat("a", rawPayloadMaxLength) + "password=hunter2")
+	tags := captureTags(t, func(pt payloadTagger, span tracer.Span) { pt.tagRequest(span, ev) }, newPayloadTagger(0, nil))
+
+	assert.Equal(t, map[string]interface{}{"function.request": strings.Repeat("a", rawPayloadMaxLength) + "..."}, tags)
+}
+
+func TestTruncatePayloadKeepsCharacters(t *testing.T) {
+	assert.Equal(t, "ab...", truncatePayload([]byte("abé"), 3))
+	assert.Equal(t, "abé", truncatePayload([]byte("abé"), 4))
+}
+
+func TestTagResponse(t *testing.T) {
+	testcases := []struct {
+		name     string
+		response interface{}
+		expected map[string]interface{}
+	}{
+		{
+			name:     "struct",
+			response: events.APIGatewayProxyResponse{StatusCode: 200, Body: `{"token": "abc"}`},
+			expected: map[string]interface{}{
+				"function.response.statusCode":        "200",
+				"function.response.headers":           "null",
+				"function.response.multiValueHeaders": "null",
+				"function.response.body.token":        "redacted",
+			},
+		},
+		{
+			name:     "raw message",
+			response: json.RawMessage(`{"count": 3}`),
+			expected: map[string]interface{}{"function.response.count": "3"},
+		},
+		{
+			name:     "string",
+			response: "done",
+			expected: map[string]interface{}{"function.response": "done"},
+		},
+		{
+			name:     "nil",
+			response: nil,
+			expected: map[string]interface{}{"function.response": "null"},
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			tags := captureTags(t, func(pt payloadTagger, span tracer.Span) { pt.tagResponse(span, tc.response) }, newPayloadTagger(0, nil))
+			assert.Equal(t, tc.expected, tags)
+		})
+	}
+}
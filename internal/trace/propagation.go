@@ -0,0 +1,303 @@
+/*
+ * Unless explicitly stated otherwise all files in this repository are licensed
+ * under the Apache License Version 2.0.
+ *
+ * This product includes software developed at Datadog (https://www.datadoghq.com/).
+ * Copyright 2021 Datadog, Inc.
+ */
+
+package trace
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/DataDog/datadog-lambda-go/internal/logger"
+)
+
+// PropagationStyle is a format used to propagate trace contexts between services.
+type PropagationStyle string
+
+const (
+	// PropagationStyleDatadog reads the x-datadog-* headers.
+	PropagationStyleDatadog PropagationStyle = "datadog"
+	// PropagationStyleTraceContext reads the W3C traceparent and tracestate headers.
+	PropagationStyleTraceContext PropagationStyle = "tracecontext"
+	// PropagationStyleB3Multi reads the x-b3-* headers.
+	PropagationStyleB3Multi PropagationStyle = "b3multi"
+	// PropagationStyleB3Single reads the single b3 header.
+	PropagationStyleB3Single PropagationStyle = "b3 single header"
+)
+
+// DefaultPropagationStyleExtract is the list of styles used when none is configured, in order of precedence.
+var DefaultPropagationStyleExtract = []PropagationStyle{PropagationStyleDatadog, PropagationStyleTraceContext}
+
+// propagationStyleExtractors convert the headers of each propagation style to Datadog headers.
+var propagationStyleExtractors = map[PropagationStyle]func(headers map[string]string) (map[string]string, bool){
+	PropagationStyleDatadog:      extractDatadogHeaders,
+	PropagationStyleTraceContext: extractTraceContextHeaders,
+	PropagationStyleB3Multi:      extractB3MultiHeaders,
+	PropagationStyleB3Single:     extractB3SingleHeader,
+}
+
+// ParsePropagationStyles parses a comma separated list of propagation styles, in the format of the
+// DD_TRACE_PROPAGATION_STYLE_EXTRACT environment variable. "b3" is accepted as an alias of "b3multi",
+// and "none" disables the extraction of trace contexts from events.
+func ParsePropagationStyles(value string) []PropagationStyle {
+	styles := []PropagationStyle{}
+	for _, name := range strings.Split(value, ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		switch name {
+		case "", "none":
+			continue
+		case "b3":
+			name = string(PropagationStyleB3Multi)
+		}
+		style := PropagationStyle(name)
+		if _, ok := propagationStyleExtractors[style]; !ok {
+			logger.Debug(fmt.Sprintf("ignoring unknown propagation style %q", name))
+			continue
+		}
+		styles = append(styles, style)
+	}
+	return styles
+}
+
+// withPropagationStyles wraps a ContextExtractor so that the headers it returns are read in each of the given
+// propagation styles in turn. The trace context of the first style found is returned as Datadog headers.
+func withPropagationStyles(extractor ContextExtractor, styles []PropagationStyle) ContextExtractor {
+	return func(ctx context.Context, ev json.RawMessage) map[string]string {
+		return extractPropagatedHeaders(extractor(ctx, ev), styles)
+	}
+}
+
+// extractPropagatedHeaders returns the trace context of the first of the given propagation styles found in
+// headers, converted to Datadog headers.
+func extractPropagatedHeaders(headers map[string]string, styles []PropagationStyle) map[string]string {
+	lowercaseHeaders := make(map[string]string, len(headers))
+	for k, v := range headers {
+		lowercaseHeaders[strings.ToLower(k)] = v
+	}
+
+	for _, style := range styles {
+		if datadogHeaders, ok := propagationStyleExtractors[style](lowercaseHeaders); ok {
+			return datadogHeaders
+		}
+	}
+	return map[string]string{}
+}
+
+func extractDatadogHeaders(headers map[string]string) (map[string]string, bool) {
+	if headers[traceIDHeader] == "" {
+		return nil, false
+	}
+	datadogHeaders := map[string]string{}
+	for _, key := range []string{traceIDHeader, parentIDHeader, samplingPriorityHeader, originHeader, tagsHeader} {
+		if value, ok := headers[key]; ok {
+			datadogHeaders[key] = value
+		}
+	}
+	return datadogHeaders, true
+}
+
+// extractTraceContextHeaders reads the W3C traceparent header, along with the sampling priority, origin and
+// propagated tags that Datadog tracers add to the dd member of the tracestate header.
+func extractTraceContextHeaders(headers map[string]string) (map[string]string, bool) {
+	parts := strings.Split(strings.TrimSpace(headers[traceparentHeader]), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" || len(parts[3]) != 2 {
+		return nil, false
+	}
+	traceIDUpper, traceIDLower, ok := parseTraceIDHex(parts[1], 32)
+	if !ok {
+		return nil, false
+	}
+	parentID, err := strconv.ParseUint(parts[2], 16, 64)
+	if err != nil || len(parts[2]) != 16 || parentID == 0 {
+		return nil, false
+	}
+	flags, err := strconv.ParseUint(parts[3], 16, 8)
+	if err != nil {
+		return nil, false
+	}
+
+	sampled := flags&0x1 == 1
+	samplingPriority := 0
+	if sampled {
+		samplingPriority = 1
+	}
+
+	tags := map[string]string{}
+	origin := ""
+	for _, member := range strings.Split(headers[tracestateHeader], ",") {
+		member = strings.TrimSpace(member)
+		if !strings.HasPrefix(member, "dd=") {
+			continue
+		}
+		for _, field := range strings.Split(strings.TrimPrefix(member, "dd="), ";") {
+			key, value, found := strings.Cut(field, ":")
+			if !found {
+				continue
+			}
+			switch {
+			case key == "s":
+				// The tracestate priority is only used if it agrees with the sampled flag of the traceparent
+				if p, err := strconv.Atoi(value); err == nil && (p > 0) == sampled {
+					samplingPriority = p
+				}
+			case key == "o":
+				origin = strings.ReplaceAll(value, "~", "=")
+			case strings.HasPrefix(key, "t."):
+				tags["_dd.p."+strings.TrimPrefix(key, "t.")] = strings.ReplaceAll(value, "~", "=")
+			}
+		}
+	}
+	if traceIDUpper != 0 {
+		tags[traceIDUpperTag] = fmt.Sprintf("%016x", traceIDUpper)
+	}
+
+	datadogHeaders := map[string]string{
+		traceIDHeader:          strconv.FormatUint(traceIDLower, 10),
+		parentIDHeader:         strconv.FormatUint(parentID, 10),
+		samplingPriorityHeader: strconv.Itoa(samplingPriority),
+	}
+	if origin != "" {
+		datadogHeaders[originHeader] = origin
+	}
+	if len(tags) > 0 {
+		datadogHeaders[tagsHeader] = formatPropagatedTags(tags)
+	}
+	return datadogHeaders, true
+}
+
+// extractB3MultiHeaders reads the x-b3-traceid, x-b3-spanid, x-b3-sampled and x-b3-flags headers.
+func extractB3MultiHeaders(headers map[string]string) (map[string]string, bool) {
+	sampled := headers[b3SampledHeader]
+	if headers[b3FlagsHeader] == "1" {
+		sampled = "d"
+	}
+	return convertB3ToDatadogHeaders(headers[b3TraceIDHeader], headers[b3SpanIDHeader], sampled)
+}
+
+// extractB3SingleHeader reads the b3 header, in the {traceid}-{spanid}-{sampled}-{parentspanid} format.
+func extractB3SingleHeader(headers map[string]string) (map[string]string, bool) {
+	parts := strings.Split(strings.TrimSpace(headers[b3SingleHeader]), "-")
+	if len(parts) < 2 {
+		return nil, false
+	}
+	sampled := ""
+	if len(parts) > 2 {
+		sampled = parts[2]
+	}
+	return convertB3ToDatadogHeaders(parts[0], parts[1], sampled)
+}
+
+func convertB3ToDatadogHeaders(traceIDHex, spanIDHex, sampled string) (map[string]string, bool) {
+	if len(traceIDHex) != 16 && len(traceIDHex) != 32 {
+		return nil, false
+	}
+	traceIDUpper, traceIDLower, ok := parseTraceIDHex(traceIDHex, len(traceIDHex))
+	if !ok {
+		return nil, false
+	}
+	spanID, err := strconv.ParseUint(spanIDHex, 16, 64)
+	if err != nil || spanID == 0 {
+		return nil, false
+	}
+
+	datadogHeaders := map[string]string{
+		traceIDHeader:  strconv.FormatUint(traceIDLower, 10),
+		parentIDHeader: strconv.FormatUint(spanID, 10),
+	}
+	switch strings.ToLower(sampled) {
+	case "1", "true":
+		datadogHeaders[samplingPriorityHeader] = "1"
+	case "0", "false":
+		datadogHeaders[samplingPriorityHeader] = "0"
+	case "d":
+		datadogHeaders[samplingPriorityHeader] = userKeep
+	}
+	if traceIDUpper != 0 {
+		datadogHeaders[tagsHeader] = formatPropagatedTags(map[string]string{traceIDUpperTag: fmt.Sprintf("%016x", traceIDUpper)})
+	}
+	return datadogHeaders, true
+}
+
+// propagationCarrier returns the headers from which ConvertTraceContextToSpanContext extracts a SpanContext.
+// The propagator only reads the styles listed in DD_TRACE_PROPAGATION_STYLE_EXTRACT, which don't necessarily
+// include the datadog style, so the trace context is rendered in every supported style.
+func propagationCarrier(traceCtx TraceContext) TraceContext {
+	traceIDLower, err := strconv.ParseUint(traceCtx[traceIDHeader], 10, 64)
+	if err != nil {
+		return traceCtx
+	}
+	parentID, err := strconv.ParseUint(traceCtx[parentIDHeader], 10, 64)
+	if err != nil {
+		return traceCtx
+	}
+	samplingPriority, err := strconv.Atoi(traceCtx[samplingPriorityHeader])
+	if err != nil {
+		samplingPriority = 1
+	}
+	traceIDHex := fmt.Sprintf("%016x", traceIDLower)
+	for _, tag := range strings.Split(traceCtx[tagsHeader], ",") {
+		if key, value, _ := strings.Cut(tag, "="); key == traceIDUpperTag && len(value) == 16 {
+			traceIDHex = value + traceIDHex
+		}
+	}
+	sampled := "0"
+	if samplingPriority > 0 {
+		sampled = "1"
+	}
+
+	carrier := TraceContext{}
+	for k, v := range traceCtx {
+		carrier[k] = v
+	}
+	carrier[traceparentHeader] = fmt.Sprintf("00-%032s-%016x-0%s", traceIDHex, parentID, sampled)
+	carrier[tracestateHeader] = fmt.Sprintf("dd=s:%d", samplingPriority)
+	if origin := traceCtx[originHeader]; origin != "" {
+		carrier[tracestateHeader] += ";o:" + strings.ReplaceAll(origin, "=", "~")
+	}
+	carrier[b3TraceIDHeader] = traceIDHex
+	carrier[b3SpanIDHeader] = fmt.Sprintf("%016x", parentID)
+	carrier[b3SampledHeader] = sampled
+	carrier[b3SingleHeader] = fmt.Sprintf("%s-%016x-%s", traceIDHex, parentID, sampled)
+	return carrier
+}
+
+// parseTraceIDHex parses a hex trace id of the given length into its upper and lower 64 bits.
+// All-zero trace ids are invalid.
+func parseTraceIDHex(traceID string, length int) (upper uint64, lower uint64, ok bool) {
+	if len(traceID) != length {
+		return 0, 0, false
+	}
+	var err error
+	if length > 16 {
+		if upper, err = strconv.ParseUint(traceID[:length-16], 16, 64); err != nil {
+			return 0, 0, false
+		}
+	}
+	if lower, err = strconv.ParseUint(traceID[length-16:], 16, 64); err != nil {
+		return 0, 0, false
+	}
+	return upper, lower, upper != 0 || lower != 0
+}
+
+// formatPropagatedTags formats tags into the comma separated key=value list of the x-datadog-tags header.
+func formatPropagatedTags(tags map[string]string) string {
+	keys := make([]string, 0, len(tags))
+	for k := range tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	pairs := make([]string, len(keys))
+	for i, k := range keys {
+		pairs[i] = k + "=" + tags[k]
+	}
+	return strings.Join(pairs, ",")
+}
@@ -0,0 +1,185 @@
+/*
+ * Unless explicitly stated otherwise all files in this repository are licensed
+ * under the Apache License Version 2.0.
+ *
+ * This product includes software developed at Datadog (https://www.datadoghq.com/).
+ * Copyright 2021 Datadog, Inc.
+ */
+
+package trace
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace"
+	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace/tracer"
+)
+
+func TestParsePropagationStyles(t *testing.T) {
+	assert.Equal(t, []PropagationStyle{PropagationStyleTraceContext, PropagationStyleB3Multi, PropagationStyleB3Single},
+		ParsePropagationStyles(" TraceContext,b3, unknown ,b3 single header"))
+	assert.Equal(t, []PropagationStyle{}, ParsePropagationStyles("none"))
+	assert.Equal(t, []PropagationStyle{}, ParsePropagationStyles(""))
+}
+
+func TestExtractPropagatedHeadersTraceContext(t *testing.T) {
+	headers := map[string]string{
+		"Traceparent": "00-80f198ee56343ba864fe8b2a57d3eff7-00f067aa0ba902b7-01",
+		"Tracestate":  "dd=s:2;o:rum;t.dm:-4,congo=t61rcWkgMzE",
+	}
+
+	result := extractPropagatedHeaders(headers, DefaultPropagationStyleExtract)
+
+	expected := map[string]string{
+		traceIDHeader:          "7277407061855694839",
+		parentIDHeader:         "67667974448284343",
+		samplingPriorityHeader: "2",
+		originHeader:           "rum",
+		tagsHeader:             "_dd.p.dm=-4,_dd.p.tid=80f198ee56343ba8",
+	}
+	assert.Equal(t, expected, result)
+}
+
+func TestExtractPropagatedHeadersTraceContextInconsistentPriority(t *testing.T) {
+	headers := map[string]string{
+		"traceparent": "00-000000000000000000000000000004d2-000000000000162e-00",
+		"tracestate":  "dd=s:2",
+	}
+
+	result := extractPropagatedHeaders(headers, DefaultPropagationStyleExtract)
+
+	assert.Equal(t, "1234", result[traceIDHeader])
+	assert.Equal(t, "5678", result[parentIDHeader])
+	assert.Equal(t, "0", result[samplingPriorityHeader])
+	assert.NotContains(t, result, tagsHeader)
+}
+
+func TestExtractPropagatedHeadersInvalidTraceContext(t *testing.T) {
+	for _, traceparent := range []string{
+		"00-00000000000000000000000000000000-000000000000162e-01",
+		"00-000000000000000000000000000004d2-0000000000000000-01",
+		"ff-000000000000000000000000000004d2-000000000000162e-01",
+		"00-4d2-162e-01",
+	} {
+		result := extractPropagatedHeaders(map[string]string{"traceparent": traceparent}, DefaultPropagationStyleExtract)
+		assert.Equal(t, map[string]string{}, result, traceparent)
+	}
+}
+
+func TestExtractPropagatedHeadersB3Multi(t *testing.T) {
+	headers := map[string]string{
+		"X-B3-TraceId": "80f198ee56343ba864fe8b2a57d3eff7",
+		"X-B3-SpanId":  "00f067aa0ba902b7",
+		"X-B3-Sampled": "1",
+	}
+
+	result := extractPropagatedHeaders(headers, []PropagationStyle{PropagationStyleB3Multi})
+
+	expected := map[string]string{
+		traceIDHeader:          "7277407061855694839",
+		parentIDHeader:         "67667974448284343",
+		samplingPriorityHeader: "1",
+		tagsHeader:             "_dd.p.tid=80f198ee56343ba8",
+	}
+	assert.Equal(t, expected, result)
+}
+
+func TestExtractPropagatedHeadersB3Single(t *testing.T) {
+	headers := map[string]string{
+		"b3": "00000000000004d2-000000000000162e-d",
+	}
+
+	result := extractPropagatedHeaders(headers, []PropagationStyle{PropagationStyleB3Single})
+
+	expected := map[string]string{
+		traceIDHeader:          "1234",
+		parentIDHeader:         "5678",
+		samplingPriorityHeader: userKeep,
+	}
+	assert.Equal(t, expected, result)
+}
+
+func TestExtractPropagatedHeadersPrecedence(t *testing.T) {
+	headers := map[string]string{
+		"x-datadog-trace-id":          "1111",
+		"x-datadog-parent-id":         "2222",
+		"x-datadog-sampling-priority": "1",
+		"traceparent":                 "00-000000000000000000000000000004d2-000000000000162e-01",
+	}
+
+	result := extractPropagatedHeaders(headers, []PropagationStyle{PropagationStyleDatadog, PropagationStyleTraceContext})
+	assert.Equal(t, "1111", result[traceIDHeader])
+	assert.Equal(t, "2222", result[parentIDHeader])
+
+	result = extractPropagatedHeaders(headers, []PropagationStyle{PropagationStyleTraceContext, PropagationStyleDatadog})
+	assert.Equal(t, "1234", result[traceIDHeader])
+	assert.Equal(t, "5678", result[parentIDHeader])
+}
+
+func TestExtractPropagatedHeadersStyleNotEnabled(t *testing.T) {
+	headers := map[string]string{
+		"b3": "00000000000004d2-000000000000162e-1",
+	}
+
+	result := extractPropagatedHeaders(headers, DefaultPropagationStyleExtract)
+	assert.Equal(t, map[string]string{}, result)
+
+	result = extractPropagatedHeaders(headers, []PropagationStyle{})
+	assert.Equal(t, map[string]string{}, result)
+}
+
+func TestWithPropagationStyles(t *testing.T) {
+	extractor := withPropagationStyles(func(ctx context.Context, ev json.RawMessage) map[string]string {
+		return map[string]string{"traceparent": "00-000000000000000000000000000004d2-000000000000162e-01"}
+	}, DefaultPropagationStyleExtract)
+
+	result := extractor(context.Background(), json.RawMessage("{}"))
+
+	expected := map[string]string{
+		traceIDHeader:          "1234",
+		parentIDHeader:         "5678",
+		samplingPriorityHeader: "1",
+	}
+	assert.Equal(t, expected, result)
+}
+
+func TestPropagationCarrier(t *testing.T) {
+	traceCtx := TraceContext{
+		traceIDHeader:          "7277407061855694839",
+		parentIDHeader:         "67667974448284343",
+		samplingPriorityHeader: "2",
+		originHeader:           "rum",
+		tagsHeader:             "_dd.p.tid=80f198ee56343ba8",
+	}
+
+	carrier := propagationCarrier(traceCtx)
+
+	assert.Equal(t, "00-80f198ee56343ba864fe8b2a57d3eff7-00f067aa0ba902b7-01", carrier[traceparentHeader])
+	assert.Equal(t, "dd=s:2;o:rum", carrier[tracestateHeader])
+	assert.Equal(t, "80f198ee56343ba864fe8b2a57d3eff7", carrier[b3TraceIDHeader])
+	assert.Equal(t, "00f067aa0ba902b7", carrier[b3SpanIDHeader])
+	assert.Equal(t, "1", carrier[b3SampledHeader])
+	assert.Equal(t, "80f198ee56343ba864fe8b2a57d3eff7-00f067aa0ba902b7-1", carrier[b3SingleHeader])
+	assert.Equal(t, "7277407061855694839", carrier[traceIDHeader])
+}
+
+func TestPropagationCarrierWithTraceContextPropagator(t *testing.T) {
+	t.Setenv("DD_TRACE_PROPAGATION_STYLE_EXTRACT", "tracecontext")
+	traceContextPropagator := tracer.NewPropagator(&tracer.PropagatorConfig{})
+
+	traceCtx := TraceContext{
+		traceIDHeader:          "1234",
+		parentIDHeader:         "5678",
+		samplingPriorityHeader: "1",
+	}
+
+	spanCtx, err := traceContextPropagator.Extract(tracer.TextMapCarrier(propagationCarrier(traceCtx)))
+
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(1234), spanCtx.TraceID())
+	assert.Equal(t, uint64(5678), spanCtx.SpanID())
+	assert.Equal(t, "000000000000000000000000000004d2", spanCtx.(ddtrace.SpanContextW3C).TraceID128())
+}
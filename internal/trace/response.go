@@ -0,0 +1,89 @@
+/*
+ * Unless explicitly stated otherwise all files in this repository are licensed
+ * under the Apache License Version 2.0.
+ *
+ * This product includes software developed at Datadog (https://www.datadoghq.com/).
+ * Copyright 2021 Datadog, Inc.
+ */
+
+package trace
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/DataDog/datadog-lambda-go/internal/extension"
+	"github.com/DataDog/datadog-lambda-go/internal/trigger"
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// getResponseStatusCode returns the status code of the response of an invocation triggered over HTTP. The responses
+// of other triggers, such as direct invocations and Step Functions, have no status code even if they hold a statusCode
+// field.
+func getResponseStatusCode(ctx context.Context) (int, bool) {
+	if event, ok := trigger.FromContext(ctx); !ok || !event.Source.IsHTTP() {
+		return 0, false
+	}
+	return getStatusCodeFromResponse(ctx.Value(extension.DdLambdaResponse))
+}
+
+// getStatusCodeFromResponse returns the status code of an API Gateway, ALB or Function URL response returned by
+// the handler. Other responses are read as JSON, and have a status code if they hold a numeric statusCode field.
+func getStatusCodeFromResponse(response interface{}) (int, bool) {
+	// Known responses are read directly to avoid marshalling their body
+	switch r := response.(type) {
+	case nil:
+		return 0, false
+	case events.APIGatewayProxyResponse:
+		return r.StatusCode, true
+	case events.APIGatewayV2HTTPResponse:
+		return r.StatusCode, true
+	case events.ALBTargetGroupResponse:
+		return r.StatusCode, true
+	case events.LambdaFunctionURLResponse:
+		return r.StatusCode, true
+	case *events.APIGatewayProxyResponse:
+		if r != nil {
+			return r.StatusCode, true
+		}
+		return 0, false
+	case *events.APIGatewayV2HTTPResponse:
+		if r != nil {
+			return r.StatusCode, true
+		}
+		return 0, false
+	case *events.ALBTargetGroupResponse:
+		if r != nil {
+			return r.StatusCode, true
+		}
+		return 0, false
+	case *events.LambdaFunctionURLResponse:
+		if r != nil {
+			return r.StatusCode, true
+		}
+		return 0, false
+	}
+
+	content, err := json.Marshal(response)
+	if err != nil {
+		return 0, false
+	}
+	statusCodeResponse := struct {
+		StatusCode *int `json:"statusCode"`
+	}{}
+	if err := json.Unmarshal(content, &statusCodeResponse); err != nil || statusCodeResponse.StatusCode == nil {
+		return 0, false
+	}
+	return *statusCodeResponse.StatusCode, true
+}
+
+// statusCodeError returns the error recorded on spans of invocations that respond with a server error,
+// or nil for other status codes.
+func statusCodeError(statusCode int) error {
+	if statusCode < 500 || statusCode > 599 {
+		return nil
+	}
+	return fmt.Errorf("HTTP response status code %d %s", statusCode, http.StatusText(statusCode))
+}
@@ -0,0 +1,54 @@
+/*
+ * Unless explicitly stated otherwise all files in this repository are licensed
+ * under the Apache License Version 2.0.
+ *
+ * This product includes software developed at Datadog (https://www.datadoghq.com/).
+ * Copyright 2021 Datadog, Inc.
+ */
+
+package trace
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetStatusCodeFromResponse(t *testing.T) {
+	testcases := []struct {
+		response   interface{}
+		statusCode int
+		ok         bool
+	}{
+		{events.APIGatewayProxyResponse{StatusCode: 200}, 200, true},
+		{&events.APIGatewayProxyResponse{StatusCode: 404}, 404, true},
+		{events.APIGatewayV2HTTPResponse{StatusCode: 201}, 201, true},
+		{&events.APIGatewayV2HTTPResponse{StatusCode: 500}, 500, true},
+		{events.ALBTargetGroupResponse{StatusCode: 302}, 302, true},
+		{&events.ALBTargetGroupResponse{StatusCode: 503}, 503, true},
+		{events.LambdaFunctionURLResponse{StatusCode: 204}, 204, true},
+		{&events.LambdaFunctionURLResponse{StatusCode: 400}, 400, true},
+		{map[string]interface{}{"statusCode": 418}, 418, true},
+		{(*events.APIGatewayProxyResponse)(nil), 0, false},
+		{map[string]interface{}{"statusCode": "200"}, 0, false},
+		{"hello", 0, false},
+		{5, 0, false},
+		{nil, 0, false},
+	}
+
+	for _, tc := range testcases {
+		t.Run(fmt.Sprintf("%#v", tc.response), func(t *testing.T) {
+			statusCode, ok := getStatusCodeFromResponse(tc.response)
+			assert.Equal(t, tc.ok, ok)
+			assert.Equal(t, tc.statusCode, statusCode)
+		})
+	}
+}
+
+func TestStatusCodeError(t *testing.T) {
+	assert.NoError(t, statusCodeError(200))
+	assert.NoError(t, statusCodeError(404))
+	assert.EqualError(t, statusCodeError(502), "HTTP response status code 502 Bad Gateway")
+}
@@ -0,0 +1,257 @@
+/*
+ * Unless explicitly stated otherwise all files in this repository are licensed
+ * under the Apache License Version 2.0.
+ *
+ * This product includes software developed at Datadog (https://www.datadoghq.com/).
+ * Copyright 2021 Datadog, Inc.
+ */
+
+package trigger
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-lambda-go/lambdacontext"
+)
+
+const (
+	// EventSourceTag is the tag holding the name of the event source of an invocation.
+	EventSourceTag = "function_trigger.event_source"
+	// EventSourceARNTag is the tag holding the ARN of the resource that triggered an invocation.
+	EventSourceARNTag = "function_trigger.event_source_arn"
+)
+
+// EventSource identifies the AWS service that triggered a Lambda invocation.
+type EventSource int
+
+const (
+	// Unknown is used for events that don't come from a recognized AWS service.
+	Unknown EventSource = iota
+	// APIGatewayREST is an API Gateway REST API event (payload format 1.0).
+	APIGatewayREST
+	// APIGatewayHTTP is an API Gateway HTTP API event (payload format 2.0).
+	APIGatewayHTTP
+	// APIGatewayWebsocket is an API Gateway WebSocket API event.
+	APIGatewayWebsocket
+	// ALB is an Application Load Balancer event.
+	ALB
+	// FunctionURL is a Lambda Function URL event.
+	FunctionURL
+	// SQS is an SQS event.
+	SQS
+	// SNS is an SNS event.
+	SNS
+	// EventBridge is an EventBridge (CloudWatch Events) event.
+	EventBridge
+	// Kinesis is a Kinesis Data Streams event.
+	Kinesis
+	// DynamoDB is a DynamoDB Streams event.
+	DynamoDB
+	// S3 is an S3 event notification.
+	S3
+)
+
+var eventSourceNames = map[EventSource]string{
+	APIGatewayREST:      "api-gateway",
+	APIGatewayHTTP:      "api-gateway",
+	APIGatewayWebsocket: "api-gateway",
+	ALB:                 "application-load-balancer",
+	FunctionURL:         "lambda-function-url",
+	SQS:                 "sqs",
+	SNS:                 "sns",
+	EventBridge:         "eventbridge",
+	Kinesis:             "kinesis",
+	DynamoDB:            "dynamodb",
+	S3:                  "s3",
+}
+
+// recordEventSources maps the eventSource field of batch event records to their event source.
+var recordEventSources = map[string]EventSource{
+	"aws:sqs":      SQS,
+	"aws:sns":      SNS,
+	"aws:kinesis":  Kinesis,
+	"aws:dynamodb": DynamoDB,
+	"aws:s3":       S3,
+}
+
+type contextKeytype int
+
+// eventKey is the key used to store the parsed event of an invocation in a context object
+var eventKey = new(contextKeytype)
+
+// Event is the result of probing a raw Lambda event. The wrapper parses the event of an invocation once and stores it
+// in the context, so that trigger tags, trace context extraction and inferred spans share the same source.
+type Event struct {
+	Source EventSource
+	// Tags holds the function_trigger.* tags of the event, and is empty if its source is unknown
+	Tags map[string]string
+}
+
+// eventProbe holds the fields needed to tell event sources apart and to find the ARN of their resource.
+type eventProbe struct {
+	Records []struct {
+		// Matches both "eventSource" and the "EventSource" key used by SNS.
+		EventSource string `json:"eventSource"`
+		// Matches the "eventSourceARN" key of SQS, Kinesis and DynamoDB records.
+		EventSourceARN string `json:"eventSourceARN"`
+		SNS            struct {
+			TopicArn string `json:"TopicArn"`
+		} `json:"Sns"`
+		S3 struct {
+			Bucket struct {
+				Arn string `json:"arn"`
+			} `json:"bucket"`
+		} `json:"s3"`
+	} `json:"Records"`
+	RequestContext *struct {
+		ELB *struct {
+			TargetGroupArn string `json:"targetGroupArn"`
+		} `json:"elb"`
+		HTTP       *json.RawMessage `json:"http"`
+		DomainName string           `json:"domainName"`
+		EventType  string           `json:"eventType"`
+		Stage      string           `json:"stage"`
+		APIID      string           `json:"apiId"`
+	} `json:"requestContext"`
+	DetailType string   `json:"detail-type"`
+	Source     string   `json:"source"`
+	Resources  []string `json:"resources"`
+}
+
+// String returns the name of the event source.
+func (es EventSource) String() string {
+	if name, ok := eventSourceNames[es]; ok {
+		return name
+	}
+	return "unknown"
+}
+
+// IsHTTP reports whether the event source invokes the function over HTTP, and expects a response with a status code.
+func (es EventSource) IsHTTP() bool {
+	switch es {
+	case APIGatewayREST, APIGatewayHTTP, APIGatewayWebsocket, ALB, FunctionURL:
+		return true
+	}
+	return false
+}
+
+// Detect returns the source of a raw Lambda event.
+func Detect(ev json.RawMessage) EventSource {
+	probe := eventProbe{}
+	if err := json.Unmarshal(ev, &probe); err != nil {
+		return Unknown
+	}
+	return probe.detect()
+}
+
+func (probe eventProbe) detect() EventSource {
+	if len(probe.Records) > 0 {
+		return recordEventSources[probe.Records[0].EventSource]
+	}
+
+	if rc := probe.RequestContext; rc != nil {
+		switch {
+		case rc.ELB != nil:
+			return ALB
+		case strings.Contains(rc.DomainName, ".lambda-url."):
+			return FunctionURL
+		case rc.EventType != "":
+			return APIGatewayWebsocket
+		case rc.HTTP != nil:
+			return APIGatewayHTTP
+		case rc.Stage != "":
+			return APIGatewayREST
+		}
+		return Unknown
+	}
+
+	if probe.DetailType != "" && probe.Source != "" {
+		return EventBridge
+	}
+	return Unknown
+}
+
+// Parse returns the source and function_trigger.* tags of a raw Lambda event, unmarshalling it once. The ARN of API
+// Gateway and Function URL triggers is built from the invoked function ARN of the Lambda context.
+func Parse(ctx context.Context, ev json.RawMessage) Event {
+	event := Event{Source: Unknown, Tags: map[string]string{}}
+	probe := eventProbe{}
+	if err := json.Unmarshal(ev, &probe); err != nil {
+		return event
+	}
+	event.Source = probe.detect()
+	if event.Source == Unknown {
+		return event
+	}
+
+	event.Tags[EventSourceTag] = event.Source.String()
+	if arn := probe.eventSourceARN(ctx, event.Source); arn != "" {
+		event.Tags[EventSourceARNTag] = arn
+	}
+	return event
+}
+
+// GetTags returns the function_trigger.* tags of a raw Lambda event, or an empty map if its source is unknown.
+func GetTags(ctx context.Context, ev json.RawMessage) map[string]string {
+	return Parse(ctx, ev).Tags
+}
+
+// ContextWithEvent returns a copy of ctx holding the parsed event of the invocation.
+func ContextWithEvent(ctx context.Context, event Event) context.Context {
+	return context.WithValue(ctx, eventKey, event)
+}
+
+// FromContext returns the event stored in ctx by ContextWithEvent.
+func FromContext(ctx context.Context) (Event, bool) {
+	event, ok := ctx.Value(eventKey).(Event)
+	return event, ok
+}
+
+// TagsFromContext returns the trigger tags of the event stored in ctx by ContextWithEvent.
+func TagsFromContext(ctx context.Context) (map[string]string, bool) {
+	event, ok := FromContext(ctx)
+	return event.Tags, ok
+}
+
+func (probe eventProbe) eventSourceARN(ctx context.Context, source EventSource) string {
+	switch source {
+	case SQS, Kinesis, DynamoDB:
+		return probe.Records[0].EventSourceARN
+	case SNS:
+		return probe.Records[0].SNS.TopicArn
+	case S3:
+		return probe.Records[0].S3.Bucket.Arn
+	case EventBridge:
+		if len(probe.Resources) > 0 {
+			return probe.Resources[0]
+		}
+	case ALB:
+		return probe.RequestContext.ELB.TargetGroupArn
+	case APIGatewayREST, APIGatewayHTTP, APIGatewayWebsocket:
+		if region, _, _, ok := splitFunctionARN(ctx); ok && probe.RequestContext.APIID != "" {
+			return fmt.Sprintf("arn:aws:apigateway:%s::/restapis/%s/stages/%s", region, probe.RequestContext.APIID, probe.RequestContext.Stage)
+		}
+	case FunctionURL:
+		if region, accountID, functionName, ok := splitFunctionARN(ctx); ok {
+			return fmt.Sprintf("arn:aws:lambda:%s:%s:url:%s", region, accountID, functionName)
+		}
+	}
+	return ""
+}
+
+// splitFunctionARN returns the region, account id and function name of the invoked function ARN.
+func splitFunctionARN(ctx context.Context) (region string, accountID string, functionName string, ok bool) {
+	lc, ok := lambdacontext.FromContext(ctx)
+	if !ok {
+		return "", "", "", false
+	}
+	// ex: arn:aws:lambda:us-east-1:123456789012:function:my-function:alias
+	splitArn := strings.Split(lc.InvokedFunctionArn, ":")
+	if len(splitArn) < 7 {
+		return "", "", "", false
+	}
+	return splitArn[3], splitArn[4], splitArn[6], true
+}
@@ -0,0 +1,136 @@
+/*
+ * Unless explicitly stated otherwise all files in this repository are licensed
+ * under the Apache License Version 2.0.
+ *
+ * This product includes software developed at Datadog (https://www.datadoghq.com/).
+ * Copyright 2021 Datadog, Inc.
+ */
+
+package trigger
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/lambdacontext"
+	"github.com/stretchr/testify/assert"
+)
+
+func loadRawJSON(t *testing.T, filename string) json.RawMessage {
+	bytes, err := os.ReadFile(filename)
+	if err != nil {
+		assert.Fail(t, "Couldn't find JSON file")
+		return nil
+	}
+	return json.RawMessage(bytes)
+}
+
+func TestDetect(t *testing.T) {
+	testcases := []struct {
+		filename string
+		expected EventSource
+	}{
+		{"../testdata/apig-event-with-headers.json", APIGatewayREST},
+		{"../testdata/http-api-event.json", APIGatewayHTTP},
+		{"../testdata/websocket-event.json", APIGatewayWebsocket},
+		{"../testdata/alb-event.json", ALB},
+		{"../testdata/function-url-event.json", FunctionURL},
+		{"../testdata/sqs-event-batch.json", SQS},
+		{"../testdata/sns-event.json", SNS},
+		{"../testdata/eventbridge-event.json", EventBridge},
+		{"../testdata/kinesis-event-batch.json", Kinesis},
+		{"../testdata/dynamodb-event.json", DynamoDB},
+		{"../testdata/s3-event.json", S3},
+		{"../testdata/non-proxy-with-headers.json", Unknown},
+		{"../testdata/invalid.json", Unknown},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.filename, func(t *testing.T) {
+			assert.Equal(t, tc.expected, Detect(loadRawJSON(t, tc.filename)))
+		})
+	}
+}
+
+func TestEventSourceString(t *testing.T) {
+	assert.Equal(t, "api-gateway", APIGatewayHTTP.String())
+	assert.Equal(t, "sqs", SQS.String())
+	assert.Equal(t, "unknown", Unknown.String())
+}
+
+func TestEventSourceIsHTTP(t *testing.T) {
+	assert.True(t, APIGatewayREST.IsHTTP())
+	assert.True(t, ALB.IsHTTP())
+	assert.True(t, FunctionURL.IsHTTP())
+	assert.False(t, SQS.IsHTTP())
+	assert.False(t, Unknown.IsHTTP())
+}
+
+func TestGetTags(t *testing.T) {
+	ctx := lambdacontext.NewContext(context.Background(), &lambdacontext.LambdaContext{
+		InvokedFunctionArn: "arn:aws:lambda:us-east-1:123456789012:function:my-function:prod",
+	})
+
+	testcases := []struct {
+		filename  string
+		source    string
+		sourceARN string
+	}{
+		{"../testdata/apig-rest-event.json", "api-gateway", "arn:aws:apigateway:us-east-1::/restapis/abcdef1234/stages/prod"},
+		{"../testdata/http-api-event.json", "api-gateway", "arn:aws:apigateway:us-east-1::/restapis/api-id/stages/$default"},
+		{"../testdata/alb-event.json", "application-load-balancer", "arn:aws:elasticloadbalancing:us-east-1:123456789012:targetgroup/lambda-target/abcdef"},
+		{"../testdata/function-url-event.json", "lambda-function-url", "arn:aws:lambda:us-east-1:123456789012:url:my-function"},
+		{"../testdata/sqs-event-batch.json", "sqs", "arn:aws:sqs:us-east-1:123456789012:my-queue"},
+		{"../testdata/sns-event.json", "sns", "arn:aws:sns:us-east-1:123456789012:my-topic"},
+		{"../testdata/kinesis-event-batch.json", "kinesis", "arn:aws:kinesis:us-east-1:123456789012:stream/my-stream"},
+		{"../testdata/dynamodb-event.json", "dynamodb", "arn:aws:dynamodb:us-east-1:123456789012:table/ExampleTableWithStream/stream/2015-06-27T00:48:05.899"},
+		{"../testdata/s3-event.json", "s3", "arn:aws:s3:::example-bucket"},
+		{"../testdata/eventbridge-event.json", "eventbridge", ""},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.filename, func(t *testing.T) {
+			tags := GetTags(ctx, loadRawJSON(t, tc.filename))
+			assert.Equal(t, tc.source, tags[EventSourceTag])
+			assert.Equal(t, tc.sourceARN, tags[EventSourceARNTag])
+		})
+	}
+}
+
+func TestGetTagsUnknownEvent(t *testing.T) {
+	assert.Empty(t, GetTags(context.Background(), loadRawJSON(t, "../testdata/non-proxy-with-headers.json")))
+	assert.Empty(t, GetTags(context.Background(), loadRawJSON(t, "../testdata/invalid.json")))
+}
+
+func TestGetTagsWithoutLambdaContext(t *testing.T) {
+	tags := GetTags(context.Background(), loadRawJSON(t, "../testdata/http-api-event.json"))
+
+	assert.Equal(t, map[string]string{EventSourceTag: "api-gateway"}, tags)
+}
+
+func TestParse(t *testing.T) {
+	event := Parse(context.Background(), loadRawJSON(t, "../testdata/sqs-event-batch.json"))
+	assert.Equal(t, SQS, event.Source)
+	assert.Equal(t, "sqs", event.Tags[EventSourceTag])
+
+	event = Parse(context.Background(), loadRawJSON(t, "../testdata/invalid.json"))
+	assert.Equal(t, Unknown, event.Source)
+	assert.Empty(t, event.Tags)
+}
+
+func TestContextWithEvent(t *testing.T) {
+	_, ok := FromContext(context.Background())
+	assert.False(t, ok)
+	_, ok = TagsFromContext(context.Background())
+	assert.False(t, ok)
+
+	ctx := ContextWithEvent(context.Background(), Event{Source: SQS, Tags: map[string]string{EventSourceTag: "sqs"}})
+	event, ok := FromContext(ctx)
+	assert.True(t, ok)
+	assert.Equal(t, SQS, event.Source)
+	tags, ok := TagsFromContext(ctx)
+	assert.True(t, ok)
+	assert.Equal(t, "sqs", tags[EventSourceTag])
+}
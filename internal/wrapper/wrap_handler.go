@@ -13,17 +13,29 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"runtime/debug"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/DataDog/datadog-lambda-go/internal/extension"
 	"github.com/DataDog/datadog-lambda-go/internal/logger"
+	"github.com/DataDog/datadog-lambda-go/internal/trigger"
 	"github.com/aws/aws-lambda-go/lambda"
 
 	"reflect"
 )
 
+// DefaultFlushDeadline is the default value of Options.FlushDeadline.
+const DefaultFlushDeadline = 100 * time.Millisecond
+
 var (
-	// CurrentContext is the last create lambda context object.
-	CurrentContext context.Context
+	// ErrImpendingTimeout is the error reported to listeners when the handler is still running at the flush deadline.
+	ErrImpendingTimeout = errors.New("datadog detected an impending timeout")
+
+	// runningContexts lists the contexts of the invocations currently running, in the order they started
+	runningContexts      []*runningContext
+	runningContextsMutex sync.Mutex
 )
 
 type (
@@ -33,70 +45,224 @@ type (
 		HandlerFinished(ctx context.Context, err error)
 	}
 
-	DatadogHandler struct {
-		coldStart bool
-		handler   lambda.Handler
+	// HandlerTimeoutListener is implemented by listeners that report timed out invocations differently. When the
+	// handler is still running at the flush deadline, HandlerTimedOut is called instead of HandlerFinished.
+	HandlerTimeoutListener interface {
+		HandlerTimedOut(ctx context.Context, err error)
+	}
+
+	// PanicError is reported to listeners when the handler panics. It carries the panic value and the stack of the
+	// goroutine that panicked.
+	PanicError struct {
+		value interface{}
+		stack []byte
+	}
+
+	// runningContext is an entry of the list of running invocations. Entries are compared by address, so that
+	// invocations sharing a context are told apart.
+	runningContext struct {
+		ctx context.Context
+	}
+
+	// Options gives options for how a wrapped handler should behave
+	Options struct {
+		// FlushDeadline is how long before the deadline of an invocation the listeners are told that the handler is
+		// timing out, so that they can report the invocation before the runtime stops the function. Zero or a
+		// negative value disables timeout detection.
+		FlushDeadline time.Duration
+	}
+
+	// invoker holds the state shared by the invocations of a wrapped handler, which can run concurrently
+	invoker struct {
+		options   Options
 		listeners []HandlerListener
+		// coldStart is true until the first invocation starts
+		coldStart atomic.Bool
+	}
+
+	DatadogHandler struct {
+		handler lambda.Handler
+		invoker *invoker
 	}
 )
 
+// newInvoker creates the state of a wrapped handler, whose first invocation is a cold start
+func newInvoker(options Options, listeners []HandlerListener) *invoker {
+	inv := &invoker{options: options, listeners: listeners}
+	inv.coldStart.Store(true)
+	return inv
+}
+
 // WrapHandlerWithListeners wraps a lambda handler, and calls listeners before and after every invocation.
-func WrapHandlerWithListeners(handler interface{}, listeners ...HandlerListener) interface{} {
+func WrapHandlerWithListeners(handler interface{}, options Options, listeners ...HandlerListener) interface{} {
 	err := validateHandler(handler)
 	if err != nil {
 		// This wasn't a valid handler function, pass back to AWS SDK to let it handle the error.
 		logger.Error(fmt.Errorf("handler function was in format ddlambda doesn't recognize: %v", err))
 		return handler
 	}
-	coldStart := true
+	inv := newInvoker(options, listeners)
 
 	// Return custom handler, to be called once per invocation
 	return func(ctx context.Context, msg json.RawMessage) (interface{}, error) {
-		//nolint
-		ctx = context.WithValue(ctx, "cold_start", coldStart)
-		for _, listener := range listeners {
-			ctx = listener.HandlerStarted(ctx, msg)
+		return inv.invoke(ctx, msg, func(ctx context.Context) (interface{}, error) {
+			return callHandler(ctx, msg, handler)
+		})
+	}
+}
+
+// WrapTypedHandlerWithListeners wraps a typed lambda handler, and calls listeners before and after every invocation.
+// Unlike WrapHandlerWithListeners, the handler signature is checked at compile time and the handler is called
+// directly instead of through reflection. The returned handler takes the raw event so that listeners see the
+// payload exactly as it was sent, and unmarshals it into TIn before calling the handler.
+func WrapTypedHandlerWithListeners[TIn any, TOut any](handler func(context.Context, TIn) (TOut, error), options Options, listeners ...HandlerListener) func(context.Context, json.RawMessage) (TOut, error) {
+	inv := newInvoker(options, listeners)
+
+	// Return custom handler, to be called once per invocation
+	return func(ctx context.Context, msg json.RawMessage) (TOut, error) {
+		var result TOut
+		_, err := inv.invoke(ctx, msg, func(ctx context.Context) (interface{}, error) {
+			var ev TIn
+			if err := json.Unmarshal(msg, &ev); err != nil {
+				return nil, err
+			}
+			var err error
+			result, err = handler(ctx, ev)
+			return result, err
+		})
+		return result, err
+	}
+}
+
+// invoke runs a single invocation of a handler, calling the listeners before and after it. Only the first invocation
+// to start is a cold start, even when several start concurrently.
+func (inv *invoker) invoke(ctx context.Context, msg json.RawMessage, call func(ctx context.Context) (interface{}, error)) (interface{}, error) {
+	listeners := inv.listeners
+	coldStart := inv.coldStart.CompareAndSwap(true, false)
+	//nolint
+	ctx = context.WithValue(ctx, "cold_start", coldStart)
+	// The event is probed once, and the listeners read its source and trigger tags from the context
+	ctx = trigger.ContextWithEvent(ctx, trigger.Parse(ctx, msg))
+	for _, listener := range listeners {
+		ctx = listener.HandlerStarted(ctx, msg)
+	}
+	removeContext := addRunningContext(ctx)
+	defer removeContext()
+
+	// The listeners are told about the invocation once, either when the handler returns or when it is about to
+	// time out. In the latter case, a handler returning meanwhile waits for the listeners to be done.
+	// Invocations that start with less time left than the flush deadline aren't reported early, as the timer would fire
+	// right away.
+	var finishOnce sync.Once
+	if deadline, ok := ctx.Deadline(); ok && inv.options.FlushDeadline > 0 && time.Until(deadline) > inv.options.FlushDeadline {
+		timer := time.AfterFunc(time.Until(deadline)-inv.options.FlushDeadline, func() {
+			finishOnce.Do(func() {
+				logger.Debug("the handler is about to time out, reporting the invocation")
+				timeOutListeners(ctx, listeners)
+			})
+		})
+		defer timer.Stop()
+	}
+
+	finish := func(result interface{}, err error) {
+		finishOnce.Do(func() {
+			for _, listener := range listeners {
+				ctx = context.WithValue(ctx, extension.DdLambdaResponse, result)
+				listener.HandlerFinished(ctx, err)
+			}
+		})
+	}
+
+	// A panic is reported to the listeners and passed on from the deferred call, before the stack unwinds, so that the
+	// runtime still reports the frames of the handler where it happened
+	defer func() {
+		if value := recover(); value != nil {
+			logger.Debug(fmt.Sprintf("the handler panicked: %v", value))
+			finish(nil, &PanicError{value: value, stack: debug.Stack()})
+			panic(value)
 		}
-		CurrentContext = ctx
-		result, err := callHandler(ctx, msg, handler)
-		for _, listener := range listeners {
-			ctx = context.WithValue(ctx, extension.DdLambdaResponse, result)
-			listener.HandlerFinished(ctx, err)
+	}()
+
+	result, err := call(ctx)
+	finish(result, err)
+	return result, err
+}
+
+// CurrentContext returns the context of the most recently started invocation that is still running, or nil. When
+// invocations run concurrently, it can't tell which one the caller belongs to, and the context passed to the
+// handler should be used instead.
+func CurrentContext() context.Context {
+	runningContextsMutex.Lock()
+	defer runningContextsMutex.Unlock()
+	if len(runningContexts) == 0 {
+		return nil
+	}
+	return runningContexts[len(runningContexts)-1].ctx
+}
+
+// addRunningContext records the context of an invocation until the returned function is called.
+func addRunningContext(ctx context.Context) func() {
+	rc := &runningContext{ctx: ctx}
+	runningContextsMutex.Lock()
+	defer runningContextsMutex.Unlock()
+	runningContexts = append(runningContexts, rc)
+	return func() {
+		runningContextsMutex.Lock()
+		defer runningContextsMutex.Unlock()
+		for i, c := range runningContexts {
+			if c == rc {
+				runningContexts = append(runningContexts[:i], runningContexts[i+1:]...)
+				break
+			}
+		}
+	}
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("panic: %v", e.value)
+}
+
+// Value returns the value the handler panicked with.
+func (e *PanicError) Value() interface{} {
+	return e.value
+}
+
+// Stack returns the stack of the goroutine that panicked, formatted like debug.Stack.
+func (e *PanicError) Stack() []byte {
+	return e.stack
+}
+
+// timeOutListeners reports an invocation whose handler is still running at the flush deadline to the listeners.
+func timeOutListeners(ctx context.Context, listeners []HandlerListener) {
+	for _, listener := range listeners {
+		if timeoutListener, ok := listener.(HandlerTimeoutListener); ok {
+			timeoutListener.HandlerTimedOut(ctx, ErrImpendingTimeout)
+		} else {
+			listener.HandlerFinished(ctx, ErrImpendingTimeout)
 		}
-		coldStart = false
-		CurrentContext = nil
-		return result, err
 	}
 }
 
 func (h *DatadogHandler) Invoke(ctx context.Context, payload []byte) ([]byte, error) {
-	//nolint
-	ctx = context.WithValue(ctx, "cold_start", h.coldStart)
 	msg := json.RawMessage{}
 	err := msg.UnmarshalJSON(payload)
 	if err != nil {
 		logger.Error(fmt.Errorf("couldn't load handler payload: %v", err))
 	}
 
-	for _, listener := range h.listeners {
-		ctx = listener.HandlerStarted(ctx, msg)
-	}
-
-	CurrentContext = ctx
-	result, err := h.handler.Invoke(ctx, payload)
-	for _, listener := range h.listeners {
-		listener.HandlerFinished(ctx, err)
-	}
-	h.coldStart = false
-	CurrentContext = nil
+	var result []byte
+	_, err = h.invoker.invoke(ctx, msg, func(ctx context.Context) (interface{}, error) {
+		var err error
+		result, err = h.handler.Invoke(ctx, payload)
+		return json.RawMessage(result), err
+	})
 	return result, err
 }
 
-func WrapHandlerInterfaceWithListeners(handler lambda.Handler, listeners ...HandlerListener) lambda.Handler {
+func WrapHandlerInterfaceWithListeners(handler lambda.Handler, options Options, listeners ...HandlerListener) lambda.Handler {
 	return &DatadogHandler{
-		coldStart: true,
-		handler:   handler,
-		listeners: listeners,
+		handler: handler,
+		invoker: newInvoker(options, listeners),
 	}
 }
 
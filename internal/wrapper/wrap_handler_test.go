@@ -14,8 +14,14 @@ import (
 	"errors"
 	"os"
 	"reflect"
+	"runtime"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
+	"github.com/DataDog/datadog-lambda-go/internal/extension"
+	"github.com/DataDog/datadog-lambda-go/internal/trigger"
 	"github.com/aws/aws-lambda-go/events"
 	"github.com/aws/aws-lambda-go/lambda"
 	"github.com/stretchr/testify/assert"
@@ -26,6 +32,7 @@ type (
 		inputCTX  context.Context
 		inputMSG  json.RawMessage
 		outputCTX context.Context
+		outputErr error
 	}
 
 	mockNonProxyEvent struct {
@@ -42,6 +49,23 @@ func (mhl *mockHandlerListener) HandlerStarted(ctx context.Context, msg json.Raw
 
 func (mhl *mockHandlerListener) HandlerFinished(ctx context.Context, err error) {
 	mhl.outputCTX = ctx
+	mhl.outputErr = err
+}
+
+type mockTimeoutListener struct {
+	mockHandlerListener
+	finishedCount int
+	timedOutCount int
+	timeoutErr    error
+}
+
+func (mtl *mockTimeoutListener) HandlerFinished(ctx context.Context, err error) {
+	mtl.finishedCount++
+}
+
+func (mtl *mockTimeoutListener) HandlerTimedOut(ctx context.Context, err error) {
+	mtl.timedOutCount++
+	mtl.timeoutErr = err
 }
 
 func runHandlerWithJSON(t *testing.T, filename string, handler interface{}) (*mockHandlerListener, interface{}, error) {
@@ -50,7 +74,7 @@ func runHandlerWithJSON(t *testing.T, filename string, handler interface{}) (*mo
 
 	mhl := mockHandlerListener{}
 
-	wrappedHandler := WrapHandlerWithListeners(handler, &mhl).(func(context.Context, json.RawMessage) (interface{}, error))
+	wrappedHandler := WrapHandlerWithListeners(handler, Options{}, &mhl).(func(context.Context, json.RawMessage) (interface{}, error))
 
 	response, err := wrappedHandler(ctx, *payload)
 	return &mhl, response, err
@@ -65,7 +89,7 @@ func runHandlerInterfaceWithJSON(t *testing.T, filename string, handler lambda.H
 	}
 	mhl := mockHandlerListener{}
 
-	wrappedHandler := WrapHandlerInterfaceWithListeners(handler, &mhl)
+	wrappedHandler := WrapHandlerInterfaceWithListeners(handler, Options{}, &mhl)
 
 	response, err := wrappedHandler.Invoke(ctx, payload)
 	return &mhl, response, err
@@ -162,6 +186,48 @@ func TestWrapHandlerAPIGEvent(t *testing.T) {
 	assert.Equal(t, 5, response)
 }
 
+func TestWrapHandlerTriggerTags(t *testing.T) {
+	handler := func(ctx context.Context, event events.SQSEvent) error {
+		return nil
+	}
+
+	mhl, _, err := runHandlerWithJSON(t, "../testdata/sqs-event-batch.json", handler)
+
+	assert.NoError(t, err)
+	event, ok := trigger.FromContext(mhl.inputCTX)
+	assert.True(t, ok)
+	assert.Equal(t, trigger.SQS, event.Source)
+	tags, ok := trigger.TagsFromContext(mhl.inputCTX)
+	assert.True(t, ok)
+	assert.Equal(t, map[string]string{
+		trigger.EventSourceTag:    "sqs",
+		trigger.EventSourceARNTag: "arn:aws:sqs:us-east-1:123456789012:my-queue",
+	}, tags)
+}
+
+func TestWrapHandlerInterfaceTriggerTags(t *testing.T) {
+	handler := lambda.NewHandler(func(ctx context.Context, event events.SQSEvent) error {
+		return nil
+	})
+
+	mhl, _, err := runHandlerInterfaceWithJSON(t, "../testdata/sqs-event-batch.json", handler)
+
+	assert.NoError(t, err)
+	tags, _ := trigger.TagsFromContext(mhl.inputCTX)
+	assert.Equal(t, "sqs", tags[trigger.EventSourceTag])
+}
+
+func TestWrapHandlerInterfaceResponse(t *testing.T) {
+	handler := lambda.NewHandler(func(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+		return events.APIGatewayProxyResponse{StatusCode: 201}, nil
+	})
+
+	mhl, response, err := runHandlerInterfaceWithJSON(t, "../testdata/apig-event-no-headers.json", handler)
+
+	assert.NoError(t, err)
+	assert.Equal(t, json.RawMessage(response), mhl.outputCTX.Value(extension.DdLambdaResponse))
+}
+
 func TestWrapHandlerNonProxyEvent(t *testing.T) {
 	called := false
 
@@ -201,7 +267,7 @@ func TestWrapHandlerContextArgumentOnly(t *testing.T) {
 	}
 
 	mhl := mockHandlerListener{}
-	wrappedHandler := WrapHandlerWithListeners(handler, &mhl).(func(context.Context, json.RawMessage) (interface{}, error))
+	wrappedHandler := WrapHandlerWithListeners(handler, Options{}, &mhl).(func(context.Context, json.RawMessage) (interface{}, error))
 
 	_, err := wrappedHandler(context.Background(), nil)
 	assert.NoError(t, err)
@@ -277,7 +343,7 @@ func TestWrapHandlerReturnsOriginalHandlerIfInvalid(t *testing.T) {
 	}
 	mhl := mockHandlerListener{}
 
-	wrappedHandler := WrapHandlerWithListeners(handler, &mhl)
+	wrappedHandler := WrapHandlerWithListeners(handler, Options{}, &mhl)
 
 	assert.Equal(t, reflect.ValueOf(handler).Pointer(), reflect.ValueOf(wrappedHandler).Pointer())
 
@@ -298,3 +364,288 @@ func TestWrapHandlerInterfaceWithListeners(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Equal(t, uint8('5'), response[0])
 }
+
+func TestWrapTypedHandlerAPIGEvent(t *testing.T) {
+	called := false
+
+	handler := func(ctx context.Context, request events.APIGatewayProxyRequest) (int, error) {
+		called = true
+		assert.Equal(t, "c6af9ac6-7b61-11e6-9a41-93e8deadbeef", request.RequestContext.RequestID)
+		return 5, nil
+	}
+
+	mhl := mockHandlerListener{}
+	wrappedHandler := WrapTypedHandlerWithListeners(handler, Options{}, &mhl)
+
+	payload := loadRawJSON(t, "../testdata/apig-event-no-headers.json")
+	response, err := wrappedHandler(context.Background(), *payload)
+
+	assert.True(t, called)
+	assert.NoError(t, err)
+	assert.Equal(t, 5, response)
+	assert.Equal(t, *payload, mhl.inputMSG)
+	assert.Equal(t, 5, mhl.outputCTX.Value(extension.DdLambdaResponse))
+}
+
+func TestWrapTypedHandlerInvalidData(t *testing.T) {
+	called := false
+
+	handler := func(ctx context.Context, request mockNonProxyEvent) (int, error) {
+		called = true
+		return 5, nil
+	}
+
+	wrappedHandler := WrapTypedHandlerWithListeners(handler, Options{}, &mockHandlerListener{})
+
+	payload := loadRawJSON(t, "../testdata/invalid.json")
+	response, err := wrappedHandler(context.Background(), *payload)
+
+	assert.False(t, called)
+	assert.Error(t, err)
+	assert.Equal(t, 0, response)
+}
+
+func TestWrapTypedHandlerReturnsError(t *testing.T) {
+	defaultErr := errors.New("Some error")
+
+	handler := func(ctx context.Context, request mockNonProxyEvent) (*mockNonProxyEvent, error) {
+		return &request, defaultErr
+	}
+
+	wrappedHandler := WrapTypedHandlerWithListeners(handler, Options{}, &mockHandlerListener{})
+
+	payload := loadRawJSON(t, "../testdata/non-proxy-no-headers.json")
+	response, err := wrappedHandler(context.Background(), *payload)
+
+	assert.Equal(t, defaultErr, err)
+	assert.Equal(t, "12345678910", response.FakeID)
+}
+
+func TestWrapTypedHandlerColdStart(t *testing.T) {
+	handler := func(ctx context.Context, request json.RawMessage) (interface{}, error) {
+		return nil, nil
+	}
+
+	mhl := mockHandlerListener{}
+	wrappedHandler := WrapTypedHandlerWithListeners(handler, Options{}, &mhl)
+
+	_, _ = wrappedHandler(context.Background(), json.RawMessage("{}"))
+	assert.Equal(t, true, mhl.inputCTX.Value("cold_start"))
+
+	_, _ = wrappedHandler(context.Background(), json.RawMessage("{}"))
+	assert.Equal(t, false, mhl.inputCTX.Value("cold_start"))
+}
+
+// coldStartListener counts the invocations that started cold, and is safe for concurrent invocations
+type coldStartListener struct {
+	coldStarts atomic.Int32
+}
+
+func (l *coldStartListener) HandlerStarted(ctx context.Context, msg json.RawMessage) context.Context {
+	if ctx.Value("cold_start").(bool) {
+		l.coldStarts.Add(1)
+	}
+	return ctx
+}
+
+func (l *coldStartListener) HandlerFinished(ctx context.Context, err error) {}
+
+func TestWrapHandlerColdStartConcurrentInvocations(t *testing.T) {
+	handler := func(ctx context.Context) error {
+		return nil
+	}
+	csl := coldStartListener{}
+	wrappedHandler := WrapHandlerWithListeners(handler, Options{}, &csl).(func(context.Context, json.RawMessage) (interface{}, error))
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, _ = wrappedHandler(context.Background(), json.RawMessage("{}"))
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, int32(1), csl.coldStarts.Load())
+}
+
+func TestWrapHandlerKeepsFlushDeadlinePerWrapper(t *testing.T) {
+	handler := func(ctx context.Context) error {
+		time.Sleep(100 * time.Millisecond)
+		return nil
+	}
+	detecting := mockTimeoutListener{}
+	notDetecting := mockTimeoutListener{}
+	detectingHandler := WrapHandlerWithListeners(handler, Options{FlushDeadline: 100 * time.Millisecond}, &detecting).(func(context.Context, json.RawMessage) (interface{}, error))
+	notDetectingHandler := WrapHandlerWithListeners(handler, Options{}, &notDetecting).(func(context.Context, json.RawMessage) (interface{}, error))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 150*time.Millisecond)
+	defer cancel()
+	_, _ = detectingHandler(ctx, json.RawMessage("{}"))
+	ctx, cancel = context.WithTimeout(context.Background(), 150*time.Millisecond)
+	defer cancel()
+	_, _ = notDetectingHandler(ctx, json.RawMessage("{}"))
+
+	assert.Equal(t, 1, detecting.timedOutCount)
+	assert.Equal(t, 0, notDetecting.timedOutCount)
+	assert.Equal(t, 1, notDetecting.finishedCount)
+}
+
+func TestWrapHandlerTimeout(t *testing.T) {
+	handler := func(ctx context.Context) error {
+		time.Sleep(200 * time.Millisecond)
+		return nil
+	}
+	mtl := mockTimeoutListener{}
+	mhl := mockHandlerListener{}
+	wrappedHandler := WrapHandlerWithListeners(handler, Options{FlushDeadline: 100 * time.Millisecond}, &mtl, &mhl).(func(context.Context, json.RawMessage) (interface{}, error))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 150*time.Millisecond)
+	defer cancel()
+	_, err := wrappedHandler(ctx, json.RawMessage("{}"))
+
+	assert.NoError(t, err)
+	assert.Equal(t, 1, mtl.timedOutCount)
+	assert.Equal(t, 0, mtl.finishedCount)
+	assert.Equal(t, ErrImpendingTimeout, mtl.timeoutErr)
+	// Listeners that don't handle timeouts are finished with the timeout error
+	assert.NotNil(t, mhl.outputCTX)
+	assert.Nil(t, mhl.outputCTX.Value(extension.DdLambdaResponse))
+}
+
+func TestWrapHandlerNoTimeout(t *testing.T) {
+	handler := func(ctx context.Context) error {
+		return nil
+	}
+	mtl := mockTimeoutListener{}
+	wrappedHandler := WrapHandlerWithListeners(handler, Options{FlushDeadline: 100 * time.Millisecond}, &mtl).(func(context.Context, json.RawMessage) (interface{}, error))
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	_, err := wrappedHandler(ctx, json.RawMessage("{}"))
+
+	assert.NoError(t, err)
+	assert.Equal(t, 0, mtl.timedOutCount)
+	assert.Equal(t, 1, mtl.finishedCount)
+}
+
+func TestWrapHandlerDeadlineWithinFlushDeadline(t *testing.T) {
+	handler := func(ctx context.Context) error {
+		time.Sleep(10 * time.Millisecond)
+		return nil
+	}
+	mtl := mockTimeoutListener{}
+	wrappedHandler := WrapHandlerWithListeners(handler, Options{FlushDeadline: 100 * time.Millisecond}, &mtl).(func(context.Context, json.RawMessage) (interface{}, error))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err := wrappedHandler(ctx, json.RawMessage("{}"))
+
+	assert.NoError(t, err)
+	assert.Equal(t, 0, mtl.timedOutCount)
+	assert.Equal(t, 1, mtl.finishedCount)
+}
+
+func TestWrapHandlerPanic(t *testing.T) {
+	handler := func(ctx context.Context) error {
+		panic("oops")
+	}
+	mhl := mockHandlerListener{}
+	wrappedHandler := WrapHandlerWithListeners(handler, Options{}, &mhl).(func(context.Context, json.RawMessage) (interface{}, error))
+
+	assert.PanicsWithValue(t, "oops", func() {
+		_, _ = wrappedHandler(context.Background(), json.RawMessage("{}"))
+	})
+
+	var panicErr *PanicError
+	assert.ErrorAs(t, mhl.outputErr, &panicErr)
+	assert.EqualError(t, panicErr, "panic: oops")
+	assert.Equal(t, "oops", panicErr.Value())
+	assert.Contains(t, string(panicErr.Stack()), "TestWrapHandlerPanic")
+	assert.Nil(t, CurrentContext())
+}
+
+func panickingHandler(ctx context.Context) error {
+	panic("oops")
+}
+
+func TestWrapHandlerPanicKeepsHandlerFrames(t *testing.T) {
+	wrappedHandler := WrapHandlerWithListeners(panickingHandler, Options{}, &mockHandlerListener{}).(func(context.Context, json.RawMessage) (interface{}, error))
+
+	// Like the runtime, take the stack in the recover of the caller
+	var functions []string
+	func() {
+		defer func() {
+			if recover() == nil {
+				return
+			}
+			pcs := make([]uintptr, 64)
+			frames := runtime.CallersFrames(pcs[:runtime.Callers(0, pcs)])
+			for {
+				frame, more := frames.Next()
+				functions = append(functions, frame.Function)
+				if !more {
+					break
+				}
+			}
+		}()
+		_, _ = wrappedHandler(context.Background(), json.RawMessage("{}"))
+	}()
+
+	assert.Contains(t, functions, "github.com/DataDog/datadog-lambda-go/internal/wrapper.panickingHandler")
+}
+
+func TestWrapHandlerInterfacePanic(t *testing.T) {
+	handler := lambda.NewHandler(func(ctx context.Context) error {
+		panic(errors.New("oops"))
+	})
+	mhl := mockHandlerListener{}
+	wrappedHandler := WrapHandlerInterfaceWithListeners(handler, Options{}, &mhl)
+
+	assert.Panics(t, func() {
+		_, _ = wrappedHandler.Invoke(context.Background(), []byte("{}"))
+	})
+
+	var panicErr *PanicError
+	assert.ErrorAs(t, mhl.outputErr, &panicErr)
+	assert.EqualError(t, panicErr, "panic: oops")
+}
+
+func TestCurrentContextConcurrentInvocations(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+	handler := func(ctx context.Context, ev map[string]string) (string, error) {
+		if ev["name"] == "first" {
+			started <- struct{}{}
+			<-release
+		}
+		return ev["name"], nil
+	}
+	wrappedHandler := WrapHandlerWithListeners(handler, Options{}).(func(context.Context, json.RawMessage) (interface{}, error))
+
+	//nolint
+	firstCtx := context.WithValue(context.Background(), "invocation", "first")
+	done := make(chan struct{})
+	go func() {
+		_, _ = wrappedHandler(firstCtx, json.RawMessage(`{"name": "first"}`))
+		close(done)
+	}()
+	<-started
+	assert.Equal(t, "first", CurrentContext().Value("invocation"))
+
+	var secondCtx context.Context
+	secondHandler := WrapHandlerWithListeners(func(ctx context.Context) error {
+		secondCtx = CurrentContext()
+		return nil
+	}, Options{}).(func(context.Context, json.RawMessage) (interface{}, error))
+	//nolint
+	_, _ = secondHandler(context.WithValue(context.Background(), "invocation", "second"), json.RawMessage(`{}`))
+	assert.Equal(t, "second", secondCtx.Value("invocation"))
+
+	// Once the second invocation is done, the first one is the current one again
+	assert.Equal(t, "first", CurrentContext().Value("invocation"))
+	close(release)
+	<-done
+	assert.Nil(t, CurrentContext())
+}